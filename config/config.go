@@ -1,6 +1,7 @@
 package config
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -11,18 +12,114 @@ import (
 
 // Config holds the configuration for the distributed lock
 type Config struct {
-	Redis     RedisConfig     `mapstructure:"redis"`
-	Etcd      EtcdConfig      `mapstructure:"etcd"`
-	MySQL     MySQLConfig     `mapstructure:"mysql"`
-	ZooKeeper ZooKeeperConfig `mapstructure:"zookeeper"`
+	Redis      RedisConfig      `mapstructure:"redis"`
+	Etcd       EtcdConfig       `mapstructure:"etcd"`
+	MySQL      MySQLConfig      `mapstructure:"mysql"`
+	Postgres   PostgresConfig   `mapstructure:"postgres"`
+	File       FileConfig       `mapstructure:"file"`
+	Consul     ConsulConfig     `mapstructure:"consul"`
+	Kubernetes KubernetesConfig `mapstructure:"kubernetes"`
+	ZooKeeper  ZooKeeperConfig  `mapstructure:"zookeeper"`
+	// Instances lists additional named backends, e.g. two Redis clusters
+	Instances []InstanceConfig `mapstructure:"instances"`
+	// Namespaces lists per-tenant views of the backends above
+	Namespaces []NamespaceConfig `mapstructure:"namespaces"`
+	// FaultInjection makes backends fail on purpose, for staging only
+	FaultInjection FaultInjectionConfig `mapstructure:"fault_injection"`
+}
+
+// FaultInjectionConfig wraps registered backends in a fault injector
+type FaultInjectionConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Seed makes probabilistic rules reproducible (0 = random)
+	Seed  int64             `mapstructure:"seed"`
+	Rules []FaultRuleConfig `mapstructure:"rules"`
+}
+
+// FaultRuleConfig is one fault rule for the backend registered as Service
+type FaultRuleConfig struct {
+	// Service is a type, instance or namespace name
+	Service string `mapstructure:"service"`
+	// Op is acquire, release or renew; empty means all
+	Op string `mapstructure:"op"`
+	// Kind is latency, error, timeout, lost-renewal, split-brain or release-error
+	Kind        string  `mapstructure:"kind"`
+	Key         string  `mapstructure:"key"`
+	Probability float64 `mapstructure:"probability"`
+	Calls       []int   `mapstructure:"calls"`
+	Times       int     `mapstructure:"times"`
+	// Latency is the delay of latency faults and the hang of timeout faults;
+	// both kinds need it
+	Latency time.Duration `mapstructure:"latency"`
+	// Error replaces the default injected error message
+	Error string `mapstructure:"error"`
+}
+
+// NamespaceConfig registers a backend under another name with all lock keys
+// prefixed and an optional limit on locks held at once
+type NamespaceConfig struct {
+	Name string `mapstructure:"name"`
+	// Backend is the registered name of the wrapped backend, e.g. "redis" or an instance name
+	Backend   string `mapstructure:"backend"`
+	Prefix    string `mapstructure:"prefix"`
+	Separator string `mapstructure:"separator"`
+	// MaxLocks limits the locks held at once in the namespace across all
+	// processes (0 = unlimited); it needs a Redis, etcd or MySQL backend
+	MaxLocks int `mapstructure:"max_locks"`
+}
+
+// InstanceConfig describes one named backend instance. Only the section
+// matching Type is used; the Enabled flag of that section is ignored.
+type InstanceConfig struct {
+	Name       string           `mapstructure:"name"`
+	Type       string           `mapstructure:"type"`
+	Redis      RedisConfig      `mapstructure:"redis"`
+	Etcd       EtcdConfig       `mapstructure:"etcd"`
+	MySQL      MySQLConfig      `mapstructure:"mysql"`
+	Postgres   PostgresConfig   `mapstructure:"postgres"`
+	File       FileConfig       `mapstructure:"file"`
+	Consul     ConsulConfig     `mapstructure:"consul"`
+	Kubernetes KubernetesConfig `mapstructure:"kubernetes"`
+	ZooKeeper  ZooKeeperConfig  `mapstructure:"zookeeper"`
 }
 
 // RedisConfig holds Redis-specific configuration
 type RedisConfig struct {
-	Enabled  bool     `mapstructure:"enabled"`
+	Enabled bool `mapstructure:"enabled"`
+	// Mode is one of standalone, sentinel or cluster
+	Mode     string   `mapstructure:"mode"`
 	Addrs    []string `mapstructure:"addrs"`
-	Password string   `mapstructure:"password"`
+	Password Secret   `mapstructure:"password"`
 	DB       int      `mapstructure:"db"`
+	// PasswordFile reads the password from a file instead
+	PasswordFile string `mapstructure:"password_file"`
+	// MasterName and SentinelPassword are only used in sentinel mode
+	MasterName           string `mapstructure:"master_name"`
+	SentinelPassword     Secret `mapstructure:"sentinel_password"`
+	SentinelPasswordFile string `mapstructure:"sentinel_password_file"`
+	// Fair mode grants the lock to waiters in arrival order
+	Fair          bool          `mapstructure:"fair"`
+	WaiterTimeout time.Duration `mapstructure:"waiter_timeout"`
+	TLS           TLSConfig     `mapstructure:"tls"`
+	// Pool and timeout settings; zero values keep the client defaults
+	PoolSize     int           `mapstructure:"pool_size"`
+	MinIdleConns int           `mapstructure:"min_idle_conns"`
+	DialTimeout  time.Duration `mapstructure:"dial_timeout"`
+	ReadTimeout  time.Duration `mapstructure:"read_timeout"`
+	WriteTimeout time.Duration `mapstructure:"write_timeout"`
+}
+
+// TLSConfig holds client TLS settings of the Redis and MySQL backends
+type TLSConfig struct {
+	// Enabled turns TLS on with the system CAs; setting any file enables it as well
+	Enabled  bool   `mapstructure:"enabled"`
+	CAFile   string `mapstructure:"ca_file"`
+	CertFile string `mapstructure:"cert_file"`
+	KeyFile  string `mapstructure:"key_file"`
+	// ServerName overrides the name checked against the server certificate
+	ServerName string `mapstructure:"server_name"`
+	// InsecureSkipVerify disables certificate verification (development only)
+	InsecureSkipVerify bool `mapstructure:"insecure_skip_verify"`
 }
 
 // EtcdConfig holds etcd-specific configuration
@@ -30,18 +127,95 @@ type EtcdConfig struct {
 	Enabled     bool          `mapstructure:"enabled"`
 	Endpoints   []string      `mapstructure:"endpoints"`
 	Username    string        `mapstructure:"username"`
-	Password    string        `mapstructure:"password"`
+	Password    Secret        `mapstructure:"password"`
 	DialTimeout time.Duration `mapstructure:"dial_timeout"`
+	// PasswordFile reads the password from a file instead
+	PasswordFile string `mapstructure:"password_file"`
+	// TLS client certificate, key and CA bundle
+	CertFile           string `mapstructure:"cert_file"`
+	KeyFile            string `mapstructure:"key_file"`
+	CAFile             string `mapstructure:"ca_file"`
+	ServerName         string `mapstructure:"server_name"`
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"`
+	// Keepalive pings on the client connection
+	KeepAliveTime    time.Duration `mapstructure:"keepalive_time"`
+	KeepAliveTimeout time.Duration `mapstructure:"keepalive_timeout"`
+	// AutoSyncInterval refreshes the endpoint list from the cluster (0 disables)
+	AutoSyncInterval time.Duration `mapstructure:"auto_sync_interval"`
 }
 
 // MySQLConfig holds MySQL-specific configuration
 type MySQLConfig struct {
 	Enabled  bool   `mapstructure:"enabled"`
 	Username string `mapstructure:"username"`
-	Password string `mapstructure:"password"`
+	Password Secret `mapstructure:"password"`
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	DBName   string `mapstructure:"dbname"`
+	// PasswordFile reads the password from a file instead
+	PasswordFile string `mapstructure:"password_file"`
+	// WaitTimeout is how long one GET_LOCK call blocks, independent of the lock expiration
+	WaitTimeout time.Duration `mapstructure:"wait_timeout"`
+	// LeaseTable and GCInterval are used by the mysql-lease backend
+	LeaseTable string        `mapstructure:"lease_table"`
+	GCInterval time.Duration `mapstructure:"gc_interval"`
+	TLS        TLSConfig     `mapstructure:"tls"`
+	// Params are extra DSN parameters: driver options such as charset or
+	// parseTime, anything else is set as a server variable, e.g. time_zone
+	Params map[string]string `mapstructure:"params"`
+	// Pool and timeout settings; zero values keep the driver defaults
+	PoolSize        int           `mapstructure:"pool_size"`
+	MaxIdleConns    int           `mapstructure:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `mapstructure:"conn_max_lifetime"`
+	DialTimeout     time.Duration `mapstructure:"dial_timeout"`
+	ReadTimeout     time.Duration `mapstructure:"read_timeout"`
+	WriteTimeout    time.Duration `mapstructure:"write_timeout"`
+}
+
+// PostgresConfig holds PostgreSQL-specific configuration
+type PostgresConfig struct {
+	Enabled  bool   `mapstructure:"enabled"`
+	Username string `mapstructure:"username"`
+	Password Secret `mapstructure:"password"`
 	Host     string `mapstructure:"host"`
 	Port     int    `mapstructure:"port"`
 	DBName   string `mapstructure:"dbname"`
+	SSLMode  string `mapstructure:"sslmode"`
+	// PasswordFile reads the password from a file instead
+	PasswordFile string `mapstructure:"password_file"`
+	// TransactionScoped takes transaction-level instead of session-level advisory locks
+	TransactionScoped bool `mapstructure:"transaction_scoped"`
+}
+
+// FileConfig holds configuration of the local file-lock backend
+type FileConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Dir is the directory holding the lock files
+	Dir string `mapstructure:"dir"`
+}
+
+// ConsulConfig holds Consul-specific configuration
+type ConsulConfig struct {
+	Enabled    bool   `mapstructure:"enabled"`
+	Address    string `mapstructure:"address"`
+	Scheme     string `mapstructure:"scheme"`
+	Token      Secret `mapstructure:"token"`
+	Datacenter string `mapstructure:"datacenter"`
+	// TokenFile reads the ACL token from a file instead
+	TokenFile string `mapstructure:"token_file"`
+	// Prefix is the KV path under which lock keys are created
+	Prefix string `mapstructure:"prefix"`
+	// LockDelay is the session lock-delay; 0 keeps the Consul default
+	LockDelay time.Duration `mapstructure:"lock_delay"`
+}
+
+// KubernetesConfig holds configuration of the Kubernetes Lease backend
+type KubernetesConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Namespace holds the Lease objects
+	Namespace string `mapstructure:"namespace"`
+	// Kubeconfig is the kubeconfig file to use; empty means in-cluster config
+	Kubeconfig string `mapstructure:"kubeconfig"`
 }
 
 // ZooKeeperConfig holds ZooKeeper-specific configuration
@@ -50,14 +224,40 @@ type ZooKeeperConfig struct {
 	Servers        []string      `mapstructure:"servers"`
 	SessionTimeout time.Duration `mapstructure:"session_timeout"`
 	Prefix         string        `mapstructure:"prefix"`
+	// WaitTimeout is how long one acquire attempt watches its predecessor
+	WaitTimeout time.Duration `mapstructure:"wait_timeout"`
+	// Username and Password are digest credentials added to the session
+	Username     string `mapstructure:"username"`
+	Password     Secret `mapstructure:"password"`
+	PasswordFile string `mapstructure:"password_file"`
+	// ACL applies to lock nodes and their parent paths
+	ACL []ZooKeeperACLConfig `mapstructure:"acl"`
+	// Chroot is prepended to every path used by the lock
+	Chroot string `mapstructure:"chroot"`
 }
 
-// LoadConfig loads configuration from file and environment variables
+// ZooKeeperACLConfig is one ZooKeeper ACL entry
+type ZooKeeperACLConfig struct {
+	// Scheme is world, digest or ip
+	Scheme string `mapstructure:"scheme"`
+	// ID is user:password for digest and an address or CIDR for ip
+	ID string `mapstructure:"id"`
+	// Perms are any of read, write, create, delete, admin, all
+	Perms []string `mapstructure:"perms"`
+}
+
+// LoadConfig loads configuration from file and environment variables.
+// Secrets may be given as references (${ENV_VAR}, ${file:/path} or a
+// registered SecretProvider) or via their *_file fields; they are resolved
+// before LoadConfig returns.
 func LoadConfig(configPath string) (*Config, error) {
 	// Set default values
 	viper.SetDefault("redis.enabled", false)
+	viper.SetDefault("redis.mode", "standalone")
 	viper.SetDefault("redis.addrs", []string{"localhost:6379"})
 	viper.SetDefault("redis.db", 0)
+	viper.SetDefault("redis.fair", false)
+	viper.SetDefault("redis.waiter_timeout", "5s")
 
 	viper.SetDefault("etcd.enabled", false)
 	viper.SetDefault("etcd.endpoints", []string{"localhost:2379"})
@@ -66,11 +266,33 @@ func LoadConfig(configPath string) (*Config, error) {
 	viper.SetDefault("mysql.enabled", false)
 	viper.SetDefault("mysql.host", "localhost")
 	viper.SetDefault("mysql.port", 3306)
+	viper.SetDefault("mysql.wait_timeout", "0s")
+	viper.SetDefault("mysql.lease_table", "distributed_locks")
+	viper.SetDefault("mysql.gc_interval", "10m")
+
+	viper.SetDefault("postgres.enabled", false)
+	viper.SetDefault("postgres.host", "localhost")
+	viper.SetDefault("postgres.port", 5432)
+	viper.SetDefault("postgres.sslmode", "disable")
+
+	viper.SetDefault("file.enabled", false)
+	viper.SetDefault("file.dir", filepath.Join(os.TempDir(), "distributed-locks"))
+
+	viper.SetDefault("consul.enabled", false)
+	viper.SetDefault("consul.address", "127.0.0.1:8500")
+	viper.SetDefault("consul.scheme", "http")
+	viper.SetDefault("consul.prefix", "locks")
+
+	viper.SetDefault("kubernetes.enabled", false)
+	viper.SetDefault("kubernetes.namespace", "default")
 
 	viper.SetDefault("zookeeper.enabled", false)
 	viper.SetDefault("zookeeper.servers", []string{"localhost:2181"})
 	viper.SetDefault("zookeeper.session_timeout", "10s")
 	viper.SetDefault("zookeeper.prefix", "/locks")
+	viper.SetDefault("zookeeper.wait_timeout", "0s")
+
+	viper.SetDefault("fault_injection.enabled", false)
 
 	// Read from environment variables
 	viper.SetEnvPrefix("DLOCK")
@@ -88,6 +310,9 @@ func LoadConfig(configPath string) (*Config, error) {
 	if err := viper.Unmarshal(&config); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
+	if err := config.resolveSecrets(context.Background()); err != nil {
+		return nil, err
+	}
 
 	return &config, nil
 }
@@ -0,0 +1,244 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// redacted is printed in place of a non-empty secret
+const redacted = "[REDACTED]"
+
+// Secret is a config value that must not show up in logs. It prints as
+// [REDACTED] with fmt, JSON and YAML; use Value to get the plaintext.
+type Secret string
+
+// Value returns the plaintext secret
+func (s Secret) Value() string {
+	return string(s)
+}
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+// GoString keeps the secret out of %#v output
+func (s Secret) GoString() string {
+	return fmt.Sprintf("%q", s.String())
+}
+
+// MarshalText keeps the secret out of encoded output
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// SecretProvider resolves secret references of the form ${scheme:ref}, e.g.
+// ${vault:kv/redis#password}. Providers are selected by Scheme.
+type SecretProvider interface {
+	Scheme() string
+	Resolve(ctx context.Context, ref string) (string, error)
+}
+
+var (
+	secretProvidersMu sync.RWMutex
+	secretProviders   = map[string]SecretProvider{
+		"env":  envSecretProvider{},
+		"file": fileSecretProvider{},
+	}
+)
+
+// RegisterSecretProvider makes p available to LoadConfig. A provider with
+// the same scheme is replaced.
+func RegisterSecretProvider(p SecretProvider) {
+	secretProvidersMu.Lock()
+	defer secretProvidersMu.Unlock()
+	secretProviders[p.Scheme()] = p
+}
+
+// envSecretProvider resolves ${env:NAME}; ${NAME} is a shorthand for it
+type envSecretProvider struct{}
+
+func (envSecretProvider) Scheme() string { return "env" }
+
+func (envSecretProvider) Resolve(ctx context.Context, name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
+
+// fileSecretProvider resolves ${file:/path} to the file content without the
+// trailing newline, as mounted by Docker and Kubernetes secrets
+type fileSecretProvider struct{}
+
+func (fileSecretProvider) Scheme() string { return "file" }
+
+func (fileSecretProvider) Resolve(ctx context.Context, path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// secretRefPattern matches ${NAME}, ${scheme:ref} and the escape $${
+var secretRefPattern = regexp.MustCompile(`\$\$\{|\$\{([^}]+)\}`)
+
+// resolveSecret replaces every reference in value; $${ stands for a literal
+// ${. It is also applied to file paths, so password_file: ${SECRETS_DIR}/redis works.
+func resolveSecret(ctx context.Context, value string) (string, error) {
+	var resolveErr error
+	resolved := secretRefPattern.ReplaceAllStringFunc(value, func(match string) string {
+		if resolveErr != nil {
+			return ""
+		}
+		if match == "$${" {
+			return "${"
+		}
+		ref := match[2 : len(match)-1]
+		scheme, arg, ok := strings.Cut(ref, ":")
+		if !ok {
+			scheme, arg = "env", ref
+		}
+
+		secretProvidersMu.RLock()
+		provider, found := secretProviders[scheme]
+		secretProvidersMu.RUnlock()
+		if !found {
+			resolveErr = fmt.Errorf("unknown secret provider %q", scheme)
+			return ""
+		}
+
+		secret, err := provider.Resolve(ctx, arg)
+		if err != nil {
+			resolveErr = fmt.Errorf("%s provider: %w", scheme, err)
+			return ""
+		}
+		return secret
+	})
+	if resolveErr != nil {
+		return "", resolveErr
+	}
+	return resolved, nil
+}
+
+// resolveSecretField resolves one secret with its optional *_file companion.
+// name is only used in error messages, which never contain the secret.
+func resolveSecretField(ctx context.Context, name string, secret *Secret, file string) error {
+	if file != "" {
+		if *secret != "" {
+			return fmt.Errorf("%s and %s_file are mutually exclusive", name, name)
+		}
+		path, err := resolveSecret(ctx, file)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s_file: %w", name, err)
+		}
+		value, err := fileSecretProvider{}.Resolve(ctx, path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s_file: %w", name, err)
+		}
+		*secret = Secret(value)
+		return nil
+	}
+
+	value, err := resolveSecret(ctx, string(*secret))
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", name, err)
+	}
+	*secret = Secret(value)
+	return nil
+}
+
+// resolveSecrets resolves the secret references of every enabled section and
+// of the instances, so a disabled backend cannot fail loading
+func (c *Config) resolveSecrets(ctx context.Context) error {
+	sections := secretSections{}
+	if c.Redis.Enabled {
+		sections.redis = &c.Redis
+	}
+	if c.Etcd.Enabled {
+		sections.etcd = &c.Etcd
+	}
+	if c.MySQL.Enabled {
+		sections.mysql = &c.MySQL
+	}
+	if c.Postgres.Enabled {
+		sections.postgres = &c.Postgres
+	}
+	if c.Consul.Enabled {
+		sections.consul = &c.Consul
+	}
+	if c.ZooKeeper.Enabled {
+		sections.zookeeper = &c.ZooKeeper
+	}
+	if err := sections.resolveSecrets(ctx, ""); err != nil {
+		return err
+	}
+
+	for i := range c.Instances {
+		inst := &c.Instances[i]
+		sections := secretSections{&inst.Redis, &inst.Etcd, &inst.MySQL, &inst.Postgres, &inst.Consul, &inst.ZooKeeper}
+		if err := sections.resolveSecrets(ctx, "instances."+inst.Name+"."); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// secretSections points at the sections that hold secrets; nil sections are skipped
+type secretSections struct {
+	redis     *RedisConfig
+	etcd      *EtcdConfig
+	mysql     *MySQLConfig
+	postgres  *PostgresConfig
+	consul    *ConsulConfig
+	zookeeper *ZooKeeperConfig
+}
+
+// secretField is one secret with its *_file companion
+type secretField struct {
+	name   string
+	secret *Secret
+	file   string
+}
+
+func (s secretSections) fields() []secretField {
+	var fields []secretField
+	if s.redis != nil {
+		fields = append(fields,
+			secretField{"redis.password", &s.redis.Password, s.redis.PasswordFile},
+			secretField{"redis.sentinel_password", &s.redis.SentinelPassword, s.redis.SentinelPasswordFile})
+	}
+	if s.etcd != nil {
+		fields = append(fields, secretField{"etcd.password", &s.etcd.Password, s.etcd.PasswordFile})
+	}
+	if s.mysql != nil {
+		fields = append(fields, secretField{"mysql.password", &s.mysql.Password, s.mysql.PasswordFile})
+	}
+	if s.postgres != nil {
+		fields = append(fields, secretField{"postgres.password", &s.postgres.Password, s.postgres.PasswordFile})
+	}
+	if s.consul != nil {
+		fields = append(fields, secretField{"consul.token", &s.consul.Token, s.consul.TokenFile})
+	}
+	if s.zookeeper != nil {
+		fields = append(fields, secretField{"zookeeper.password", &s.zookeeper.Password, s.zookeeper.PasswordFile})
+	}
+	return fields
+}
+
+func (s secretSections) resolveSecrets(ctx context.Context, prefix string) error {
+	for _, f := range s.fields() {
+		if err := resolveSecretField(ctx, prefix+f.name, f.secret, f.file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
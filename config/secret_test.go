@@ -0,0 +1,146 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// staticProvider resolves references from a fixed map
+type staticProvider map[string]string
+
+func (staticProvider) Scheme() string { return "static" }
+
+func (p staticProvider) Resolve(ctx context.Context, ref string) (string, error) {
+	value, ok := p[ref]
+	if !ok {
+		return "", fmt.Errorf("no secret %s", ref)
+	}
+	return value, nil
+}
+
+// TestSecretRedacted tests that secrets never show up when printed or encoded
+func TestSecretRedacted(t *testing.T) {
+	cfg := RedisConfig{Password: "hunter2"}
+	for _, out := range []string{
+		fmt.Sprintf("%v", cfg),
+		fmt.Sprintf("%+v", cfg),
+		fmt.Sprintf("%#v", cfg),
+		fmt.Sprint(cfg.Password),
+	} {
+		if strings.Contains(out, "hunter2") {
+			t.Errorf("Expected secret to be redacted, got %s", out)
+		}
+	}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatalf("Failed to marshal config: %v", err)
+	}
+	if strings.Contains(string(data), "hunter2") {
+		t.Errorf("Expected secret to be redacted in JSON, got %s", data)
+	}
+	if cfg.Password.Value() != "hunter2" {
+		t.Errorf("Expected Value to return the plaintext, got %s", cfg.Password.Value())
+	}
+}
+
+// TestLoadConfigResolvesSecrets tests env, file and provider references
+func TestLoadConfigResolvesSecrets(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "mysql"), []byte("from-file\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write secret file: %v", err)
+	}
+	t.Setenv("TEST_REDIS_PASSWORD", "from-env")
+	t.Setenv("TEST_SECRETS_DIR", dir)
+	RegisterSecretProvider(staticProvider{"consul/token": "from-provider"})
+
+	path := filepath.Join(dir, "config.yaml")
+	yaml := `
+redis:
+  enabled: true
+  password: "prefix-${TEST_REDIS_PASSWORD}"
+mysql:
+  enabled: true
+  password_file: "${TEST_SECRETS_DIR}/mysql"
+consul:
+  enabled: true
+  token: "${static:consul/token}"
+postgres:
+  password: "${UNSET_BUT_DISABLED}"
+instances:
+  - name: "redis-payments"
+    type: "redis"
+    redis:
+      password: "${env:TEST_REDIS_PASSWORD}"
+`
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if got := cfg.Redis.Password.Value(); got != "prefix-from-env" {
+		t.Errorf("Expected interpolated env secret, got %s", got)
+	}
+	if got := cfg.MySQL.Password.Value(); got != "from-file" {
+		t.Errorf("Expected secret from file, got %s", got)
+	}
+	if got := cfg.Consul.Token.Value(); got != "from-provider" {
+		t.Errorf("Expected secret from provider, got %s", got)
+	}
+	if got := cfg.Instances[0].Redis.Password.Value(); got != "from-env" {
+		t.Errorf("Expected instance secret to be resolved, got %s", got)
+	}
+}
+
+// TestResolveSecretEscape tests that $${ yields a literal ${
+func TestResolveSecretEscape(t *testing.T) {
+	t.Setenv("TEST_ESCAPE_SECRET", "resolved")
+	cases := map[string]string{
+		"pa$${ss}":                    "pa${ss}",
+		"$${TEST_ESCAPE_SECRET}":      "${TEST_ESCAPE_SECRET}",
+		"$${a}-${TEST_ESCAPE_SECRET}": "${a}-resolved",
+		"cost$$5":                     "cost$$5",
+	}
+	for value, want := range cases {
+		got, err := resolveSecret(context.Background(), value)
+		if err != nil {
+			t.Errorf("resolveSecret(%q) failed: %v", value, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("resolveSecret(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+// TestResolveSecretFieldErrors tests that resolution errors do not leak secrets
+func TestResolveSecretFieldErrors(t *testing.T) {
+	ctx := context.Background()
+
+	secret := Secret("plain")
+	if err := resolveSecretField(ctx, "redis.password", &secret, "/run/secrets/redis"); err == nil {
+		t.Error("Expected error when both password and password_file are set")
+	}
+
+	secret = Secret("${MISSING_TEST_SECRET_VAR}")
+	if err := resolveSecretField(ctx, "redis.password", &secret, ""); err == nil {
+		t.Error("Expected error for an unset environment variable")
+	}
+
+	secret = Secret("${nosuch:ref}")
+	if err := resolveSecretField(ctx, "redis.password", &secret, ""); err == nil {
+		t.Error("Expected error for an unknown provider")
+	}
+
+	secret = Secret("no references")
+	if err := resolveSecretField(ctx, "redis.password", &secret, ""); err != nil || secret != "no references" {
+		t.Errorf("Expected plain value to be kept, got %q, %v", secret.Value(), err)
+	}
+}
@@ -0,0 +1,140 @@
+package distributedlock
+
+import (
+	"context"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// minConsulSessionTTL is the smallest session TTL Consul accepts
+const minConsulSessionTTL = 10 * time.Second
+
+// ConsulLock implements DistributedLockService with Consul sessions. Every
+// held lock owns a session with TTL = lock expiration and behavior=delete,
+// and holds the KV key <prefix>/<key> via acquire=<session>.
+type ConsulLock struct {
+	client    *api.Client
+	prefix    string
+	lockDelay time.Duration
+}
+
+// consulHandle is the Consul-private state of a held lock
+type consulHandle struct {
+	sessionID string
+}
+
+// NewConsulLock creates a Consul lock backend on an existing client. Lock
+// keys live under prefix; lockDelay is the session lock-delay (0 keeps the
+// Consul default of 15s).
+func NewConsulLock(client *api.Client, prefix string, lockDelay time.Duration) *ConsulLock {
+	return &ConsulLock{
+		client:    client,
+		prefix:    strings.Trim(prefix, "/"),
+		lockDelay: lockDelay,
+	}
+}
+
+// consulSessionTTL maps a lock expiration to a session TTL. Consul rejects
+// TTLs below 10s, so shorter expirations are rounded up.
+func consulSessionTTL(expiration time.Duration) string {
+	if expiration < minConsulSessionTTL {
+		expiration = minConsulSessionTTL
+	}
+	return expiration.Round(time.Second).String()
+}
+
+func (c *ConsulLock) AcquireLock(ctx context.Context, lockInfo *DistributedLockInfo) (bool, error) {
+	wopts := (&api.WriteOptions{}).WithContext(ctx)
+	sessionID, _, err := c.client.Session().Create(&api.SessionEntry{
+		Name:      "lock:" + lockInfo.key,
+		TTL:       consulSessionTTL(lockInfo.expiration),
+		Behavior:  api.SessionBehaviorDelete,
+		LockDelay: c.lockDelay,
+	}, wopts)
+	if err != nil {
+		return false, err
+	}
+
+	acquired, _, err := c.client.KV().Acquire(&api.KVPair{
+		Key:     c.lockPath(lockInfo.key),
+		Value:   []byte(lockInfo.value),
+		Session: sessionID,
+	}, wopts)
+	if err != nil || !acquired {
+		c.client.Session().Destroy(sessionID, wopts)
+		return false, err
+	}
+
+	lockInfo.handle = &consulHandle{sessionID: sessionID}
+	return true, nil
+}
+
+func (c *ConsulLock) ReleaseLock(ctx context.Context, lockInfo *DistributedLockInfo) (bool, error) {
+	h, ok := lockInfo.handle.(*consulHandle)
+	if !ok {
+		return false, ErrLockNotHeld
+	}
+	lockInfo.handle = nil
+
+	wopts := (&api.WriteOptions{}).WithContext(ctx)
+	released, _, err := c.client.KV().Release(&api.KVPair{
+		Key:     c.lockPath(lockInfo.key),
+		Session: h.sessionID,
+	}, wopts)
+
+	// The session is useless after release; destroying it also deletes the
+	// key if the release did not go through
+	if _, derr := c.client.Session().Destroy(h.sessionID, wopts); err == nil {
+		err = derr
+	}
+	if err != nil {
+		return false, err
+	}
+	return released, nil
+}
+
+// RenewLock renews the lock's session and checks that the key is still held by it
+func (c *ConsulLock) RenewLock(ctx context.Context, lockInfo *DistributedLockInfo) error {
+	h, ok := lockInfo.handle.(*consulHandle)
+	if !ok {
+		return ErrLockNotHeld
+	}
+
+	entry, _, err := c.client.Session().Renew(h.sessionID, (&api.WriteOptions{}).WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		// The session was invalidated, so Consul has already deleted the key
+		lockInfo.handle = nil
+		return ErrLockLost
+	}
+
+	pair, _, err := c.client.KV().Get(c.lockPath(lockInfo.key), (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	if pair == nil || pair.Session != h.sessionID {
+		lockInfo.handle = nil
+		c.client.Session().Destroy(h.sessionID, nil)
+		return ErrLockLost
+	}
+	return nil
+}
+
+// NewLock returns a lock handle bound to this Consul agent
+func (c *ConsulLock) NewLock(key string, opts ...LockOption) Locker {
+	return newLockHandle(c, key, opts...)
+}
+
+func (c *ConsulLock) BuildServiceType() string {
+	return "consul"
+}
+
+// lockPath returns the KV key for a lock
+func (c *ConsulLock) lockPath(key string) string {
+	return path.Join(c.prefix, key)
+}
@@ -0,0 +1,212 @@
+package distributedlock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// TestConsulSessionTTL tests mapping lock expirations to session TTLs
+func TestConsulSessionTTL(t *testing.T) {
+	cases := map[time.Duration]string{
+		time.Second:                       "10s",
+		30 * time.Second:                  "30s",
+		90*time.Second + time.Millisecond: "1m30s",
+	}
+	for expiration, want := range cases {
+		if got := consulSessionTTL(expiration); got != want {
+			t.Errorf("consulSessionTTL(%v) = %s, want %s", expiration, got, want)
+		}
+	}
+}
+
+// TestConsulLockAgainstDevAgent runs against a local agent started with
+// `consul agent -dev`; set DLOCK_TEST_CONSUL_ADDR (e.g. 127.0.0.1:8500) to enable it
+func TestConsulLockAgainstDevAgent(t *testing.T) {
+	addr := os.Getenv("DLOCK_TEST_CONSUL_ADDR")
+	if addr == "" {
+		t.Skip("DLOCK_TEST_CONSUL_ADDR not set")
+	}
+	ctx := context.Background()
+
+	svc, err := NewDistributedLock(ConsulLockType, ConsulConfig{Address: addr, Prefix: "dlock-test"})
+	if err != nil {
+		t.Fatalf("Failed to create consul lock: %v", err)
+	}
+	lock := svc.(*ConsulLock)
+
+	first := NewDistributedLockInfo("consul-key", "first", 10*time.Second)
+	second := NewDistributedLockInfo("consul-key", "second", 10*time.Second)
+
+	if ok, err := lock.AcquireLock(ctx, first); err != nil || !ok {
+		t.Fatalf("Expected first to acquire the lock, got %v, %v", ok, err)
+	}
+	if ok, err := lock.AcquireLock(ctx, second); err != nil || ok {
+		t.Fatalf("Expected second to be rejected, got %v, %v", ok, err)
+	}
+	if err := lock.RenewLock(ctx, first); err != nil {
+		t.Fatalf("Expected renew to succeed, got %v", err)
+	}
+	if ok, err := lock.ReleaseLock(ctx, first); err != nil || !ok {
+		t.Fatalf("Expected to release the lock, got %v, %v", ok, err)
+	}
+	if ok, err := lock.AcquireLock(ctx, second); err != nil || !ok {
+		t.Fatalf("Expected second to acquire after release, got %v, %v", ok, err)
+	}
+	lock.ReleaseLock(ctx, second)
+}
+
+// fakeConsul serves the session and KV endpoints used by ConsulLock
+type fakeConsul struct {
+	mu       sync.Mutex
+	nextID   int
+	sessions map[string]bool
+	kv       map[string]*api.KVPair
+}
+
+func newFakeConsul(t *testing.T) (*fakeConsul, *ConsulLock) {
+	t.Helper()
+	f := &fakeConsul{sessions: make(map[string]bool), kv: make(map[string]*api.KVPair)}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	client, err := api.NewClient(&api.Config{Address: srv.URL})
+	if err != nil {
+		t.Fatalf("Failed to create consul client: %v", err)
+	}
+	return f, NewConsulLock(client, "locks", 0)
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	reply := func(v interface{}) { json.NewEncoder(w).Encode(v) }
+
+	switch p := r.URL.Path; {
+	case p == "/v1/session/create":
+		f.nextID++
+		id := fmt.Sprintf("session-%d", f.nextID)
+		f.sessions[id] = true
+		reply(map[string]string{"ID": id})
+	case strings.HasPrefix(p, "/v1/session/renew/"):
+		id := strings.TrimPrefix(p, "/v1/session/renew/")
+		if !f.sessions[id] {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		reply([]*api.SessionEntry{{ID: id}})
+	case strings.HasPrefix(p, "/v1/session/destroy/"):
+		f.invalidate(strings.TrimPrefix(p, "/v1/session/destroy/"))
+		reply(true)
+	case strings.HasPrefix(p, "/v1/kv/"):
+		key := strings.TrimPrefix(p, "/v1/kv/")
+		pair := f.kv[key]
+		if r.Method == http.MethodGet {
+			if pair == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			reply([]*api.KVPair{pair})
+			return
+		}
+		if id := r.URL.Query().Get("acquire"); id != "" {
+			if !f.sessions[id] || pair != nil && pair.Session != "" && pair.Session != id {
+				reply(false)
+				return
+			}
+			value, _ := io.ReadAll(r.Body)
+			f.kv[key] = &api.KVPair{Key: key, Value: value, Session: id}
+			reply(true)
+			return
+		}
+		if id := r.URL.Query().Get("release"); id != "" {
+			if pair == nil || pair.Session != id {
+				reply(false)
+				return
+			}
+			pair.Session = ""
+			reply(true)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// invalidate drops a session and, as with behavior=delete, the keys it holds
+func (f *fakeConsul) invalidate(id string) {
+	delete(f.sessions, id)
+	for key, pair := range f.kv {
+		if pair.Session == id {
+			delete(f.kv, key)
+		}
+	}
+}
+
+// TestConsulLockAcquireRenewRelease tests the session and KV calls of a lock's lifecycle
+func TestConsulLockAcquireRenewRelease(t *testing.T) {
+	ctx := context.Background()
+	fake, lock := newFakeConsul(t)
+
+	first := NewDistributedLockInfo("consul-key", "first", 10*time.Second)
+	second := NewDistributedLockInfo("consul-key", "second", 10*time.Second)
+
+	if ok, err := lock.AcquireLock(ctx, first); err != nil || !ok {
+		t.Fatalf("Expected first to acquire the lock, got %v, %v", ok, err)
+	}
+	if pair := fake.kv["locks/consul-key"]; pair == nil || string(pair.Value) != "first" {
+		t.Errorf("Expected key to hold the owner value, got %+v", pair)
+	}
+	if ok, err := lock.AcquireLock(ctx, second); err != nil || ok {
+		t.Fatalf("Expected second to be rejected, got %v, %v", ok, err)
+	}
+	if len(fake.sessions) != 1 {
+		t.Errorf("Expected the rejected session to be destroyed, got %d sessions", len(fake.sessions))
+	}
+	if err := lock.RenewLock(ctx, first); err != nil {
+		t.Fatalf("Expected renew to succeed, got %v", err)
+	}
+	if ok, err := lock.ReleaseLock(ctx, first); err != nil || !ok {
+		t.Fatalf("Expected to release the lock, got %v, %v", ok, err)
+	}
+	if len(fake.sessions) != 0 {
+		t.Errorf("Expected the session to be destroyed on release, got %d sessions", len(fake.sessions))
+	}
+	if ok, err := lock.AcquireLock(ctx, second); err != nil || !ok {
+		t.Fatalf("Expected second to acquire after release, got %v, %v", ok, err)
+	}
+}
+
+// TestConsulLockRenewDetectsLoss tests that invalidated sessions and taken keys report ErrLockLost
+func TestConsulLockRenewDetectsLoss(t *testing.T) {
+	ctx := context.Background()
+	fake, lock := newFakeConsul(t)
+
+	info := NewDistributedLockInfo("consul-key", "owner", 10*time.Second)
+	lock.AcquireLock(ctx, info)
+	fake.mu.Lock()
+	fake.invalidate(info.handle.(*consulHandle).sessionID)
+	fake.mu.Unlock()
+	if err := lock.RenewLock(ctx, info); !errors.Is(err, ErrLockLost) {
+		t.Errorf("Expected ErrLockLost for an invalidated session, got %v", err)
+	}
+
+	lock.AcquireLock(ctx, info)
+	fake.mu.Lock()
+	fake.kv["locks/consul-key"].Session = "someone-else"
+	fake.mu.Unlock()
+	if err := lock.RenewLock(ctx, info); !errors.Is(err, ErrLockLost) {
+		t.Errorf("Expected ErrLockLost for a key held by another session, got %v", err)
+	}
+}
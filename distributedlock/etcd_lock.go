@@ -2,127 +2,176 @@ package distributedlock
 
 import (
 	"context"
+	"fmt"
 	"time"
 
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"go.etcd.io/etcd/client/v3/concurrency"
 )
 
 type EtcdLock struct {
-	session   *concurrency.Session
-	client    *clientv3.Client
-	endpoints []string
+	session *concurrency.Session
+	client  *clientv3.Client
+	pool    *etcdSessionPool
 }
 
-// NewEtcdLock creates a new etcd distributed lock
+// etcdHandle is the etcd-private state of a held lock
+type etcdHandle struct {
+	session *pooledSession
+	mutex   *concurrency.Mutex
+}
+
+// defaultEtcdDialTimeout is used when the client config has no dial timeout
+const defaultEtcdDialTimeout = 5 * time.Second
+
+// NewEtcdLock creates a new etcd distributed lock on the client of session.
+// Locks get their own pooled sessions; session itself is closed by Close.
 func NewEtcdLock(session *concurrency.Session) *EtcdLock {
 	return &EtcdLock{
-		session:   session,
-		endpoints: []string{"localhost:2379"}, // Default endpoint
+		session: session,
+		pool:    newEtcdSessionPool(session.Client()),
 	}
 }
 
 // NewEtcdLockWithEndpoints creates a new etcd distributed lock with custom endpoints
 func NewEtcdLockWithEndpoints(endpoints []string) (*EtcdLock, error) {
-	if len(endpoints) == 0 {
-		endpoints = []string{"localhost:2379"}
-	}
+	return NewEtcdLockWithConfig(clientv3.Config{Endpoints: endpoints})
+}
 
-	client, err := clientv3.New(clientv3.Config{
-		Endpoints:   endpoints,
-		DialTimeout: 5 * time.Second,
-	})
-	if err != nil {
-		return nil, err
+// NewEtcdLockWithConfig creates a new etcd distributed lock from a full
+// client config (TLS, auth, keepalive, endpoint auto-sync)
+func NewEtcdLockWithConfig(cfg clientv3.Config) (*EtcdLock, error) {
+	if len(cfg.Endpoints) == 0 {
+		cfg.Endpoints = []string{"localhost:2379"}
+	}
+	if cfg.DialTimeout == 0 {
+		cfg.DialTimeout = defaultEtcdDialTimeout
 	}
 
-	session, err := concurrency.NewSession(client)
+	client, err := clientv3.New(cfg)
 	if err != nil {
-		client.Close()
-		return nil, err
+		return nil, fmt.Errorf("failed to create etcd client: %v", err)
 	}
 
 	return &EtcdLock{
-		session:   session,
-		client:    client,
-		endpoints: endpoints,
+		client: client,
+		pool:   newEtcdSessionPool(client),
 	}, nil
 }
 
-// AcquireLock attempts to acquire a distributed lock
+// AcquireLock attempts to acquire a distributed lock. Locks with the same
+// expiration share a session, whose lease TTL is that expiration.
 func (e *EtcdLock) AcquireLock(ctx context.Context, lockInfo *DistributedLockInfo) (bool, error) {
-	if lockInfo.etcdSession == nil {
-		// 使用已有的 session 或创建新的
-		if e.session != nil {
-			lockInfo.etcdSession = e.session
-		} else {
-			// Create a new session if one doesn't exist
-			client, err := clientv3.New(clientv3.Config{
-				Endpoints:   e.endpoints,
-				DialTimeout: 5 * time.Second,
-			})
-			if err != nil {
-				return false, err
-			}
-
-			session, err := concurrency.NewSession(client)
-			if err != nil {
-				client.Close()
-				return false, err
-			}
-			lockInfo.etcdSession = session
-		}
+	session, err := e.pool.get(ctx, etcdLeaseTTL(lockInfo.expiration), lockInfo.key)
+	if err != nil {
+		return false, err
 	}
 
 	// Create a new mutex for this lock
-	mutex := concurrency.NewMutex(lockInfo.etcdSession, lockInfo.key)
-	lockInfo.etcdMutex = mutex
+	mutex := concurrency.NewMutex(session.session, lockInfo.key)
 
 	// Try to acquire the lock
-	err := mutex.TryLock(ctx)
+	err = mutex.TryLock(ctx)
 	if err != nil {
+		e.pool.put(session, lockInfo.key)
 		if err == concurrency.ErrLocked {
 			return false, nil
 		}
 		return false, err
 	}
 
+	lockInfo.handle = &etcdHandle{session: session, mutex: mutex}
 	return true, nil
 }
 
 // ReleaseLock releases the distributed lock
 func (e *EtcdLock) ReleaseLock(ctx context.Context, lockInfo *DistributedLockInfo) (bool, error) {
-	if lockInfo.etcdMutex == nil {
+	h, ok := lockInfo.handle.(*etcdHandle)
+	if !ok {
 		return false, ErrLockNotHeld
 	}
 
-	// Unlock the mutex
-	err := lockInfo.etcdMutex.Unlock(ctx)
+	// Unlock the mutex; the shared lease must not keep our key alive
+	err := h.mutex.Unlock(ctx)
 	if err != nil {
 		return false, err
 	}
 
-	// Clean up the session if it exists
-	if lockInfo.etcdSession != nil {
-		err = lockInfo.etcdSession.Close()
-		if err != nil {
-			return false, err
-		}
-		lockInfo.etcdSession = nil
+	lockInfo.handle = nil
+	if err := e.pool.put(h.session, lockInfo.key); err != nil {
+		return false, err
 	}
-
-	lockInfo.etcdMutex = nil
 	return true, nil
 }
 
-// RenewLock implements lease keep-alive for the lock
+// RenewLock refreshes the lock's lease once and checks that the lease and
+// the lock key still exist. The session keeps the lease alive in the
+// background as well; if that keepalive has stopped, the lock is lost.
 func (e *EtcdLock) RenewLock(ctx context.Context, lockInfo *DistributedLockInfo) error {
-	// etcd's concurrency.Mutex handles lease renewal automatically
-	// as long as the session is kept alive
+	h, ok := lockInfo.handle.(*etcdHandle)
+	if !ok {
+		return ErrLockNotHeld
+	}
+	if h.session.expired() {
+		return ErrLockLost
+	}
+
+	session := h.session.session
+	client := session.Client()
+	resp, err := client.KeepAliveOnce(ctx, session.Lease())
+	if err == rpctypes.ErrLeaseNotFound {
+		return ErrLockLost
+	}
+	if err != nil {
+		return err
+	}
+	if resp.TTL <= 0 {
+		return ErrLockLost
+	}
+
+	// The key may have been deleted behind our back even with a live lease
+	get, err := client.Get(ctx, h.mutex.Key())
+	if err != nil {
+		return err
+	}
+	if len(get.Kvs) == 0 || get.Kvs[0].Lease != int64(session.Lease()) {
+		return ErrLockLost
+	}
 	return nil
 }
 
+// etcdLeaseTTL converts a lock expiration into whole lease seconds (at least 1)
+func etcdLeaseTTL(expiration time.Duration) int {
+	return int(leaseSeconds(expiration))
+}
+
+// NewLock returns a lock handle bound to this etcd instance
+func (e *EtcdLock) NewLock(key string, opts ...LockOption) Locker {
+	return newLockHandle(e, key, opts...)
+}
+
 // BuildServiceType returns the type of lock service
 func (e *EtcdLock) BuildServiceType() string {
 	return "etcd"
 }
+
+// Close closes the pooled sessions, the session passed to NewEtcdLock and
+// the etcd client owned by this lock
+func (e *EtcdLock) Close() error {
+	var err error
+	if e.pool != nil {
+		err = e.pool.close()
+	}
+	if e.session != nil {
+		if cerr := e.session.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if e.client != nil {
+		if cerr := e.client.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,151 @@
+package distributedlock
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+// newTestEtcd starts an embedded single-node etcd and returns a client for it
+func newTestEtcd(t *testing.T) *clientv3.Client {
+	t.Helper()
+	freeURL := func() url.URL {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to find a free port: %v", err)
+		}
+		defer l.Close()
+		return url.URL{Scheme: "http", Host: l.Addr().String()}
+	}
+
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LogLevel = "error"
+	clientURL, peerURL := freeURL(), freeURL()
+	cfg.ListenClientUrls = []url.URL{clientURL}
+	cfg.AdvertiseClientUrls = []url.URL{clientURL}
+	cfg.ListenPeerUrls = []url.URL{peerURL}
+	cfg.AdvertisePeerUrls = []url.URL{peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+
+	server, err := embed.StartEtcd(cfg)
+	if err != nil {
+		t.Fatalf("Failed to start etcd: %v", err)
+	}
+	t.Cleanup(server.Close)
+	select {
+	case <-server.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for etcd to start")
+	}
+
+	client, err := clientv3.New(clientv3.Config{Endpoints: []string{clientURL.String()}, DialTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("Failed to create etcd client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// writeTestCA writes a self-signed CA certificate and returns its path
+func writeTestCA(t *testing.T) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+	return path
+}
+
+// TestEtcdClientConfig tests converting EtcdConfig into a client config
+func TestEtcdClientConfig(t *testing.T) {
+	cfg, err := EtcdConfig{
+		Endpoints:        []string{"etcd-0:2379", "etcd-1:2379"},
+		Username:         "locker",
+		Password:         "secret",
+		DialTimeout:      3 * time.Second,
+		KeepAliveTime:    10 * time.Second,
+		KeepAliveTimeout: 3 * time.Second,
+		AutoSyncInterval: time.Minute,
+	}.clientConfig()
+	if err != nil {
+		t.Fatalf("Failed to build client config: %v", err)
+	}
+	if cfg.TLS != nil {
+		t.Error("Expected no TLS without TLS settings")
+	}
+	if len(cfg.Endpoints) != 2 || cfg.Username != "locker" || cfg.Password != "secret" {
+		t.Errorf("Expected endpoints and credentials to be kept, got %+v", cfg)
+	}
+	if cfg.DialTimeout != 3*time.Second || cfg.DialKeepAliveTime != 10*time.Second ||
+		cfg.DialKeepAliveTimeout != 3*time.Second || cfg.AutoSyncInterval != time.Minute {
+		t.Errorf("Expected timeouts to be kept, got %+v", cfg)
+	}
+
+	cfg, err = EtcdConfig{CAFile: writeTestCA(t), ServerName: "etcd.internal"}.clientConfig()
+	if err != nil {
+		t.Fatalf("Failed to build TLS client config: %v", err)
+	}
+	if cfg.TLS == nil || cfg.TLS.RootCAs == nil || cfg.TLS.ServerName != "etcd.internal" {
+		t.Errorf("Expected TLS with CA pool and server name, got %+v", cfg.TLS)
+	}
+
+	if _, err := (EtcdConfig{CAFile: filepath.Join(t.TempDir(), "missing.pem")}).clientConfig(); err == nil {
+		t.Error("Expected error for a missing CA file")
+	}
+	if _, err := (EtcdConfig{CertFile: "client.pem"}).clientConfig(); err == nil {
+		t.Error("Expected error for a cert file without key file")
+	}
+}
+
+// TestEtcdLeaseTTL tests that lease TTLs follow the lock expiration
+func TestEtcdLeaseTTL(t *testing.T) {
+	cases := map[time.Duration]int{
+		30 * time.Second:        30,
+		1500 * time.Millisecond: 2,
+		100 * time.Millisecond:  1,
+	}
+	for expiration, want := range cases {
+		if got := etcdLeaseTTL(expiration); got != want {
+			t.Errorf("Expected TTL %d for %v, got %d", want, expiration, got)
+		}
+	}
+
+	lock := &EtcdLock{}
+	lockInfo := NewDistributedLockInfo("orders", "owner", 30*time.Second)
+	if err := lock.RenewLock(context.Background(), lockInfo); !errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("Expected ErrLockNotHeld without a held lock, got %v", err)
+	}
+}
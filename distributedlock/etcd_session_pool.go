@@ -0,0 +1,155 @@
+package distributedlock
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+// errEtcdPoolClosed is returned when acquiring on a closed etcd backend
+var errEtcdPoolClosed = errors.New("etcd lock service is closed")
+
+// etcdSessionPool shares etcd sessions between locks. Locks with the same
+// TTL share one lease, so a single keepalive covers all of them. A session
+// is closed (revoking its lease) when its last lock is released, and a
+// session whose keepalive has stopped is replaced by a fresh one for new
+// locks while the locks on it are reported lost.
+type etcdSessionPool struct {
+	client *clientv3.Client
+
+	mu     sync.Mutex
+	groups map[int][]*pooledSession
+	closed bool
+}
+
+// pooledSession is a session with the keys its locks use. concurrency.Mutex
+// names its key after the lease, so one session can hold each key once.
+type pooledSession struct {
+	session *concurrency.Session
+	ttl     int
+	keys    map[string]struct{}
+}
+
+func newEtcdSessionPool(client *clientv3.Client) *etcdSessionPool {
+	return &etcdSessionPool{client: client, groups: make(map[int][]*pooledSession)}
+}
+
+// get returns a live session with the given TTL that does not hold key yet
+// and reserves key on it. A new session is created outside the pool's lock,
+// so a slow grant only holds up its own caller; ctx bounds the grant.
+func (p *etcdSessionPool) get(ctx context.Context, ttl int, key string) (*pooledSession, error) {
+	p.mu.Lock()
+	found, err := p.reserve(ttl, key)
+	p.mu.Unlock()
+	if err != nil || found != nil {
+		return found, err
+	}
+
+	lease, err := p.client.Grant(ctx, int64(ttl))
+	if err != nil {
+		return nil, err
+	}
+	// The keepalive must outlive the acquire call that created the session
+	session, err := concurrency.NewSession(p.client, concurrency.WithLease(lease.ID),
+		concurrency.WithContext(context.WithoutCancel(ctx)))
+	if err != nil {
+		p.client.Revoke(context.WithoutCancel(ctx), lease.ID)
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		session.Close()
+		return nil, errEtcdPoolClosed
+	}
+	found = &pooledSession{session: session, ttl: ttl, keys: map[string]struct{}{key: {}}}
+	p.groups[ttl] = append(p.groups[ttl], found)
+	return found, nil
+}
+
+// reserve reserves key on a live pooled session with the given TTL and
+// returns it, or nil if none has room. Must be called with p.mu held.
+func (p *etcdSessionPool) reserve(ttl int, key string) (*pooledSession, error) {
+	if p.closed {
+		return nil, errEtcdPoolClosed
+	}
+
+	live := p.groups[ttl][:0]
+	var found *pooledSession
+	for _, ps := range p.groups[ttl] {
+		if ps.expired() {
+			// Its locks find out on their next renewal; new locks go elsewhere
+			continue
+		}
+		live = append(live, ps)
+		if _, used := ps.keys[key]; !used && found == nil {
+			found = ps
+		}
+	}
+	p.groups[ttl] = live
+
+	if found != nil {
+		found.keys[key] = struct{}{}
+	}
+	return found, nil
+}
+
+// put releases key on ps and closes the session once no lock uses it
+func (p *etcdSessionPool) put(ps *pooledSession, key string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(ps.keys, key)
+	if len(ps.keys) > 0 {
+		return nil
+	}
+
+	group := p.groups[ps.ttl]
+	for i, other := range group {
+		if other == ps {
+			p.groups[ps.ttl] = append(group[:i], group[i+1:]...)
+			break
+		}
+	}
+	if len(p.groups[ps.ttl]) == 0 {
+		delete(p.groups, ps.ttl)
+	}
+	// Revoking an expired lease fails, but there is nothing left to clean up
+	expired := ps.expired()
+	if err := ps.session.Close(); err != nil && !expired {
+		return err
+	}
+	return nil
+}
+
+// close closes every pooled session
+func (p *etcdSessionPool) close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var err error
+	for ttl, group := range p.groups {
+		for _, ps := range group {
+			if cerr := ps.session.Close(); err == nil {
+				err = cerr
+			}
+		}
+		delete(p.groups, ttl)
+	}
+	p.closed = true
+	return err
+}
+
+// expired reports whether the session's keepalive has stopped
+func (ps *pooledSession) expired() bool {
+	select {
+	case <-ps.session.Done():
+		return true
+	default:
+		return false
+	}
+}
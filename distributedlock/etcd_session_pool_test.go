@@ -0,0 +1,139 @@
+package distributedlock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// leaseAlive reports whether the lease of ps still exists on the server
+func leaseAlive(t *testing.T, client *clientv3.Client, ps *pooledSession) bool {
+	t.Helper()
+	resp, err := client.TimeToLive(context.Background(), ps.session.Lease())
+	if err != nil {
+		t.Fatalf("Failed to read lease: %v", err)
+	}
+	return resp.TTL > 0
+}
+
+// TestEtcdSessionPoolSharing tests which locks share a session
+func TestEtcdSessionPoolSharing(t *testing.T) {
+	ctx := context.Background()
+	client := newTestEtcd(t)
+	pool := newEtcdSessionPool(client)
+	defer pool.close()
+
+	a, err := pool.get(ctx, 10, "a")
+	if err != nil {
+		t.Fatalf("Failed to get session: %v", err)
+	}
+	b, _ := pool.get(ctx, 10, "b")
+	if a != b {
+		t.Error("Expected locks with the same TTL to share a session")
+	}
+	again, _ := pool.get(ctx, 10, "a")
+	if again == a {
+		t.Error("Expected a second lock on the same key to get another session")
+	}
+	other, _ := pool.get(ctx, 20, "c")
+	if other == a || other == again {
+		t.Error("Expected a different TTL to get its own session")
+	}
+}
+
+// TestEtcdSessionPoolRefcount tests that a session lives until its last lock is put back
+func TestEtcdSessionPoolRefcount(t *testing.T) {
+	ctx := context.Background()
+	client := newTestEtcd(t)
+	pool := newEtcdSessionPool(client)
+	defer pool.close()
+
+	ps, _ := pool.get(ctx, 10, "a")
+	pool.get(ctx, 10, "b")
+
+	if err := pool.put(ps, "a"); err != nil {
+		t.Fatalf("Failed to put session: %v", err)
+	}
+	if !leaseAlive(t, client, ps) {
+		t.Fatal("Expected the session to stay while a lock uses it")
+	}
+	if err := pool.put(ps, "b"); err != nil {
+		t.Fatalf("Failed to put session: %v", err)
+	}
+	if leaseAlive(t, client, ps) {
+		t.Error("Expected the lease to be revoked with the last lock")
+	}
+	if next, _ := pool.get(ctx, 10, "a"); next == ps {
+		t.Error("Expected a closed session not to be handed out again")
+	}
+}
+
+// TestEtcdSessionPoolReplacesExpired tests that new locks get a fresh session
+// once the shared one has expired
+func TestEtcdSessionPoolReplacesExpired(t *testing.T) {
+	ctx := context.Background()
+	client := newTestEtcd(t)
+	pool := newEtcdSessionPool(client)
+	defer pool.close()
+
+	ps, _ := pool.get(ctx, 10, "a")
+	if _, err := client.Revoke(ctx, ps.session.Lease()); err != nil {
+		t.Fatalf("Failed to revoke lease: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !ps.expired() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !ps.expired() {
+		t.Fatal("Expected the session to notice its lease is gone")
+	}
+
+	fresh, err := pool.get(ctx, 10, "b")
+	if err != nil {
+		t.Fatalf("Failed to get session: %v", err)
+	}
+	if fresh == ps || !leaseAlive(t, client, fresh) {
+		t.Error("Expected a fresh live session")
+	}
+	if err := pool.put(ps, "a"); err != nil {
+		t.Errorf("Expected putting back an expired session to succeed, got %v", err)
+	}
+}
+
+// TestEtcdSessionPoolClose tests that close revokes every session and refuses new ones
+func TestEtcdSessionPoolClose(t *testing.T) {
+	ctx := context.Background()
+	client := newTestEtcd(t)
+	pool := newEtcdSessionPool(client)
+
+	a, _ := pool.get(ctx, 10, "a")
+	b, _ := pool.get(ctx, 20, "b")
+	if err := pool.close(); err != nil {
+		t.Fatalf("Failed to close pool: %v", err)
+	}
+	if leaseAlive(t, client, a) || leaseAlive(t, client, b) {
+		t.Error("Expected close to revoke every lease")
+	}
+	if _, err := pool.get(ctx, 10, "c"); !errors.Is(err, errEtcdPoolClosed) {
+		t.Errorf("Expected errEtcdPoolClosed, got %v", err)
+	}
+}
+
+// TestEtcdSessionPoolCanceledGrant tests that a canceled caller does not get a session
+func TestEtcdSessionPoolCanceledGrant(t *testing.T) {
+	client := newTestEtcd(t)
+	pool := newEtcdSessionPool(client)
+	defer pool.close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := pool.get(ctx, 10, "a"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if _, err := pool.get(context.Background(), 10, "a"); err != nil {
+		t.Errorf("Expected the pool to stay usable, got %v", err)
+	}
+}
@@ -6,11 +6,16 @@ import (
 	"time"
 )
 
+// AcquireLock acquires the lock on the service registered as serviceType
 func (dl *DistributedLockInfo) AcquireLock(ctx context.Context, serviceType string) (bool, error) {
 	service, err := GetService(serviceType)
 	if err != nil {
 		return false, err
 	}
+	return dl.acquire(ctx, service)
+}
+
+func (dl *DistributedLockInfo) acquire(ctx context.Context, service DistributedLockService) (bool, error) {
 	dl.mutex.Lock()
 	defer dl.mutex.Unlock()
 	if dl.locked {
@@ -32,6 +37,7 @@ func (dl *DistributedLockInfo) AcquireLock(ctx context.Context, serviceType stri
 			select {
 			case <-ctx.Done():
 				timer.Stop()
+				dl.cancelWait(ctx, service)
 				return false, ctx.Err()
 			case <-timer.C:
 				// Fall-through when the delay timer completes.
@@ -53,40 +59,88 @@ func (dl *DistributedLockInfo) AcquireLock(ctx context.Context, serviceType stri
 		timer.Stop()
 	}
 
+	if !acquireLock {
+		dl.cancelWait(ctx, service)
+	}
 	if lockErr != nil {
 		return acquireLock, lockErr
 	}
 	if acquireLock {
 		dl.locked = true
-		go dl.startWatchdog(ctx, serviceType)
+		// 上一次释放会关闭 stopChan，重新加锁时需要新的
+		select {
+		case <-dl.stopChan:
+			dl.stopChan = make(chan struct{})
+		default:
+		}
+		dl.lost = make(chan struct{})
+		var wake <-chan struct{}
+		if notifier, ok := dl.handle.(lossNotifier); ok {
+			wake = notifier.lostSignal()
+		}
+		go dl.startWatchdog(ctx, service, dl.stopChan, dl.lost, wake)
 	}
 
 	return acquireLock, nil
 }
 
-// 启动Watch Dog自动续期
-func (dl *DistributedLockInfo) startWatchdog(ctx context.Context, serviceType string) {
+// waitCanceler is implemented by services that keep server-side state for waiters
+type waitCanceler interface {
+	CancelWait(ctx context.Context, lockInfo *DistributedLockInfo) error
+}
+
+// lossNotifier is implemented by backend handles that learn about a lost
+// lock before the next renewal, e.g. from session events
+type lossNotifier interface {
+	// lostSignal returns a channel closed once the lock is known to be lost
+	lostSignal() <-chan struct{}
+}
+
+// cancelWait lets the service forget about a waiter that gave up
+func (dl *DistributedLockInfo) cancelWait(ctx context.Context, service DistributedLockService) {
+	canceler, ok := service.(waitCanceler)
+	if !ok {
+		return
+	}
+	if err := canceler.CancelWait(context.WithoutCancel(ctx), dl); err != nil {
+		log.Printf("Lock %s failed to leave wait queue: %v\n", dl.key, err)
+	}
+}
+
+// 启动Watch Dog自动续期; a closed wake channel triggers an immediate renewal
+// so a loss the backend already knows about reaches the holder right away
+func (dl *DistributedLockInfo) startWatchdog(ctx context.Context, service DistributedLockService, stopChan, lost chan struct{}, wake <-chan struct{}) {
 	ticker := time.NewTicker(dl.expiration / 2) // 在过期时间的一半进行续期
 	defer ticker.Stop()
+	renew := func() bool {
+		dl.mutex.Lock()
+		defer dl.mutex.Unlock()
+		if !dl.locked {
+			return false
+		}
+
+		err := service.RenewLock(ctx, dl)
+		if err != nil {
+			log.Printf("WatchDog: %v failed to renew lock: %v\n", dl.key, err)
+			dl.locked = false
+			close(lost)
+			return false
+		}
+		log.Printf("WatchDog: successfully renewed lock for key: %s\n", dl.key)
+		return true
+	}
 	for {
 		select {
 		case <-ticker.C:
-			dl.mutex.Lock()
-			if !dl.locked {
-				dl.mutex.Unlock()
+			if !renew() {
 				return
 			}
-
-			err := dl.renewLock(ctx, serviceType)
-			if err != nil {
-				log.Printf("WatchDog: %v failed to renew lock: %v\n", dl.key, err)
-				dl.locked = false
-				dl.mutex.Unlock()
+		case <-wake:
+			wake = nil
+			if !renew() {
 				return
 			}
-			log.Printf("WatchDog: successfully renewed lock for key: %s\n", dl.key)
-			dl.mutex.Unlock()
-		case <-dl.stopChan:
+		case <-stopChan:
 			return
 		case <-ctx.Done():
 			return
@@ -94,19 +148,16 @@ func (dl *DistributedLockInfo) startWatchdog(ctx context.Context, serviceType st
 	}
 }
 
-func (dl *DistributedLockInfo) renewLock(ctx context.Context, serviceType string) error {
+// ReleaseLock releases the lock on the service registered as serviceType
+func (dl *DistributedLockInfo) ReleaseLock(ctx context.Context, serviceType string) error {
 	service, err := GetService(serviceType)
 	if err != nil {
 		return err
 	}
-	return service.RenewLock(ctx, dl)
+	return dl.release(ctx, service)
 }
 
-func (dl *DistributedLockInfo) ReleaseLock(ctx context.Context, serviceType string) error {
-	service, err := GetService(serviceType)
-	if err != nil {
-		return err
-	}
+func (dl *DistributedLockInfo) release(ctx context.Context, service DistributedLockService) error {
 	dl.mutex.Lock()
 	defer dl.mutex.Unlock()
 	if !dl.locked {
@@ -1,13 +1,29 @@
 package distributedlock
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
 	"errors"
 	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/go-sql-driver/mysql"
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/samuel/go-zookeeper/zk"
+	"go.etcd.io/etcd/client/pkg/v3/transport"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"go.etcd.io/etcd/client/v3/concurrency"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
 // LockType represents the type of distributed lock
@@ -20,6 +36,16 @@ const (
 	EtcdLockType LockType = "etcd"
 	// MySQLLockType represents a MySQL-based distributed lock
 	MySQLLockType LockType = "mysql"
+	// MySQLLeaseLockType represents a MySQL table-based lease lock with fencing tokens
+	MySQLLeaseLockType LockType = "mysql-lease"
+	// PostgresLockType represents a PostgreSQL advisory-lock-based distributed lock
+	PostgresLockType LockType = "postgres"
+	// FileLockType represents a local file-lock-based lock for single-host deployments
+	FileLockType LockType = "file"
+	// ConsulLockType represents a Consul session-based distributed lock
+	ConsulLockType LockType = "consul"
+	// KubernetesLockType represents a Kubernetes Lease-object-based distributed lock
+	KubernetesLockType LockType = "kubernetes"
 	// ZookeeperLockType represents a ZooKeeper-based distributed lock
 	ZookeeperLockType LockType = "zookeeper"
 )
@@ -33,6 +59,16 @@ func NewDistributedLock(lockType LockType, config interface{}) (DistributedLockS
 		return newEtcdLock(config)
 	case MySQLLockType:
 		return newMySQLLock(config)
+	case MySQLLeaseLockType:
+		return newMySQLLeaseLock(config)
+	case PostgresLockType:
+		return newPostgresLock(config)
+	case FileLockType:
+		return newFileLock(config)
+	case ConsulLockType:
+		return newConsulLock(config)
+	case KubernetesLockType:
+		return newKubernetesLock(config)
 	case ZookeeperLockType:
 		return newZookeeperLock(config)
 	default:
@@ -47,42 +83,101 @@ func newRedisLock(config interface{}) (*RedisLock, error) {
 		return nil, errors.New("invalid Redis config")
 	}
 
-	// 使用第一个地址，如果没有则使用默认值
-	addr := "localhost:6379"
-	if len(cfg.Addrs) > 0 {
-		addr = cfg.Addrs[0]
+	client, err := newRedisClient(cfg)
+	if err != nil {
+		return nil, err
 	}
 
-	return &RedisLock{
-		client: redis.NewClient(&redis.Options{
-			Addr:     addr,
-			Password: cfg.Password,
-			DB:       cfg.DB,
-		}),
-	}, nil
+	lock := NewRedisLockWithClient(client)
+	if cfg.Fair {
+		lock.SetFair(cfg.WaiterTimeout)
+	}
+	return lock, nil
+}
+
+// newRedisClient builds the go-redis client matching cfg.Mode
+func newRedisClient(cfg RedisConfig) (redis.UniversalClient, error) {
+	tlsConfig, err := cfg.TLS.clientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis TLS config: %v", err)
+	}
+
+	switch cfg.Mode {
+	case "", RedisStandalone:
+		// 使用第一个地址，如果没有则使用默认值
+		addr := "localhost:6379"
+		if len(cfg.Addrs) > 0 {
+			addr = cfg.Addrs[0]
+		}
+		return redis.NewClient(&redis.Options{
+			Addr:         addr,
+			Password:     cfg.Password,
+			DB:           cfg.DB,
+			TLSConfig:    tlsConfig,
+			PoolSize:     cfg.PoolSize,
+			MinIdleConns: cfg.MinIdleConns,
+			DialTimeout:  cfg.DialTimeout,
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+		}), nil
+
+	case RedisSentinel:
+		if cfg.MasterName == "" {
+			return nil, errors.New("invalid Redis config: sentinel mode requires a master name")
+		}
+		if len(cfg.Addrs) == 0 {
+			return nil, errors.New("invalid Redis config: sentinel mode requires sentinel addresses")
+		}
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       cfg.MasterName,
+			SentinelAddrs:    cfg.Addrs,
+			SentinelPassword: cfg.SentinelPassword,
+			Password:         cfg.Password,
+			DB:               cfg.DB,
+			TLSConfig:        tlsConfig,
+			PoolSize:         cfg.PoolSize,
+			MinIdleConns:     cfg.MinIdleConns,
+			DialTimeout:      cfg.DialTimeout,
+			ReadTimeout:      cfg.ReadTimeout,
+			WriteTimeout:     cfg.WriteTimeout,
+		}), nil
+
+	case RedisCluster:
+		if len(cfg.Addrs) == 0 {
+			return nil, errors.New("invalid Redis config: cluster mode requires seed addresses")
+		}
+		if cfg.DB != 0 {
+			return nil, errors.New("invalid Redis config: cluster mode only supports DB 0")
+		}
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:        cfg.Addrs,
+			Password:     cfg.Password,
+			TLSConfig:    tlsConfig,
+			PoolSize:     cfg.PoolSize,
+			MinIdleConns: cfg.MinIdleConns,
+			DialTimeout:  cfg.DialTimeout,
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+		}), nil
+
+	default:
+		return nil, fmt.Errorf("invalid Redis config: unsupported mode: %s", cfg.Mode)
+	}
 }
 
 // newEtcdLock creates a new etcd distributed lock
 func newEtcdLock(config interface{}) (*EtcdLock, error) {
 	// 尝试多种配置类型
 	switch cfg := config.(type) {
-	case clientv3.Config:
-		client, err := clientv3.New(cfg)
+	case EtcdConfig:
+		clientConfig, err := cfg.clientConfig()
 		if err != nil {
-			return nil, fmt.Errorf("failed to create etcd client: %v", err)
+			return nil, err
 		}
+		return NewEtcdLockWithConfig(clientConfig)
 
-		session, err := concurrency.NewSession(client)
-		if err != nil {
-			client.Close()
-			return nil, fmt.Errorf("failed to create etcd session: %v", err)
-		}
-
-		return &EtcdLock{
-			session:   session,
-			client:    client,
-			endpoints: cfg.Endpoints,
-		}, nil
+	case clientv3.Config:
+		return NewEtcdLockWithConfig(cfg)
 
 	case []string:
 		// 如果传入的是端点列表
@@ -90,34 +185,162 @@ func newEtcdLock(config interface{}) (*EtcdLock, error) {
 
 	case *concurrency.Session:
 		// 如果直接传入 session
-		return &EtcdLock{
-			session:   cfg,
-			endpoints: []string{"localhost:2379"},
-		}, nil
+		return NewEtcdLock(cfg), nil
 
 	default:
-		return nil, errors.New("invalid etcd config: expected clientv3.Config, []string, or *concurrency.Session")
+		return nil, errors.New("invalid etcd config: expected EtcdConfig, clientv3.Config, []string, or *concurrency.Session")
 	}
 }
 
 // newMySQLLock creates a new MySQL distributed lock
 func newMySQLLock(config interface{}) (*MySQLLock, error) {
-	dsn, ok := config.(string)
+	cfg, err := mysqlConfigOf(config)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := cfg.openDB()
+	if err != nil {
+		return nil, err
+	}
+	lock, err := NewMySQLLockWithDB(db)
+	if err != nil {
+		return nil, err
+	}
+	lock.SetWaitTimeout(cfg.WaitTimeout)
+	return lock, nil
+}
+
+// newMySQLLeaseLock creates a new MySQL table-based lease lock
+func newMySQLLeaseLock(config interface{}) (*MySQLLeaseLock, error) {
+	cfg, err := mysqlConfigOf(config)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := cfg.openDB()
+	if err != nil {
+		return nil, err
+	}
+	lock, err := NewMySQLLeaseLockWithDB(db, cfg.LeaseTable)
+	if err != nil {
+		return nil, err
+	}
+	lock.StartGC(cfg.GCInterval)
+	return lock, nil
+}
+
+// mysqlConfigOf accepts the MySQL config forms understood by NewDistributedLock
+func mysqlConfigOf(config interface{}) (MySQLConfig, error) {
+	switch c := config.(type) {
+	case string:
+		return MySQLConfig{DSN: c}, nil
+	case MySQLConfig:
+		return c, nil
+	case map[string]interface{}:
+		return MySQLConfig{DSN: fmt.Sprintf("%s:%s@tcp(%s:%d)/%s",
+			c["user"],
+			c["password"],
+			c["host"],
+			c["port"],
+			c["dbname"],
+		)}, nil
+	default:
+		return MySQLConfig{}, errors.New("invalid MySQL config: expected DSN string, MySQLConfig or config map")
+	}
+}
+
+// newPostgresLock creates a new PostgreSQL advisory lock
+func newPostgresLock(config interface{}) (*PostgresLock, error) {
+	var cfg PostgresConfig
+	switch c := config.(type) {
+	case string:
+		cfg.DSN = c
+	case PostgresConfig:
+		cfg = c
+	default:
+		return nil, errors.New("invalid PostgreSQL config: expected DSN string or PostgresConfig")
+	}
+
+	lock, err := NewPostgresLock(cfg.dsn())
+	if err != nil {
+		return nil, err
+	}
+	lock.SetTransactionScoped(cfg.TransactionScoped)
+	return lock, nil
+}
+
+// newFileLock creates a new local file lock
+func newFileLock(config interface{}) (*FileLock, error) {
+	switch cfg := config.(type) {
+	case string:
+		return NewFileLock(cfg)
+	case FileConfig:
+		return NewFileLock(cfg.Dir)
+	default:
+		return nil, errors.New("invalid file lock config: expected directory string or FileConfig")
+	}
+}
+
+// newConsulLock creates a new Consul distributed lock
+func newConsulLock(config interface{}) (*ConsulLock, error) {
+	cfg, ok := config.(ConsulConfig)
 	if !ok {
-		dsnCfg, ok := config.(map[string]interface{})
-		if !ok {
-			return nil, errors.New("invalid MySQL config: expected DSN string or config map")
-		}
-		dsn = fmt.Sprintf("%s:%s@tcp(%s:%d)/%s",
-			dsnCfg["user"],
-			dsnCfg["password"],
-			dsnCfg["host"],
-			dsnCfg["port"],
-			dsnCfg["dbname"],
-		)
+		return nil, errors.New("invalid Consul config")
+	}
+
+	// DefaultConfig honors CONSUL_HTTP_ADDR, CONSUL_HTTP_TOKEN etc.
+	apiConfig := consulapi.DefaultConfig()
+	if cfg.Address != "" {
+		apiConfig.Address = cfg.Address
+	}
+	if cfg.Scheme != "" {
+		apiConfig.Scheme = cfg.Scheme
 	}
+	if cfg.Token != "" {
+		apiConfig.Token = cfg.Token
+	}
+	if cfg.Datacenter != "" {
+		apiConfig.Datacenter = cfg.Datacenter
+	}
+
+	client, err := consulapi.NewClient(apiConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create consul client: %v", err)
+	}
+
+	prefix := "locks"
+	if cfg.Prefix != "" {
+		prefix = cfg.Prefix
+	}
+
+	return NewConsulLock(client, prefix, cfg.LockDelay), nil
+}
 
-	return NewMySQLLock(dsn)
+// newKubernetesLock creates a new Kubernetes Lease lock
+func newKubernetesLock(config interface{}) (*KubernetesLock, error) {
+	switch cfg := config.(type) {
+	case kubernetes.Interface:
+		return NewKubernetesLock(cfg, ""), nil
+	case KubernetesConfig:
+		var restConfig *rest.Config
+		var err error
+		if cfg.Kubeconfig != "" {
+			restConfig, err = clientcmd.BuildConfigFromFlags("", cfg.Kubeconfig)
+		} else {
+			restConfig, err = rest.InClusterConfig()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load kubernetes config: %v", err)
+		}
+		client, err := kubernetes.NewForConfig(restConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create kubernetes client: %v", err)
+		}
+		return NewKubernetesLock(client, cfg.Namespace), nil
+	default:
+		return nil, errors.New("invalid Kubernetes config: expected KubernetesConfig or kubernetes.Interface")
+	}
 }
 
 // newZookeeperLock creates a new ZooKeeper distributed lock
@@ -141,15 +364,392 @@ func newZookeeperLock(config interface{}) (*ZookeeperLock, error) {
 	if cfg.Prefix != "" {
 		prefix = cfg.Prefix
 	}
+	// The Go client has no chroot support, so apply it to the lock prefix
+	prefix = path.Join("/", cfg.Chroot, prefix)
+
+	acl, err := zookeeperACL(cfg)
+	if err != nil {
+		return nil, err
+	}
 
-	return NewZookeeperLock(servers, sessionTimeout, prefix)
+	lock, err := NewZookeeperLock(servers, sessionTimeout, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Username != "" {
+		if err := lock.SetAuth(cfg.Username, cfg.Password); err != nil {
+			lock.Close()
+			return nil, fmt.Errorf("failed to authenticate with zookeeper: %v", err)
+		}
+	}
+	lock.SetACL(acl)
+	lock.SetWaitTimeout(cfg.WaitTimeout)
+	return lock, nil
+}
+
+// zookeeperPerms maps permission names to ZooKeeper permission bits
+var zookeeperPerms = map[string]int32{
+	"read":   zk.PermRead,
+	"write":  zk.PermWrite,
+	"create": zk.PermCreate,
+	"delete": zk.PermDelete,
+	"admin":  zk.PermAdmin,
+	"all":    zk.PermAll,
 }
 
+// zookeeperACL builds the node ACL from cfg. Without explicit entries an
+// authenticated client grants all permissions to itself only (the "auth"
+// scheme); an anonymous client falls back to world:anyone.
+func zookeeperACL(cfg ZooKeeperConfig) ([]zk.ACL, error) {
+	if len(cfg.ACL) == 0 {
+		if cfg.Username != "" {
+			return zk.AuthACL(zk.PermAll), nil
+		}
+		return zk.WorldACL(zk.PermAll), nil
+	}
+
+	var acl []zk.ACL
+	for _, entry := range cfg.ACL {
+		var perms int32
+		for _, name := range entry.Perms {
+			perm, ok := zookeeperPerms[strings.ToLower(name)]
+			if !ok {
+				return nil, fmt.Errorf("invalid ZooKeeper ACL permission: %s", name)
+			}
+			perms |= perm
+		}
+		if perms == 0 {
+			return nil, fmt.Errorf("ZooKeeper ACL entry %s:%s has no permissions", entry.Scheme, entry.ID)
+		}
+
+		switch entry.Scheme {
+		case "world":
+			acl = append(acl, zk.ACL{Perms: perms, Scheme: "world", ID: "anyone"})
+		case "digest":
+			user, password, ok := strings.Cut(entry.ID, ":")
+			if !ok {
+				return nil, fmt.Errorf("ZooKeeper digest ACL id must be user:password")
+			}
+			acl = append(acl, zk.DigestACL(perms, user, password)...)
+		case "ip":
+			if entry.ID == "" {
+				return nil, errors.New("ZooKeeper ip ACL requires an address or CIDR")
+			}
+			acl = append(acl, zk.ACL{Perms: perms, Scheme: "ip", ID: entry.ID})
+		default:
+			return nil, fmt.Errorf("unsupported ZooKeeper ACL scheme: %s", entry.Scheme)
+		}
+	}
+	return acl, nil
+}
+
+// Redis deployment modes accepted in RedisConfig.Mode
+const (
+	// RedisStandalone talks to a single Redis server (the default)
+	RedisStandalone = "standalone"
+	// RedisSentinel discovers the primary through Sentinel; Addrs are the sentinels
+	RedisSentinel = "sentinel"
+	// RedisCluster talks to a Redis Cluster; Addrs are the seed nodes
+	RedisCluster = "cluster"
+)
+
 // RedisConfig holds the configuration for Redis lock
 type RedisConfig struct {
+	Mode     string
 	Addrs    []string
 	Password string
 	DB       int
+	// MasterName is the name of the primary monitored by Sentinel
+	MasterName string
+	// SentinelPassword authenticates against the sentinels themselves
+	SentinelPassword string
+	// Fair enables FIFO ordering of waiters
+	Fair bool
+	// WaiterTimeout is how long a queued waiter survives without retrying
+	WaiterTimeout time.Duration
+	TLS           TLSConfig
+	// Pool and timeout settings; zero values keep the go-redis defaults
+	PoolSize     int
+	MinIdleConns int
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+}
+
+// EtcdConfig holds the configuration for etcd lock
+type EtcdConfig struct {
+	Endpoints   []string
+	Username    string
+	Password    string
+	DialTimeout time.Duration
+	// CertFile and KeyFile are the client certificate for mutual TLS
+	CertFile string
+	KeyFile  string
+	// CAFile verifies the server certificates; TLS is used if any TLS field is set
+	CAFile             string
+	ServerName         string
+	InsecureSkipVerify bool
+	// KeepAliveTime and KeepAliveTimeout configure gRPC keepalive pings
+	KeepAliveTime    time.Duration
+	KeepAliveTimeout time.Duration
+	// AutoSyncInterval refreshes the endpoint list from the cluster members (0 disables)
+	AutoSyncInterval time.Duration
+}
+
+// useTLS reports whether any TLS setting is configured
+func (c EtcdConfig) useTLS() bool {
+	return c.CertFile != "" || c.KeyFile != "" || c.CAFile != "" || c.ServerName != "" || c.InsecureSkipVerify
+}
+
+// clientConfig converts c into a clientv3.Config, loading the TLS files
+func (c EtcdConfig) clientConfig() (clientv3.Config, error) {
+	cfg := clientv3.Config{
+		Endpoints:            c.Endpoints,
+		Username:             c.Username,
+		Password:             c.Password,
+		DialTimeout:          c.DialTimeout,
+		DialKeepAliveTime:    c.KeepAliveTime,
+		DialKeepAliveTimeout: c.KeepAliveTimeout,
+		AutoSyncInterval:     c.AutoSyncInterval,
+	}
+	if !c.useTLS() {
+		return cfg, nil
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return cfg, errors.New("invalid etcd config: cert file and key file must be set together")
+	}
+
+	tlsInfo := transport.TLSInfo{
+		CertFile:           c.CertFile,
+		KeyFile:            c.KeyFile,
+		TrustedCAFile:      c.CAFile,
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	tlsConfig, err := tlsInfo.ClientConfig()
+	if err != nil {
+		return cfg, fmt.Errorf("failed to load etcd TLS config: %v", err)
+	}
+	cfg.TLS = tlsConfig
+	return cfg, nil
+}
+
+// MySQLConfig holds the configuration for MySQL lock
+type MySQLConfig struct {
+	// DSN is used as-is when set; otherwise it is built from the fields below
+	DSN      string
+	User     string
+	Password string
+	Host     string
+	Port     int
+	DBName   string
+	// WaitTimeout is how long one GET_LOCK call blocks, independent of the lock expiration
+	WaitTimeout time.Duration
+	// LeaseTable is the locks table of the mysql-lease backend
+	LeaseTable string
+	// GCInterval is how often the mysql-lease backend deletes expired rows (0 disables)
+	GCInterval time.Duration
+	TLS        TLSConfig
+	// Params are extra DSN parameters: driver options such as charset or
+	// parseTime, anything else is set as a server variable, e.g. time_zone
+	Params map[string]string
+	// PoolSize caps open connections. MySQLLock pins one connection per held
+	// lock, so it also caps the number of locks held at once.
+	PoolSize        int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	// Timeouts of the driver; zero values keep the driver defaults
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+}
+
+// driverConfig parses the configured DSN, or builds one from the individual
+// fields, and applies the TLS, timeout and parameter settings on top
+func (c MySQLConfig) driverConfig() (*mysql.Config, error) {
+	var cfg *mysql.Config
+	if c.DSN != "" {
+		parsed, err := mysql.ParseDSN(c.DSN)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MySQL DSN: %v", err)
+		}
+		cfg = parsed
+	} else {
+		cfg = mysql.NewConfig()
+		cfg.User = c.User
+		cfg.Passwd = c.Password
+		cfg.Net = "tcp"
+		cfg.Addr = net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+		cfg.DBName = c.DBName
+	}
+
+	if len(c.Params) > 0 {
+		// Run Params through the DSN parser, so driver options such as
+		// charset or parseTime are applied and only the rest are sent to
+		// the server as SET statements
+		params := url.Values{}
+		for k, v := range c.Params {
+			params.Set(k, v)
+		}
+		dsn := cfg.FormatDSN()
+		if strings.Contains(dsn, "?") {
+			dsn += "&" + params.Encode()
+		} else {
+			dsn += "?" + params.Encode()
+		}
+		parsed, err := mysql.ParseDSN(dsn)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MySQL params: %v", err)
+		}
+		cfg = parsed
+	}
+
+	if c.DialTimeout > 0 {
+		cfg.Timeout = c.DialTimeout
+	}
+	if c.ReadTimeout > 0 {
+		cfg.ReadTimeout = c.ReadTimeout
+	}
+	if c.WriteTimeout > 0 {
+		cfg.WriteTimeout = c.WriteTimeout
+	}
+
+	tlsConfig, err := c.TLS.clientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("invalid MySQL TLS config: %v", err)
+	}
+	if tlsConfig != nil {
+		cfg.TLS = tlsConfig
+	}
+	return cfg, nil
+}
+
+// openDB opens a connection pool configured from c
+func (c MySQLConfig) openDB() (*sql.DB, error) {
+	cfg, err := c.driverConfig()
+	if err != nil {
+		return nil, err
+	}
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
+
+	db := sql.OpenDB(connector)
+	if c.PoolSize > 0 {
+		db.SetMaxOpenConns(c.PoolSize)
+	}
+	if c.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(c.MaxIdleConns)
+	}
+	if c.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(c.ConnMaxLifetime)
+	}
+	return db, nil
+}
+
+// TLSConfig holds client TLS settings of the Redis and MySQL backends
+type TLSConfig struct {
+	// Enabled turns TLS on with the system CAs; setting any file enables it as well
+	Enabled bool
+	// CAFile verifies the server certificate
+	CAFile string
+	// CertFile and KeyFile are the client certificate for mutual TLS
+	CertFile string
+	KeyFile  string
+	// ServerName overrides the name checked against the server certificate
+	ServerName string
+	// InsecureSkipVerify disables certificate verification (development only)
+	InsecureSkipVerify bool
+}
+
+// clientConfig builds the *tls.Config described by c, or nil if TLS is off
+func (c TLSConfig) clientConfig() (*tls.Config, error) {
+	if !c.Enabled && c.CAFile == "" && c.CertFile == "" && c.KeyFile == "" {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", c.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// PostgresConfig holds the configuration for PostgreSQL lock
+type PostgresConfig struct {
+	// DSN is used as-is when set; otherwise it is built from the fields below
+	DSN      string
+	User     string
+	Password string
+	Host     string
+	Port     int
+	DBName   string
+	SSLMode  string
+	// TransactionScoped takes pg_try_advisory_xact_lock inside a transaction
+	TransactionScoped bool
+}
+
+// dsn returns the configured DSN or builds a postgres:// URL from the individual fields
+func (c PostgresConfig) dsn() string {
+	if c.DSN != "" {
+		return c.DSN
+	}
+	u := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(c.User, c.Password),
+		Host:   fmt.Sprintf("%s:%d", c.Host, c.Port),
+		Path:   "/" + c.DBName,
+	}
+	if c.SSLMode != "" {
+		u.RawQuery = url.Values{"sslmode": {c.SSLMode}}.Encode()
+	}
+	return u.String()
+}
+
+// FileConfig holds the configuration for file lock
+type FileConfig struct {
+	// Dir is the directory holding the lock files
+	Dir string
+}
+
+// ConsulConfig holds the configuration for Consul lock
+type ConsulConfig struct {
+	Address    string
+	Scheme     string
+	Token      string
+	Datacenter string
+	// Prefix is the KV path under which lock keys are created
+	Prefix string
+	// LockDelay is the session lock-delay; 0 keeps the Consul default
+	LockDelay time.Duration
+}
+
+// KubernetesConfig holds the configuration for Kubernetes lock
+type KubernetesConfig struct {
+	// Namespace holds the Lease objects
+	Namespace string
+	// Kubeconfig is the kubeconfig file to use; empty means in-cluster config
+	Kubeconfig string
 }
 
 // ZooKeeperConfig holds the configuration for ZooKeeper lock
@@ -157,4 +757,22 @@ type ZooKeeperConfig struct {
 	Servers        []string
 	SessionTimeout time.Duration
 	Prefix         string
+	// WaitTimeout is how long one acquire attempt watches its predecessor
+	WaitTimeout time.Duration
+	// Username and Password are digest credentials added to the session
+	Username string
+	Password string
+	// ACL applies to lock nodes and their parents; see zookeeperACL for the default
+	ACL []ZooKeeperACLEntry
+	// Chroot is prepended to every path used by the lock
+	Chroot string
+}
+
+// ZooKeeperACLEntry is one ACL entry. ID is ignored for "world", is
+// "user:password" for "digest" (hashed before use) and an address or CIDR for "ip".
+type ZooKeeperACLEntry struct {
+	Scheme string
+	ID     string
+	// Perms are any of read, write, create, delete, admin, all
+	Perms []string
 }
@@ -0,0 +1,348 @@
+package distributedlock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"path"
+	"sync"
+	"time"
+)
+
+// ErrInjectedFault is returned by errors injected by a FaultInjector
+var ErrInjectedFault = errors.New("injected fault")
+
+// FaultOp is the service call a fault rule applies to
+type FaultOp string
+
+const (
+	FaultAcquire FaultOp = "acquire"
+	FaultRelease FaultOp = "release"
+	FaultRenew   FaultOp = "renew"
+)
+
+// FaultKind is the failure a rule injects
+type FaultKind string
+
+const (
+	// FaultLatency delays the call by Latency, then makes it
+	FaultLatency FaultKind = "latency"
+	// FaultError fails the call with the rule's error without making it
+	FaultError FaultKind = "error"
+	// FaultTimeout hangs for Latency, or until the context is done, and
+	// fails with a deadline error
+	FaultTimeout FaultKind = "timeout"
+	// FaultLostRenewal releases the lock on the backend and fails the renewal
+	// with ErrLockLost, as if it had expired (renew only)
+	FaultLostRenewal FaultKind = "lost-renewal"
+	// FaultSplitBrain grants the lock without asking the backend, so two
+	// holders can coexist (acquire only)
+	FaultSplitBrain FaultKind = "split-brain"
+	// FaultReleaseError releases the lock but reports an error (release only)
+	FaultReleaseError FaultKind = "release-error"
+)
+
+// faultKindOps lists the calls each kind can be injected into
+var faultKindOps = map[FaultKind][]FaultOp{
+	FaultLatency:      {FaultAcquire, FaultRelease, FaultRenew},
+	FaultError:        {FaultAcquire, FaultRelease, FaultRenew},
+	FaultTimeout:      {FaultAcquire, FaultRelease, FaultRenew},
+	FaultLostRenewal:  {FaultRenew},
+	FaultSplitBrain:   {FaultAcquire},
+	FaultReleaseError: {FaultRelease},
+}
+
+// FaultRule injects one kind of fault into matching calls. A rule is
+// scripted if Calls is set and probabilistic otherwise.
+type FaultRule struct {
+	// Op limits the rule to one call; empty means every call the kind supports
+	Op   FaultOp
+	Kind FaultKind
+	// Key is a path.Match pattern for lock keys; empty matches every key
+	Key string
+	// Probability that a matching call fails, from 0 to 1
+	Probability float64
+	// Calls are the 1-based numbers of the matching calls that fail
+	Calls []int
+	// Times limits how often the rule fires (0 = unlimited)
+	Times int
+	// Latency is the delay of FaultLatency and the hang of FaultTimeout
+	Latency time.Duration
+	// Err is returned by FaultError and FaultReleaseError; nil means ErrInjectedFault
+	Err error
+}
+
+func (r FaultRule) validate() error {
+	ops, ok := faultKindOps[r.Kind]
+	if !ok {
+		return fmt.Errorf("unsupported fault kind: %s", r.Kind)
+	}
+	if r.Op != "" && !containsFaultOp(ops, r.Op) {
+		return fmt.Errorf("fault %s cannot be injected into %s", r.Kind, r.Op)
+	}
+	if r.Probability < 0 || r.Probability > 1 {
+		return fmt.Errorf("invalid fault probability: %v", r.Probability)
+	}
+	if (r.Kind == FaultLatency || r.Kind == FaultTimeout) && r.Latency <= 0 {
+		return fmt.Errorf("%s fault needs a latency", r.Kind)
+	}
+	if _, err := path.Match(r.Key, ""); err != nil {
+		return fmt.Errorf("invalid fault key pattern %q: %w", r.Key, err)
+	}
+	return nil
+}
+
+func containsFaultOp(ops []FaultOp, op FaultOp) bool {
+	for _, o := range ops {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// faultRule is a rule with its call counters
+type faultRule struct {
+	FaultRule
+	matched int
+	fired   int
+}
+
+// FaultInjector wraps a DistributedLockService and injects failures into
+// its calls according to rules. Rules are checked in order and the first
+// one that fires applies. It is meant for tests and staging only.
+type FaultInjector struct {
+	service DistributedLockService
+
+	mu    sync.Mutex
+	rules []*faultRule
+	rand  *rand.Rand
+	// splitBrain holds the locks granted by FaultSplitBrain, which the
+	// backend knows nothing about
+	splitBrain map[*DistributedLockInfo]struct{}
+}
+
+// NewFaultInjector wraps service with the given rules
+func NewFaultInjector(service DistributedLockService, rules ...FaultRule) (*FaultInjector, error) {
+	f := &FaultInjector{
+		service:    service,
+		rand:       rand.New(rand.NewSource(time.Now().UnixNano())),
+		splitBrain: make(map[*DistributedLockInfo]struct{}),
+	}
+	if err := f.SetRules(rules...); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// SetRules replaces the rules and resets their counters
+func (f *FaultInjector) SetRules(rules ...FaultRule) error {
+	compiled := make([]*faultRule, 0, len(rules))
+	for _, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return err
+		}
+		compiled = append(compiled, &faultRule{FaultRule: rule})
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = compiled
+	return nil
+}
+
+// SetSeed makes probabilistic rules reproducible
+func (f *FaultInjector) SetSeed(seed int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rand = rand.New(rand.NewSource(seed))
+}
+
+func (f *FaultInjector) AcquireLock(ctx context.Context, lockInfo *DistributedLockInfo) (bool, error) {
+	rule := f.fault(FaultAcquire, lockInfo.key)
+	if rule == nil {
+		return f.service.AcquireLock(ctx, lockInfo)
+	}
+
+	switch rule.Kind {
+	case FaultLatency:
+		if err := sleepContext(ctx, rule.Latency); err != nil {
+			return false, err
+		}
+		return f.service.AcquireLock(ctx, lockInfo)
+	case FaultSplitBrain:
+		f.mu.Lock()
+		f.splitBrain[lockInfo] = struct{}{}
+		f.mu.Unlock()
+		return true, nil
+	case FaultTimeout:
+		return false, injectTimeout(ctx, rule.Latency)
+	default:
+		return false, rule.err(FaultAcquire)
+	}
+}
+
+func (f *FaultInjector) ReleaseLock(ctx context.Context, lockInfo *DistributedLockInfo) (bool, error) {
+	if f.forgetSplitBrain(lockInfo) {
+		return true, nil
+	}
+	rule := f.fault(FaultRelease, lockInfo.key)
+	if rule == nil {
+		return f.service.ReleaseLock(ctx, lockInfo)
+	}
+
+	switch rule.Kind {
+	case FaultLatency:
+		if err := sleepContext(ctx, rule.Latency); err != nil {
+			return false, err
+		}
+		return f.service.ReleaseLock(ctx, lockInfo)
+	case FaultReleaseError:
+		if _, err := f.service.ReleaseLock(ctx, lockInfo); err != nil {
+			return false, err
+		}
+		return false, rule.err(FaultRelease)
+	case FaultTimeout:
+		return false, injectTimeout(ctx, rule.Latency)
+	default:
+		return false, rule.err(FaultRelease)
+	}
+}
+
+func (f *FaultInjector) RenewLock(ctx context.Context, lockInfo *DistributedLockInfo) error {
+	f.mu.Lock()
+	_, split := f.splitBrain[lockInfo]
+	f.mu.Unlock()
+	if split {
+		return nil
+	}
+	rule := f.fault(FaultRenew, lockInfo.key)
+	if rule == nil {
+		return f.service.RenewLock(ctx, lockInfo)
+	}
+
+	switch rule.Kind {
+	case FaultLatency:
+		if err := sleepContext(ctx, rule.Latency); err != nil {
+			return err
+		}
+		return f.service.RenewLock(ctx, lockInfo)
+	case FaultLostRenewal:
+		f.service.ReleaseLock(ctx, lockInfo)
+		return ErrLockLost
+	case FaultTimeout:
+		return injectTimeout(ctx, rule.Latency)
+	default:
+		return rule.err(FaultRenew)
+	}
+}
+
+// CancelWait passes a gave-up waiter on to backends that queue waiters
+func (f *FaultInjector) CancelWait(ctx context.Context, lockInfo *DistributedLockInfo) error {
+	canceler, ok := f.service.(waitCanceler)
+	if !ok {
+		return nil
+	}
+	return canceler.CancelWait(ctx, lockInfo)
+}
+
+// NewLock returns a lock handle whose calls go through the injector
+func (f *FaultInjector) NewLock(key string, opts ...LockOption) Locker {
+	return newLockHandle(f, key, opts...)
+}
+
+// BuildServiceType returns the type of the wrapped backend
+func (f *FaultInjector) BuildServiceType() string {
+	return f.service.BuildServiceType()
+}
+
+// Close closes the wrapped backend, which the injector owns once registered
+func (f *FaultInjector) Close() error {
+	if closer, ok := f.service.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// fault returns the first rule that fires for op on key, or nil
+func (f *FaultInjector) fault(op FaultOp, key string) *faultRule {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, rule := range f.rules {
+		if !rule.matches(op, key) {
+			continue
+		}
+		rule.matched++
+		if rule.Times > 0 && rule.fired >= rule.Times {
+			continue
+		}
+		if !rule.fires(f.rand) {
+			continue
+		}
+		rule.fired++
+		return rule
+	}
+	return nil
+}
+
+func (f *FaultInjector) forgetSplitBrain(lockInfo *DistributedLockInfo) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, split := f.splitBrain[lockInfo]
+	delete(f.splitBrain, lockInfo)
+	return split
+}
+
+func (r *faultRule) matches(op FaultOp, key string) bool {
+	if r.Op != "" && r.Op != op || !containsFaultOp(faultKindOps[r.Kind], op) {
+		return false
+	}
+	if r.Key == "" {
+		return true
+	}
+	matched, _ := path.Match(r.Key, key)
+	return matched
+}
+
+// fires decides whether the current matching call fails
+func (r *faultRule) fires(rnd *rand.Rand) bool {
+	if len(r.Calls) > 0 {
+		for _, call := range r.Calls {
+			if call == r.matched {
+				return true
+			}
+		}
+		return false
+	}
+	return rnd.Float64() < r.Probability
+}
+
+func (r *faultRule) err(op FaultOp) error {
+	if r.Err != nil {
+		return r.Err
+	}
+	return fmt.Errorf("%w: %s on %s", ErrInjectedFault, r.Kind, op)
+}
+
+// injectTimeout hangs until ctx is done or limit has passed. The hang is
+// always bounded: the watchdog renews with the acquire context, often
+// Background, while holding the lock info's mutex.
+func injectTimeout(ctx context.Context, limit time.Duration) error {
+	if err := sleepContext(ctx, limit); err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrInjectedFault, context.DeadlineExceeded)
+}
+
+// sleepContext waits for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
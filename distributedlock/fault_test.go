@@ -0,0 +1,185 @@
+package distributedlock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestFaultInjectorScriptedErrors tests that scripted rules fail exactly the listed calls
+func TestFaultInjectorScriptedErrors(t *testing.T) {
+	ctx := context.Background()
+	lock, _ := newTestRedisLock(t)
+	f, err := NewFaultInjector(lock, FaultRule{Op: FaultAcquire, Kind: FaultError, Key: "orders/*", Calls: []int{1, 3}})
+	if err != nil {
+		t.Fatalf("Failed to create fault injector: %v", err)
+	}
+
+	for i, want := range []bool{true, false, true} {
+		info := NewDistributedLockInfo("orders/"+string(rune('a'+i)), "v", time.Second)
+		_, err := f.AcquireLock(ctx, info)
+		if got := errors.Is(err, ErrInjectedFault); got != want {
+			t.Errorf("Expected call %d injected=%v, got %v", i+1, want, err)
+		}
+	}
+	if acquired, err := f.AcquireLock(ctx, NewDistributedLockInfo("users/a", "v", time.Second)); err != nil || !acquired {
+		t.Errorf("Expected other keys to pass through, got %v, %v", acquired, err)
+	}
+}
+
+// TestFaultInjectorProbability tests that a seeded probabilistic rule fires about as often as configured
+func TestFaultInjectorProbability(t *testing.T) {
+	ctx := context.Background()
+	lock, _ := newTestRedisLock(t)
+	f, _ := NewFaultInjector(lock, FaultRule{Kind: FaultError, Probability: 0.3})
+	f.SetSeed(1)
+
+	failures := 0
+	for i := 0; i < 1000; i++ {
+		if err := f.RenewLock(ctx, NewDistributedLockInfo("k", "v", time.Second)); errors.Is(err, ErrInjectedFault) {
+			failures++
+		}
+	}
+	if failures < 250 || failures > 350 {
+		t.Errorf("Expected about 300 failures, got %d", failures)
+	}
+}
+
+// TestFaultInjectorLostRenewal tests that a lost renewal frees the lock and makes the watchdog give up
+func TestFaultInjectorLostRenewal(t *testing.T) {
+	ctx := context.Background()
+	lock, mr := newTestRedisLock(t)
+	f, _ := NewFaultInjector(lock, FaultRule{Kind: FaultLostRenewal, Times: 1, Probability: 1})
+
+	err := WithLock(ctx, f, "job", []LockOption{WithExpiration(40 * time.Millisecond)}, func(ctx context.Context) error {
+		<-ctx.Done()
+		if mr.Exists("job") {
+			t.Error("Expected the backend lock to be gone")
+		}
+		return nil
+	})
+	if !errors.Is(err, ErrLockLost) {
+		t.Errorf("Expected ErrLockLost, got %v", err)
+	}
+}
+
+// TestFaultInjectorSplitBrain tests that a split-brain acquire yields a second holder
+func TestFaultInjectorSplitBrain(t *testing.T) {
+	ctx := context.Background()
+	lock, mr := newTestRedisLock(t)
+	f, _ := NewFaultInjector(lock, FaultRule{Kind: FaultSplitBrain, Calls: []int{2}})
+
+	first := f.NewLock("job")
+	second := f.NewLock("job")
+	if acquired, _ := first.Lock(ctx); !acquired {
+		t.Fatal("Expected first holder to acquire")
+	}
+	if acquired, _ := second.Lock(ctx); !acquired {
+		t.Fatal("Expected split-brain holder to acquire")
+	}
+	if err := second.Unlock(ctx); err != nil {
+		t.Errorf("Expected split-brain release to succeed, got %v", err)
+	}
+	if !mr.Exists("job") {
+		t.Error("Expected the real holder's lock to survive the split-brain release")
+	}
+	first.Unlock(ctx)
+}
+
+// TestFaultInjectorReleaseError tests that the lock is released even though an error is reported
+func TestFaultInjectorReleaseError(t *testing.T) {
+	ctx := context.Background()
+	lock, mr := newTestRedisLock(t)
+	f, _ := NewFaultInjector(lock, FaultRule{Kind: FaultReleaseError, Probability: 1})
+
+	info := NewDistributedLockInfo("job", "v", time.Second)
+	f.AcquireLock(ctx, info)
+	if _, err := f.ReleaseLock(ctx, info); !errors.Is(err, ErrInjectedFault) {
+		t.Errorf("Expected injected release error, got %v", err)
+	}
+	if mr.Exists("job") {
+		t.Error("Expected lock to be released on the backend")
+	}
+}
+
+// TestFaultInjectorLatencyAndTimeout tests delayed calls and hanging calls
+func TestFaultInjectorLatencyAndTimeout(t *testing.T) {
+	lock, _ := newTestRedisLock(t)
+	f, _ := NewFaultInjector(lock,
+		FaultRule{Op: FaultAcquire, Kind: FaultLatency, Latency: 30 * time.Millisecond, Probability: 1},
+		FaultRule{Op: FaultRenew, Kind: FaultTimeout, Latency: time.Minute, Probability: 1},
+		FaultRule{Op: FaultRelease, Kind: FaultTimeout, Latency: 20 * time.Millisecond, Probability: 1},
+	)
+
+	start := time.Now()
+	if acquired, err := f.AcquireLock(context.Background(), NewDistributedLockInfo("job", "v", time.Second)); err != nil || !acquired {
+		t.Errorf("Expected delayed acquire to succeed, got %v, %v", acquired, err)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("Expected acquire to take at least 30ms, took %v", elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := f.RenewLock(ctx, NewDistributedLockInfo("job", "v", time.Second)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected DeadlineExceeded, got %v", err)
+	}
+
+	// Without a deadline the hang ends after the rule's latency
+	_, err := f.ReleaseLock(context.Background(), NewDistributedLockInfo("job", "v", time.Second))
+	if !errors.Is(err, ErrInjectedFault) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected an injected DeadlineExceeded, got %v", err)
+	}
+}
+
+// TestFaultRuleValidation tests that rules for the wrong call are rejected
+func TestFaultRuleValidation(t *testing.T) {
+	lock, _ := newTestRedisLock(t)
+	invalid := []FaultRule{
+		{Kind: "flaky"},
+		{Op: FaultAcquire, Kind: FaultLostRenewal},
+		{Kind: FaultError, Probability: 2},
+		{Kind: FaultLatency},
+		{Kind: FaultTimeout},
+	}
+	for _, rule := range invalid {
+		if _, err := NewFaultInjector(lock, rule); err == nil {
+			t.Errorf("Expected error for rule %+v", rule)
+		}
+	}
+}
+
+// TestFaultInjectorBackendFeatures tests that features keeping state on the
+// backend work through a fault injector and a namespace over one
+func TestFaultInjectorBackendFeatures(t *testing.T) {
+	ctx := context.Background()
+	lock, mr := newTestRedisLock(t)
+	f, _ := NewFaultInjector(lock)
+	ns, err := NewNamespacedService(f, Namespace{Prefix: "tenant"})
+	if err != nil {
+		t.Fatalf("Failed to create namespace: %v", err)
+	}
+
+	for _, service := range []DistributedLockService{f, ns} {
+		if _, err := NewRateLimiter(service, TokenBucket, Limit{Rate: 1, Per: time.Second}); err != nil {
+			t.Errorf("Expected rate limiter on %T, got %v", service, err)
+		}
+		if _, err := NewIdempotencyStore(service, time.Hour); err != nil {
+			t.Errorf("Expected idempotency store on %T, got %v", service, err)
+		}
+	}
+
+	once, err := NewOnce(ns, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to create once: %v", err)
+	}
+	if _, err := once.RunOnce(ctx, "cache", func(ctx context.Context) ([]byte, error) {
+		return []byte("warm"), nil
+	}); err != nil {
+		t.Fatalf("Expected RunOnce to succeed, got %v", err)
+	}
+	if !mr.Exists("tenant/" + onceResultPrefix + "cache") {
+		t.Errorf("Expected the result under the namespace prefix, got keys %v", mr.Keys())
+	}
+}
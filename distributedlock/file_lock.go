@@ -0,0 +1,262 @@
+package distributedlock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileLock implements DistributedLockService with OS file locks (flock on
+// Unix, LockFileEx on Windows) on lock files in one directory. It only
+// excludes processes on the same host and is meant for development machines
+// and single-VM installs.
+//
+// The holder writes its metadata into the lock file. A lock file that is
+// still locked although its recorded holder process is gone (e.g. the
+// descriptor leaked into a child process) is considered stale and broken by
+// replacing the file.
+type FileLock struct {
+	dir      string
+	hostname string
+}
+
+// fileHandle is the file-private state of a held lock
+type fileHandle struct {
+	file *os.File
+	path string
+}
+
+// fileLockMetadata is written into a held lock file
+type fileLockMetadata struct {
+	Owner      string    `json:"owner"`
+	PID        int       `json:"pid"`
+	Hostname   string    `json:"hostname"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// errFileLockBusy is returned by tryLockFile when another holder has the lock
+var errFileLockBusy = errors.New("file lock busy")
+
+// NewFileLock creates a file lock backend storing lock files in dir
+func NewFileLock(dir string) (*FileLock, error) {
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "distributed-locks")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %v", err)
+	}
+	hostname, _ := os.Hostname()
+	return &FileLock{dir: dir, hostname: hostname}, nil
+}
+
+func (f *FileLock) AcquireLock(ctx context.Context, lockInfo *DistributedLockInfo) (bool, error) {
+	path := f.lockPath(lockInfo.key)
+
+	// One retry after breaking a stale lock file
+	for attempt := 0; attempt < 2; attempt++ {
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			return false, err
+		}
+
+		err = tryLockFile(file)
+		if err == errFileLockBusy {
+			file.Close()
+			if attempt == 0 && f.breakStale(path) {
+				continue
+			}
+			return false, nil
+		}
+		if err != nil {
+			file.Close()
+			return false, err
+		}
+
+		// Record ourselves right away: a file left behind by a crashed holder
+		// still names the dead process, and a contender must not break it
+		// while we hold it
+		h := &fileHandle{file: file, path: path}
+		if err := f.writeMetadata(h, lockInfo, time.Now()); err != nil {
+			unlockFile(file)
+			file.Close()
+			return false, err
+		}
+
+		// The previous holder may have removed or replaced the file between
+		// our open and lock; then we locked an orphan and must start over
+		if !sameFile(file, path) {
+			unlockFile(file)
+			file.Close()
+			continue
+		}
+		lockInfo.handle = h
+		return true, nil
+	}
+	return false, nil
+}
+
+func (f *FileLock) ReleaseLock(ctx context.Context, lockInfo *DistributedLockInfo) (bool, error) {
+	h, ok := lockInfo.handle.(*fileHandle)
+	if !ok {
+		return false, ErrLockNotHeld
+	}
+	lockInfo.handle = nil
+
+	owned := sameFile(h.file, h.path)
+	if owned {
+		// Remove while still locked so waiters that opened this file notice
+		// the replacement; on Windows an open file may not be removable
+		os.Remove(h.path)
+	}
+	err := unlockFile(h.file)
+	h.file.Close()
+	if err != nil {
+		return false, err
+	}
+	return owned, nil
+}
+
+// RenewLock checks that the lock file is still ours and refreshes the
+// expiry recorded in it. File locks do not expire on their own.
+func (f *FileLock) RenewLock(ctx context.Context, lockInfo *DistributedLockInfo) error {
+	h, ok := lockInfo.handle.(*fileHandle)
+	if !ok {
+		return ErrLockNotHeld
+	}
+	if !sameFile(h.file, h.path) {
+		lockInfo.handle = nil
+		unlockFile(h.file)
+		h.file.Close()
+		return ErrLockLost
+	}
+	return f.writeMetadata(h, lockInfo, time.Time{})
+}
+
+// Holder returns the metadata recorded by the current holder of key
+func (f *FileLock) Holder(key string) (owner string, pid int, err error) {
+	meta, err := readFileLockMetadata(f.lockPath(key))
+	if err != nil {
+		return "", 0, err
+	}
+	return meta.Owner, meta.PID, nil
+}
+
+// NewLock returns a lock handle bound to this lock directory
+func (f *FileLock) NewLock(key string, opts ...LockOption) Locker {
+	return newLockHandle(f, key, opts...)
+}
+
+func (f *FileLock) BuildServiceType() string {
+	return "file"
+}
+
+// lockPath maps a key to a file name that is safe on every platform
+func (f *FileLock) lockPath(key string) string {
+	return filepath.Join(f.dir, url.PathEscape(key)+".lock")
+}
+
+// breakStale removes a lock file whose recorded holder process on this host
+// no longer exists and reports whether it did so. The metadata is read
+// through an open descriptor and the file is only removed while it is still
+// the one at path and still names the dead process, so a holder that has
+// just taken over or replaced the file is left alone.
+func (f *FileLock) breakStale(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	meta, err := readFileLockMetadataFrom(file)
+	if err != nil || !f.stale(meta) {
+		return false
+	}
+	if again, err := readFileLockMetadataFrom(file); err != nil || *again != *meta || !sameFile(file, path) {
+		return false
+	}
+	log.Printf("FileLock: breaking stale lock %s held by dead process %d\n", path, meta.PID)
+	return os.Remove(path) == nil
+}
+
+// stale reports whether meta names a process on this host that is gone
+func (f *FileLock) stale(meta *fileLockMetadata) bool {
+	return meta.PID != 0 && meta.Hostname == f.hostname && !processAlive(meta.PID)
+}
+
+// writeMetadata records the holder in the lock file. A zero acquiredAt keeps
+// the previously recorded acquisition time.
+func (f *FileLock) writeMetadata(h *fileHandle, lockInfo *DistributedLockInfo, acquiredAt time.Time) error {
+	now := time.Now()
+	if acquiredAt.IsZero() {
+		if prev, err := readFileLockMetadata(h.path); err == nil {
+			acquiredAt = prev.AcquiredAt
+		} else {
+			acquiredAt = now
+		}
+	}
+	data, err := json.Marshal(fileLockMetadata{
+		Owner:      lockInfo.value,
+		PID:        os.Getpid(),
+		Hostname:   f.hostname,
+		AcquiredAt: acquiredAt,
+		ExpiresAt:  now.Add(lockInfo.expiration),
+	})
+	if err != nil {
+		return err
+	}
+	if err := h.file.Truncate(0); err != nil {
+		return err
+	}
+	_, err = h.file.WriteAt(data, 0)
+	return err
+}
+
+func readFileLockMetadata(path string) (*fileLockMetadata, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseFileLockMetadata(data)
+}
+
+// readFileLockMetadataFrom reads the metadata of an open lock file
+func readFileLockMetadataFrom(file *os.File) (*fileLockMetadata, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, info.Size())
+	n, err := file.ReadAt(data, 0)
+	if err != nil && n < len(data) {
+		return nil, err
+	}
+	return parseFileLockMetadata(data[:n])
+}
+
+func parseFileLockMetadata(data []byte) (*fileLockMetadata, error) {
+	var meta fileLockMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// sameFile reports whether file is still the file found at path
+func sameFile(file *os.File, path string) bool {
+	openInfo, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	pathInfo, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(openInfo, pathInfo)
+}
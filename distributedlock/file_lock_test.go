@@ -0,0 +1,132 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package distributedlock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// TestFileLockExclusive tests that a second holder is rejected until release
+func TestFileLockExclusive(t *testing.T) {
+	ctx := context.Background()
+	lock, err := NewFileLock(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create file lock: %v", err)
+	}
+
+	first := NewDistributedLockInfo("jobs/nightly", "first", 30*time.Second)
+	second := NewDistributedLockInfo("jobs/nightly", "second", 30*time.Second)
+
+	if ok, err := lock.AcquireLock(ctx, first); err != nil || !ok {
+		t.Fatalf("Expected first to acquire the lock, got %v, %v", ok, err)
+	}
+	if ok, err := lock.AcquireLock(ctx, second); err != nil || ok {
+		t.Fatalf("Expected second to be rejected, got %v, %v", ok, err)
+	}
+
+	owner, pid, err := lock.Holder("jobs/nightly")
+	if err != nil {
+		t.Fatalf("Failed to read holder: %v", err)
+	}
+	if owner != "first" || pid != os.Getpid() {
+		t.Errorf("Expected holder first/%d, got %s/%d", os.Getpid(), owner, pid)
+	}
+
+	if err := lock.RenewLock(ctx, first); err != nil {
+		t.Fatalf("Expected renew to succeed, got %v", err)
+	}
+	if ok, err := lock.ReleaseLock(ctx, first); err != nil || !ok {
+		t.Fatalf("Expected to release the lock, got %v, %v", ok, err)
+	}
+	if ok, err := lock.AcquireLock(ctx, second); err != nil || !ok {
+		t.Fatalf("Expected second to acquire after release, got %v, %v", ok, err)
+	}
+}
+
+// TestFileLockBreaksStaleLock tests that a lock recorded for a dead process is taken over
+func TestFileLockBreaksStaleLock(t *testing.T) {
+	ctx := context.Background()
+	lock, err := NewFileLock(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create file lock: %v", err)
+	}
+
+	// Find a PID that no longer exists
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("Cannot start helper process: %v", err)
+	}
+	deadPID := cmd.Process.Pid
+
+	// Hold the flock through a leaked descriptor while recording the dead PID
+	path := lock.lockPath("stale-key")
+	leaked, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		t.Fatalf("Failed to create lock file: %v", err)
+	}
+	defer leaked.Close()
+	if err := tryLockFile(leaked); err != nil {
+		t.Fatalf("Failed to lock file: %v", err)
+	}
+	data, _ := json.Marshal(fileLockMetadata{Owner: "ghost", PID: deadPID, Hostname: lock.hostname})
+	leaked.Write(data)
+
+	lockInfo := NewDistributedLockInfo("stale-key", "owner", 30*time.Second)
+	if ok, err := lock.AcquireLock(ctx, lockInfo); err != nil || !ok {
+		t.Fatalf("Expected stale lock to be broken, got %v, %v", ok, err)
+	}
+
+	// The old holder's file was replaced, so it can no longer renew
+	staleInfo := NewDistributedLockInfo("stale-key", "ghost", 30*time.Second)
+	staleInfo.handle = &fileHandle{file: leaked, path: path}
+	if err := lock.RenewLock(ctx, staleInfo); !errors.Is(err, ErrLockLost) {
+		t.Errorf("Expected ErrLockLost for the replaced holder, got %v", err)
+	}
+}
+
+// TestFileLockTakeoverOfCrashedFile tests that the holder of a file left by a
+// crashed process is not broken as stale by a concurrent contender
+func TestFileLockTakeoverOfCrashedFile(t *testing.T) {
+	ctx := context.Background()
+	lock, err := NewFileLock(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create file lock: %v", err)
+	}
+
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("Cannot start helper process: %v", err)
+	}
+
+	// A crashed holder leaves its file behind, unlocked, naming the dead PID
+	path := lock.lockPath("crashed-key")
+	data, _ := json.Marshal(fileLockMetadata{Owner: "ghost", PID: cmd.Process.Pid, Hostname: lock.hostname})
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("Failed to write lock file: %v", err)
+	}
+
+	first := NewDistributedLockInfo("crashed-key", "first", 30*time.Second)
+	if ok, err := lock.AcquireLock(ctx, first); err != nil || !ok {
+		t.Fatalf("Expected crashed file to be taken over, got %v, %v", ok, err)
+	}
+	if owner, pid, _ := lock.Holder("crashed-key"); owner != "first" || pid != os.Getpid() {
+		t.Errorf("Expected metadata of the new holder, got %s/%d", owner, pid)
+	}
+
+	second := NewDistributedLockInfo("crashed-key", "second", 30*time.Second)
+	if ok, err := lock.AcquireLock(ctx, second); err != nil || ok {
+		t.Errorf("Expected contender to fail, got %v, %v", ok, err)
+	}
+	if lock.breakStale(path) {
+		t.Error("Expected a live holder's file not to be broken")
+	}
+	if err := lock.RenewLock(ctx, first); err != nil {
+		t.Errorf("Expected first holder to keep the lock, got %v", err)
+	}
+}
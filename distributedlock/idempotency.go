@@ -0,0 +1,238 @@
+package distributedlock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	// idempotencyLockPrefix is put in front of the lock key of a request
+	idempotencyLockPrefix = "idempotency/"
+	// idempotencyRecordPrefix is put in front of the backend key of a record
+	idempotencyRecordPrefix = "idempotency-record/"
+)
+
+var (
+	// ErrIdempotencyUnsupported is returned for backends that cannot store records
+	ErrIdempotencyUnsupported = errors.New("idempotency store not supported by this backend")
+	// ErrRequestInProgress is returned for a duplicate of an in-flight request
+	// when the store does not wait
+	ErrRequestInProgress = errors.New("request already in progress")
+)
+
+// IdempotencyState is the processing state of a request key
+type IdempotencyState string
+
+const (
+	// IdempotencyInProgress means a replica holds the request lock and is processing it
+	IdempotencyInProgress IdempotencyState = "in-progress"
+	// IdempotencyCompleted means the response is stored and is replayed to duplicates
+	IdempotencyCompleted IdempotencyState = "completed"
+	// IdempotencyFailed means the last attempt failed; the next request retries
+	IdempotencyFailed IdempotencyState = "failed"
+)
+
+// IdempotencyRecord is the stored state of a request key
+type IdempotencyRecord struct {
+	State     IdempotencyState `json:"state"`
+	Response  []byte           `json:"response,omitempty"`
+	Error     string           `json:"error,omitempty"`
+	UpdatedAt time.Time        `json:"updated_at"`
+}
+
+// IdempotencyStore processes each request key at most once. The replica
+// that gets the request lock marks the key in progress and keeps the lock
+// renewed by the watchdog while it runs; a successful response is stored
+// as completed and replayed to every duplicate for the retention period. A
+// failed attempt is stored as failed and the next duplicate retries it.
+//
+// An in-progress record without a held lock belongs to a replica that died;
+// the next request takes it over. fn's context is cancelled when the lock
+// is lost, and the outcome of such an attempt is not stored, as another
+// replica may already process the request. Errors that must not be retried
+// should be returned as a response rather than as an error.
+type IdempotencyStore struct {
+	service      DistributedLockService
+	store        ttlStore
+	prefix       string
+	lockPrefix   string
+	retention    time.Duration
+	expiration   time.Duration
+	pollInterval time.Duration
+	wait         bool
+}
+
+// NewIdempotencyStore creates a store on the backend under service (see
+// newTTLStore). Records are kept for retention.
+func NewIdempotencyStore(service DistributedLockService, retention time.Duration) (*IdempotencyStore, error) {
+	if retention < time.Millisecond {
+		return nil, fmt.Errorf("invalid retention: %v", retention)
+	}
+	store, prefix, err := newTTLStore(service, ErrIdempotencyUnsupported)
+	if err != nil {
+		return nil, err
+	}
+	return &IdempotencyStore{
+		service:      service,
+		store:        store,
+		prefix:       prefix + idempotencyRecordPrefix,
+		lockPrefix:   prefix + idempotencyLockPrefix,
+		retention:    retention,
+		expiration:   defaultLockExpiration,
+		pollInterval: defaultOncePollInterval,
+		wait:         true,
+	}, nil
+}
+
+// SetLockExpiration sets the expiration of the request lock; the watchdog
+// keeps renewing it while the request is processed
+func (s *IdempotencyStore) SetLockExpiration(expiration time.Duration) {
+	s.expiration = expiration
+}
+
+// SetPollInterval sets how often duplicates look for the outcome of an
+// in-flight request on backends that cannot notify them (MySQL)
+func (s *IdempotencyStore) SetPollInterval(interval time.Duration) {
+	s.pollInterval = interval
+}
+
+// SetWait sets whether duplicates of an in-flight request wait for it (the
+// default) or fail right away with ErrRequestInProgress
+func (s *IdempotencyStore) SetWait(wait bool) {
+	s.wait = wait
+}
+
+// Get returns the record of key, or nil if the key is unknown
+func (s *IdempotencyStore) Get(ctx context.Context, key string) (*IdempotencyRecord, error) {
+	data, err := s.store.get(ctx, s.prefix+key)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	var record IdempotencyRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// Do processes the request key with fn unless it is already completed. It
+// returns the response and whether it was replayed from an earlier request.
+// A duplicate of an in-flight request waits for its outcome; if that attempt
+// fails, the duplicate retries with its own fn.
+func (s *IdempotencyStore) Do(ctx context.Context, key string, fn func(ctx context.Context) ([]byte, error)) ([]byte, bool, error) {
+	changed, timeout, stop, err := s.watch(ctx, key)
+	if err != nil {
+		return nil, false, err
+	}
+	defer stop()
+
+	var timer *time.Timer
+	for {
+		record, err := s.Get(ctx, key)
+		if err != nil {
+			return nil, false, err
+		}
+		if record != nil && record.State == IdempotencyCompleted {
+			return record.Response, true, nil
+		}
+
+		lock := newLockHandle(s.service, idempotencyLockPrefix+key, WithExpiration(s.expiration), WithRetry(1, 0))
+		acquired, err := lock.Lock(ctx)
+		if err != nil {
+			return nil, false, err
+		}
+		if acquired {
+			return s.process(ctx, key, lock, fn)
+		}
+		if !s.wait {
+			return nil, false, ErrRequestInProgress
+		}
+
+		if timer == nil {
+			timer = time.NewTimer(timeout)
+			defer timer.Stop()
+		} else {
+			timer.Reset(timeout)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		case <-changed:
+		case <-timer.C:
+		}
+	}
+}
+
+// watch starts following key for a duplicate, as Once.watch does for
+// waiters. Stores that do not wait need no watch.
+func (s *IdempotencyStore) watch(ctx context.Context, key string) (<-chan struct{}, time.Duration, func(), error) {
+	watcher, ok := s.store.(ttlWatcher)
+	if !ok || !s.wait {
+		return nil, s.pollInterval, func() {}, nil
+	}
+	changed, stop, err := watcher.watch(ctx, s.prefix+key, s.lockPrefix+key)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	return changed, s.expiration, stop, nil
+}
+
+// process runs fn under the request lock and stores the outcome before
+// unlocking and waking the duplicates, unless the lock was lost meanwhile
+func (s *IdempotencyStore) process(ctx context.Context, key string, lock *lockHandle, fn func(ctx context.Context) ([]byte, error)) ([]byte, bool, error) {
+	defer func() {
+		ctx := context.WithoutCancel(ctx)
+		if err := lock.Unlock(ctx); err != nil {
+			log.Printf("Idempotency %s failed to release lock: %v\n", key, err)
+		}
+		if watcher, ok := s.store.(ttlWatcher); ok {
+			if err := watcher.notify(ctx, s.prefix+key); err != nil {
+				log.Printf("Idempotency %s failed to wake duplicates: %v\n", key, err)
+			}
+		}
+	}()
+
+	// The previous holder may have completed between our lookup and the lock
+	record, err := s.Get(ctx, key)
+	if err != nil {
+		return nil, false, err
+	}
+	if record != nil && record.State == IdempotencyCompleted {
+		return record.Response, true, nil
+	}
+	if err := s.put(ctx, key, &IdempotencyRecord{State: IdempotencyInProgress}); err != nil {
+		return nil, false, err
+	}
+
+	workCtx, cancel := lock.lossContext(ctx)
+	response, fnErr := fn(workCtx)
+	cancel(nil)
+	if lock.isLost() {
+		return nil, false, fmt.Errorf("lock lost while processing %s, outcome not stored: %w", key, errors.Join(ErrLockLost, fnErr))
+	}
+
+	record = &IdempotencyRecord{State: IdempotencyCompleted, Response: response}
+	if fnErr != nil {
+		record = &IdempotencyRecord{State: IdempotencyFailed, Error: fnErr.Error()}
+	}
+	if err := s.put(context.WithoutCancel(ctx), key, record); err != nil {
+		if fnErr != nil {
+			return nil, false, fnErr
+		}
+		return response, false, fmt.Errorf("failed to store idempotent response: %w", err)
+	}
+	return response, false, fnErr
+}
+
+func (s *IdempotencyStore) put(ctx context.Context, key string, record *IdempotencyRecord) error {
+	record.UpdatedAt = time.Now()
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.store.put(ctx, s.prefix+key, data, s.retention)
+}
@@ -0,0 +1,277 @@
+package distributedlock
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+)
+
+// newTestIdempotencyStore returns a store on its own Redis client, like one replica
+func newTestIdempotencyStore(t *testing.T, mr *miniredis.Miniredis) *IdempotencyStore {
+	t.Helper()
+	lock := NewRedisLock(mr.Addr(), "", 0)
+	t.Cleanup(func() { lock.client.Close() })
+	store, err := NewIdempotencyStore(lock, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create idempotency store: %v", err)
+	}
+	store.SetPollInterval(10 * time.Millisecond)
+	return store
+}
+
+// TestIdempotencyDuplicatesReplay tests that concurrent duplicates process once and replay the response
+func TestIdempotencyDuplicatesReplay(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+
+	var calls int32
+	fn := func(ctx context.Context) ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(30 * time.Millisecond)
+		return []byte("charged"), nil
+	}
+
+	var wg sync.WaitGroup
+	var replays int32
+	for i := 0; i < 4; i++ {
+		store := newTestIdempotencyStore(t, mr)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response, replayed, err := store.Do(ctx, "payment-1", fn)
+			if err != nil || string(response) != "charged" {
+				t.Errorf("Expected charged, got %q, %v", response, err)
+			}
+			if replayed {
+				atomic.AddInt32(&replays, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if calls != 1 || replays != 3 {
+		t.Errorf("Expected one processing and three replays, got %d and %d", calls, replays)
+	}
+	record, err := newTestIdempotencyStore(t, mr).Get(ctx, "payment-1")
+	if err != nil || record == nil || record.State != IdempotencyCompleted {
+		t.Errorf("Expected completed record, got %+v, %v", record, err)
+	}
+}
+
+// TestIdempotencyWakesDuplicates tests that a waiting duplicate is woken by
+// the stored response instead of waiting for the next poll
+func TestIdempotencyWakesDuplicates(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	first := newTestIdempotencyStore(t, mr)
+	duplicate := newTestIdempotencyStore(t, mr)
+	duplicate.SetPollInterval(time.Hour)
+	duplicate.SetLockExpiration(time.Hour)
+
+	release := make(chan struct{})
+	running := make(chan struct{})
+	go first.Do(ctx, "payment-1", func(ctx context.Context) ([]byte, error) {
+		close(running)
+		<-release
+		return []byte("charged"), nil
+	})
+	<-running
+
+	done := make(chan []byte)
+	go func() {
+		response, _, _ := duplicate.Do(ctx, "payment-1", func(ctx context.Context) ([]byte, error) {
+			t.Error("Expected fn not to run again")
+			return nil, nil
+		})
+		done <- response
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	select {
+	case response := <-done:
+		if string(response) != "charged" {
+			t.Errorf("Expected the first response, got %q", response)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the duplicate to be woken by the stored response")
+	}
+}
+
+// TestIdempotencyFailedRetries tests that a failed attempt is recorded and retried by the next request
+func TestIdempotencyFailedRetries(t *testing.T) {
+	ctx := context.Background()
+	store := newTestIdempotencyStore(t, miniredis.RunT(t))
+
+	fnErr := errors.New("gateway timeout")
+	if _, _, err := store.Do(ctx, "payment-1", func(ctx context.Context) ([]byte, error) {
+		return nil, fnErr
+	}); err != fnErr {
+		t.Errorf("Expected fn's error, got %v", err)
+	}
+	record, _ := store.Get(ctx, "payment-1")
+	if record == nil || record.State != IdempotencyFailed || record.Error != "gateway timeout" {
+		t.Errorf("Expected failed record, got %+v", record)
+	}
+
+	response, replayed, err := store.Do(ctx, "payment-1", func(ctx context.Context) ([]byte, error) {
+		return []byte("charged"), nil
+	})
+	if err != nil || replayed || string(response) != "charged" {
+		t.Errorf("Expected retry to process, got %q, %v, %v", response, replayed, err)
+	}
+}
+
+// TestIdempotencyInProgress tests duplicates of an in-flight request and takeover after a crash
+func TestIdempotencyInProgress(t *testing.T) {
+	ctx := context.Background()
+	store := newTestIdempotencyStore(t, miniredis.RunT(t))
+	store.SetWait(false)
+
+	// A replica that crashed mid-request: in-progress record, lock still held
+	crashed := newLockHandle(store.service, idempotencyLockPrefix+"payment-1", WithRetry(1, 0))
+	crashed.Lock(ctx)
+	store.put(ctx, "payment-1", &IdempotencyRecord{State: IdempotencyInProgress})
+
+	fn := func(ctx context.Context) ([]byte, error) { return []byte("charged"), nil }
+	if _, _, err := store.Do(ctx, "payment-1", fn); !errors.Is(err, ErrRequestInProgress) {
+		t.Errorf("Expected ErrRequestInProgress, got %v", err)
+	}
+
+	// Its lock expires; the record alone does not block the retry
+	crashed.Unlock(ctx)
+	response, replayed, err := store.Do(ctx, "payment-1", fn)
+	if err != nil || replayed || string(response) != "charged" {
+		t.Errorf("Expected takeover to process, got %q, %v, %v", response, replayed, err)
+	}
+}
+
+// TestIdempotencyLockLost tests that fn sees a lost lock and its outcome is not stored
+func TestIdempotencyLockLost(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	store := newTestIdempotencyStore(t, mr)
+	store.SetLockExpiration(100 * time.Millisecond)
+
+	var cause error
+	_, _, err := store.Do(ctx, "payment-1", func(ctx context.Context) ([]byte, error) {
+		// Another replica takes the expired lock over
+		mr.Del(idempotencyLockPrefix + "payment-1")
+		<-ctx.Done()
+		cause = context.Cause(ctx)
+		return []byte("charged"), nil
+	})
+	if !errors.Is(err, ErrLockLost) || !errors.Is(cause, ErrLockLost) {
+		t.Errorf("Expected fn and Do to see ErrLockLost, got %v and %v", cause, err)
+	}
+
+	record, err := store.Get(ctx, "payment-1")
+	if err != nil || record == nil || record.State != IdempotencyInProgress {
+		t.Errorf("Expected the record to stay in progress, got %+v, %v", record, err)
+	}
+}
+
+// TestIdempotencyQuota tests that a full namespace fails the request instead of waiting
+func TestIdempotencyQuota(t *testing.T) {
+	ctx := context.Background()
+	lock, _ := newTestRedisLock(t)
+	ns, err := NewNamespacedService(lock, Namespace{Prefix: "tenant", MaxLocks: 1})
+	if err != nil {
+		t.Fatalf("Failed to create namespace: %v", err)
+	}
+	other := ns.NewLock("other", WithRetry(1, 0))
+	other.Lock(ctx)
+	defer other.Unlock(ctx)
+
+	store, err := NewIdempotencyStore(ns, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create idempotency store: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	_, _, err = store.Do(ctx, "payment-1", func(ctx context.Context) ([]byte, error) {
+		return []byte("charged"), nil
+	})
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected ErrQuotaExceeded, got %v", err)
+	}
+}
+
+// TestIdempotencyMySQLStore tests the record lookup on the MySQL result table
+func TestIdempotencyMySQLStore(t *testing.T) {
+	lock, mock := newTestMySQLLock(t)
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS distributed_results (
+	name       VARCHAR(255) NOT NULL,
+	value      MEDIUMBLOB   NOT NULL,
+	expires_at DATETIME(3)  NOT NULL,
+	PRIMARY KEY (name),
+	KEY (expires_at)
+) ENGINE=InnoDB`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT value FROM distributed_results WHERE name = ? AND expires_at > NOW(3)").
+		WithArgs("idempotency-record/payment-1").
+		WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(`{"state":"completed","response":"b2s="}`))
+
+	store, err := NewIdempotencyStore(lock, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create idempotency store: %v", err)
+	}
+	record, err := store.Get(context.Background(), "payment-1")
+	if err != nil || record == nil || record.State != IdempotencyCompleted || string(record.Response) != "ok" {
+		t.Errorf("Expected completed record with ok, got %+v, %v", record, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unmet expectations: %v", err)
+	}
+}
+
+// TestMySQLTTLStoreDeletesExpired tests that a put deletes expired rows
+// once per GC interval
+func TestMySQLTTLStoreDeletesExpired(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("Failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS distributed_results (
+	name       VARCHAR(255) NOT NULL,
+	value      MEDIUMBLOB   NOT NULL,
+	expires_at DATETIME(3)  NOT NULL,
+	PRIMARY KEY (name),
+	KEY (expires_at)
+) ENGINE=InnoDB`).WillReturnResult(sqlmock.NewResult(0, 0))
+	put := `INSERT INTO distributed_results (name, value, expires_at)
+VALUES (?, ?, NOW(3) + INTERVAL ? MICROSECOND)
+ON DUPLICATE KEY UPDATE value = VALUES(value), expires_at = VALUES(expires_at)`
+	mock.ExpectExec(put).WithArgs("a", []byte("1"), int64(1000000)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM distributed_results WHERE expires_at < NOW(3) LIMIT 1000").WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(put).WithArgs("b", []byte("2"), int64(1000000)).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	store, err := newMySQLTTLStore(db)
+	if err != nil {
+		t.Fatalf("Failed to create TTL store: %v", err)
+	}
+	ctx := context.Background()
+	if err := store.put(ctx, "a", []byte("1"), time.Second); err != nil {
+		t.Fatalf("Expected put to succeed, got %v", err)
+	}
+	if err := store.put(ctx, "b", []byte("2"), time.Second); err != nil {
+		t.Fatalf("Expected put to succeed, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unmet expectations: %v", err)
+	}
+}
+
+// TestIdempotencyUnsupported tests that backends without a record store are refused
+func TestIdempotencyUnsupported(t *testing.T) {
+	lock, _ := NewFileLock(t.TempDir())
+	if _, err := NewIdempotencyStore(lock, time.Hour); !errors.Is(err, ErrIdempotencyUnsupported) {
+		t.Errorf("Expected ErrIdempotencyUnsupported, got %v", err)
+	}
+}
@@ -3,10 +3,11 @@ package distributedlock
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
+	"sort"
 	"sync"
 	"time"
-
-	"go.etcd.io/etcd/client/v3/concurrency"
 )
 
 var (
@@ -14,19 +15,31 @@ var (
 	ErrLockNotHeld = errors.New("lock not held")
 	// ErrLockNotAcquired is returned when a lock cannot be acquired
 	ErrLockNotAcquired = errors.New("failed to acquire lock")
+	// ErrLockLost is returned when a held lock was taken away by the backend,
+	// e.g. because its session or connection died
+	ErrLockLost = errors.New("lock lost")
+	// ErrServiceNotFound is returned when no service is registered under a name
+	ErrServiceNotFound = errors.New("service not found")
+	// ErrServiceExists is returned when a name is already taken in the registry
+	ErrServiceExists = errors.New("service already registered")
+	// ErrQuotaExceeded is returned when a namespace already holds its maximum number of locks
+	ErrQuotaExceeded = errors.New("lock quota exceeded")
 )
 
 type DistributedLockInfo struct {
-	key         string
-	value       string
-	expiration  time.Duration
-	mutex       sync.Mutex
-	locked      bool
-	stopChan    chan struct{}
-	failTrys    int
-	failDelay   time.Duration
-	etcdSession *concurrency.Session
-	etcdMutex   *concurrency.Mutex
+	key        string
+	value      string
+	expiration time.Duration
+	mutex      sync.Mutex
+	locked     bool
+	stopChan   chan struct{}
+	failTrys   int
+	failDelay  time.Duration
+	// lost is closed by the watchdog when renewing the held lock fails
+	lost chan struct{}
+	// handle holds backend-private state of a held lock; only the backend
+	// that acquired the lock knows its concrete type
+	handle interface{}
 }
 
 type DistributedLockService interface {
@@ -59,20 +72,75 @@ func (dl *DistributedLockInfo) SetRetry(tries int, delay time.Duration) {
 	dl.failDelay = delay
 }
 
-// RegisterService registers a new distributed lock service
-func RegisterService(service DistributedLockService) {
+// RegisterService registers a service under its BuildServiceType name
+func RegisterService(service DistributedLockService) error {
+	return RegisterNamedService(service.BuildServiceType(), service)
+}
+
+// RegisterNamedService registers a service under an explicit name, so several
+// instances of the same backend type (e.g. "redis-payments" and "redis-search")
+// can live side by side. Registering a name twice returns ErrServiceExists.
+func RegisterNamedService(name string, service DistributedLockService) error {
+	if name == "" {
+		return errors.New("service name must not be empty")
+	}
 	serviceMutex.Lock()
 	defer serviceMutex.Unlock()
-	serviceContainer[service.BuildServiceType()] = service
+	if _, ok := serviceContainer[name]; ok {
+		return fmt.Errorf("%w: %s", ErrServiceExists, name)
+	}
+	serviceContainer[name] = service
+	return nil
 }
 
-// GetService returns a registered service by its type
-func GetService(serviceType string) (DistributedLockService, error) {
+// GetService returns a registered service by its name
+func GetService(name string) (DistributedLockService, error) {
 	serviceMutex.RLock()
 	defer serviceMutex.RUnlock()
 
-	if service, ok := serviceContainer[serviceType]; ok {
+	if service, ok := serviceContainer[name]; ok {
 		return service, nil
 	}
-	return nil, errors.New("service not found")
+	return nil, fmt.Errorf("%w: %s", ErrServiceNotFound, name)
+}
+
+// ServiceNames returns the sorted names of all registered services
+func ServiceNames() []string {
+	serviceMutex.RLock()
+	defer serviceMutex.RUnlock()
+
+	names := make([]string, 0, len(serviceContainer))
+	for name := range serviceContainer {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// UnregisterService removes a service from the registry and closes it if it
+// holds resources (implements io.Closer)
+func UnregisterService(name string) error {
+	serviceMutex.Lock()
+	service, ok := serviceContainer[name]
+	delete(serviceContainer, name)
+	serviceMutex.Unlock()
+
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrServiceNotFound, name)
+	}
+	if closer, ok := service.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// CloseServices unregisters and closes every registered service
+func CloseServices() error {
+	var errs []error
+	for _, name := range ServiceNames() {
+		if err := UnregisterService(name); err != nil && !errors.Is(err, ErrServiceNotFound) {
+			errs = append(errs, fmt.Errorf("close %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
 }
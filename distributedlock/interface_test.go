@@ -2,6 +2,7 @@ package distributedlock
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 )
@@ -173,3 +174,70 @@ func (m *mockLockService) RenewLock(ctx context.Context, lockInfo *DistributedLo
 func (m *mockLockService) BuildServiceType() string {
 	return m.serviceType
 }
+
+// TestRegisterNamedServiceDuplicate tests that a name cannot be registered twice
+func TestRegisterNamedServiceDuplicate(t *testing.T) {
+	defer UnregisterService("redis-payments")
+
+	if err := RegisterNamedService("redis-payments", &mockLockService{serviceType: "redis"}); err != nil {
+		t.Fatalf("Failed to register service: %v", err)
+	}
+	err := RegisterNamedService("redis-payments", &mockLockService{serviceType: "redis"})
+	if !errors.Is(err, ErrServiceExists) {
+		t.Errorf("Expected ErrServiceExists, got %v", err)
+	}
+}
+
+// TestNamedServicesOfSameType tests that two instances of one backend type coexist
+func TestNamedServicesOfSameType(t *testing.T) {
+	payments := &mockLockService{serviceType: "redis"}
+	search := &mockLockService{serviceType: "redis"}
+	defer UnregisterService("redis-payments")
+	defer UnregisterService("redis-search")
+
+	if err := RegisterNamedService("redis-payments", payments); err != nil {
+		t.Fatalf("Failed to register payments: %v", err)
+	}
+	if err := RegisterNamedService("redis-search", search); err != nil {
+		t.Fatalf("Failed to register search: %v", err)
+	}
+
+	got, err := GetService("redis-search")
+	if err != nil {
+		t.Fatalf("Failed to get service: %v", err)
+	}
+	if got != search {
+		t.Error("Expected the redis-search instance")
+	}
+}
+
+// TestUnregisterServiceCloses tests that unregistering closes the service
+func TestUnregisterServiceCloses(t *testing.T) {
+	svc := &closableLockService{mockLockService: mockLockService{serviceType: "closable"}}
+	if err := RegisterNamedService("closable", svc); err != nil {
+		t.Fatalf("Failed to register service: %v", err)
+	}
+
+	if err := UnregisterService("closable"); err != nil {
+		t.Fatalf("Failed to unregister service: %v", err)
+	}
+	if !svc.closed {
+		t.Error("Expected service to be closed")
+	}
+	if _, err := GetService("closable"); !errors.Is(err, ErrServiceNotFound) {
+		t.Errorf("Expected ErrServiceNotFound, got %v", err)
+	}
+	if err := UnregisterService("closable"); !errors.Is(err, ErrServiceNotFound) {
+		t.Errorf("Expected ErrServiceNotFound on second unregister, got %v", err)
+	}
+}
+
+type closableLockService struct {
+	mockLockService
+	closed bool
+}
+
+func (c *closableLockService) Close() error {
+	c.closed = true
+	return nil
+}
@@ -0,0 +1,201 @@
+package distributedlock
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// maxLeaseNameLength keeps lease names well inside the DNS subdomain limit
+const maxLeaseNameLength = 63
+
+// leaseKeyAnnotation records the original lock key on the Lease object
+const leaseKeyAnnotation = "distributedlock/key"
+
+var invalidLeaseNameChars = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// KubernetesLock implements DistributedLockService on coordination.k8s.io/v1
+// Lease objects. The holder is lockInfo.value, the lease duration is the lock
+// expiration, and every change goes through a resourceVersion-checked Update,
+// so two clients can never both win a takeover.
+//
+// Expiry is judged from the lease's renewTime, so clocks of the participating
+// nodes should be roughly in sync (as they are for leader election).
+type KubernetesLock struct {
+	client    kubernetes.Interface
+	namespace string
+}
+
+// NewKubernetesLock creates a Kubernetes lock backend storing leases in namespace
+func NewKubernetesLock(client kubernetes.Interface, namespace string) *KubernetesLock {
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+	return &KubernetesLock{client: client, namespace: namespace}
+}
+
+func (k *KubernetesLock) AcquireLock(ctx context.Context, lockInfo *DistributedLockInfo) (bool, error) {
+	leases := k.client.CoordinationV1().Leases(k.namespace)
+	name := leaseName(lockInfo.key)
+	now := metav1.NewMicroTime(time.Now())
+
+	lease, err := leases.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = leases.Create(ctx, &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Annotations: map[string]string{leaseKeyAnnotation: lockInfo.key},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &lockInfo.value,
+				LeaseDurationSeconds: leaseDurationSeconds(lockInfo.expiration),
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if leaseHeld(lease, now.Time) {
+		return false, nil
+	}
+
+	// Take over a released or expired lease; the Update fails with a
+	// conflict if somebody else changed it since our Get
+	transitions := int32(0)
+	if lease.Spec.LeaseTransitions != nil {
+		transitions = *lease.Spec.LeaseTransitions
+	}
+	transitions++
+	lease.Spec.HolderIdentity = &lockInfo.value
+	lease.Spec.LeaseDurationSeconds = leaseDurationSeconds(lockInfo.expiration)
+	lease.Spec.AcquireTime = &now
+	lease.Spec.RenewTime = &now
+	lease.Spec.LeaseTransitions = &transitions
+
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	if apierrors.IsConflict(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (k *KubernetesLock) ReleaseLock(ctx context.Context, lockInfo *DistributedLockInfo) (bool, error) {
+	leases := k.client.CoordinationV1().Leases(k.namespace)
+
+	lease, err := leases.Get(ctx, leaseName(lockInfo.key), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !leaseHeldBy(lease, lockInfo.value) {
+		return false, nil
+	}
+
+	// Clear the holder instead of deleting, so LeaseTransitions keeps counting
+	lease.Spec.HolderIdentity = nil
+	lease.Spec.AcquireTime = nil
+	lease.Spec.RenewTime = nil
+
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	if apierrors.IsConflict(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// RenewLock moves the lease's renewTime forward if we still hold it
+func (k *KubernetesLock) RenewLock(ctx context.Context, lockInfo *DistributedLockInfo) error {
+	leases := k.client.CoordinationV1().Leases(k.namespace)
+
+	lease, err := leases.Get(ctx, leaseName(lockInfo.key), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return ErrLockLost
+	}
+	if err != nil {
+		return err
+	}
+	if !leaseHeldBy(lease, lockInfo.value) {
+		return ErrLockLost
+	}
+
+	now := metav1.NewMicroTime(time.Now())
+	lease.Spec.RenewTime = &now
+	lease.Spec.LeaseDurationSeconds = leaseDurationSeconds(lockInfo.expiration)
+
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	if apierrors.IsConflict(err) {
+		return ErrLockLost
+	}
+	return err
+}
+
+// NewLock returns a lock handle bound to this namespace
+func (k *KubernetesLock) NewLock(key string, opts ...LockOption) Locker {
+	return newLockHandle(k, key, opts...)
+}
+
+func (k *KubernetesLock) BuildServiceType() string {
+	return "kubernetes"
+}
+
+// leaseName maps a lock key to a valid Lease name. Keys that are already
+// valid names are used as-is; others are lowercased, stripped of invalid
+// characters and suffixed with a hash of the original key to stay unique.
+func leaseName(key string) string {
+	name := invalidLeaseNameChars.ReplaceAllString(strings.ToLower(key), "-")
+	name = strings.Trim(name, "-.")
+	if name == key && len(name) <= maxLeaseNameLength {
+		return name
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	suffix := hex.EncodeToString(sum[:])[:10]
+	if limit := maxLeaseNameLength - len(suffix) - 1; len(name) > limit {
+		name = strings.TrimRight(name[:limit], "-.")
+	}
+	if name == "" {
+		return "lock-" + suffix
+	}
+	return name + "-" + suffix
+}
+
+// leaseDurationSeconds converts a lock expiration into the lease duration field
+func leaseDurationSeconds(expiration time.Duration) *int32 {
+	seconds := int32(leaseSeconds(expiration))
+	return &seconds
+}
+
+// leaseHeld reports whether lease has a holder whose lease has not expired at now
+func leaseHeld(lease *coordinationv1.Lease, now time.Time) bool {
+	spec := lease.Spec
+	if spec.HolderIdentity == nil || *spec.HolderIdentity == "" {
+		return false
+	}
+	if spec.RenewTime == nil || spec.LeaseDurationSeconds == nil {
+		return false
+	}
+	expiry := spec.RenewTime.Add(time.Duration(*spec.LeaseDurationSeconds) * time.Second)
+	return now.Before(expiry)
+}
+
+// leaseHeldBy reports whether lease is currently held by holder
+func leaseHeldBy(lease *coordinationv1.Lease, holder string) bool {
+	return leaseHeld(lease, time.Now()) && *lease.Spec.HolderIdentity == holder
+}
@@ -0,0 +1,99 @@
+package distributedlock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// TestKubernetesLockLifecycle tests acquire, reject, renew and release on a Lease
+func TestKubernetesLockLifecycle(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewClientset()
+	lock := NewKubernetesLock(client, "locks")
+
+	first := NewDistributedLockInfo("orders", "pod-a", 30*time.Second)
+	second := NewDistributedLockInfo("orders", "pod-b", 30*time.Second)
+
+	if ok, err := lock.AcquireLock(ctx, first); err != nil || !ok {
+		t.Fatalf("Expected first to acquire the lock, got %v, %v", ok, err)
+	}
+	if ok, err := lock.AcquireLock(ctx, second); err != nil || ok {
+		t.Fatalf("Expected second to be rejected, got %v, %v", ok, err)
+	}
+
+	lease, err := client.CoordinationV1().Leases("locks").Get(ctx, "orders", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get lease: %v", err)
+	}
+	if *lease.Spec.HolderIdentity != "pod-a" || *lease.Spec.LeaseDurationSeconds != 30 {
+		t.Errorf("Unexpected lease spec: holder %s, duration %d", *lease.Spec.HolderIdentity, *lease.Spec.LeaseDurationSeconds)
+	}
+
+	if err := lock.RenewLock(ctx, first); err != nil {
+		t.Fatalf("Expected renew to succeed, got %v", err)
+	}
+	if err := lock.RenewLock(ctx, second); !errors.Is(err, ErrLockLost) {
+		t.Errorf("Expected ErrLockLost for a non-holder, got %v", err)
+	}
+	if ok, err := lock.ReleaseLock(ctx, first); err != nil || !ok {
+		t.Fatalf("Expected to release the lock, got %v, %v", ok, err)
+	}
+	if ok, err := lock.AcquireLock(ctx, second); err != nil || !ok {
+		t.Fatalf("Expected second to acquire after release, got %v, %v", ok, err)
+	}
+
+	lease, _ = client.CoordinationV1().Leases("locks").Get(ctx, "orders", metav1.GetOptions{})
+	if lease.Spec.LeaseTransitions == nil || *lease.Spec.LeaseTransitions != 1 {
+		t.Errorf("Expected one lease transition, got %v", lease.Spec.LeaseTransitions)
+	}
+}
+
+// TestKubernetesLockTakesOverExpiredLease tests that an expired holder can be replaced
+func TestKubernetesLockTakesOverExpiredLease(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewClientset()
+	lock := NewKubernetesLock(client, "")
+
+	stale := NewDistributedLockInfo("orders", "pod-a", time.Second)
+	if ok, err := lock.AcquireLock(ctx, stale); err != nil || !ok {
+		t.Fatalf("Expected to acquire the lock, got %v, %v", ok, err)
+	}
+
+	// Age the lease past its duration
+	leases := client.CoordinationV1().Leases(metav1.NamespaceDefault)
+	lease, _ := leases.Get(ctx, "orders", metav1.GetOptions{})
+	old := metav1.NewMicroTime(time.Now().Add(-time.Minute))
+	lease.Spec.RenewTime = &old
+	leases.Update(ctx, lease, metav1.UpdateOptions{})
+
+	next := NewDistributedLockInfo("orders", "pod-b", 30*time.Second)
+	if ok, err := lock.AcquireLock(ctx, next); err != nil || !ok {
+		t.Fatalf("Expected to take over the expired lease, got %v, %v", ok, err)
+	}
+	if err := lock.RenewLock(ctx, stale); !errors.Is(err, ErrLockLost) {
+		t.Errorf("Expected ErrLockLost for the old holder, got %v", err)
+	}
+}
+
+// TestLeaseName tests mapping lock keys to valid Lease names
+func TestLeaseName(t *testing.T) {
+	if got := leaseName("orders"); got != "orders" {
+		t.Errorf("Expected valid key to be kept, got %s", got)
+	}
+	a, b := leaseName("Jobs/Nightly"), leaseName("jobs/nightly")
+	if a == b {
+		t.Errorf("Expected keys differing in case to map to different names, both got %s", a)
+	}
+	if !invalidLeaseNameChars.MatchString("Jobs/Nightly") || invalidLeaseNameChars.MatchString(a) {
+		t.Errorf("Expected %s to be a valid name", a)
+	}
+	long := leaseName(string(make([]byte, 300)))
+	if len(long) > maxLeaseNameLength {
+		t.Errorf("Expected name of at most %d chars, got %d", maxLeaseNameLength, len(long))
+	}
+}
@@ -0,0 +1,106 @@
+package distributedlock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"math"
+	"time"
+)
+
+// Locker is a lock handle bound to the service instance that created it.
+// Unlike the string-based DistributedLockInfo API, a Locker never looks up
+// its backend in the global registry, so one process can hold locks on
+// several backends (or several instances of the same backend) at once.
+type Locker interface {
+	// Lock tries to acquire the lock using the configured retry policy
+	Lock(ctx context.Context) (bool, error)
+	// Unlock releases the lock and stops its watchdog
+	Unlock(ctx context.Context) error
+	// Key returns the lock key
+	Key() string
+	// Locked reports whether the handle currently believes it holds the lock
+	Locked() bool
+}
+
+// LockerFactory is implemented by services that hand out bound lock handles
+type LockerFactory interface {
+	NewLock(key string, opts ...LockOption) Locker
+}
+
+// LockOption configures a lock created through LockerFactory.NewLock
+type LockOption func(*DistributedLockInfo)
+
+// defaultLockExpiration is used when no WithExpiration option is given
+const defaultLockExpiration = 30 * time.Second
+
+// WithValue sets the owner token stored in the lock. By default a random token is generated.
+func WithValue(value string) LockOption {
+	return func(dl *DistributedLockInfo) {
+		dl.value = value
+	}
+}
+
+// WithExpiration sets the lock expiration
+func WithExpiration(expiration time.Duration) LockOption {
+	return func(dl *DistributedLockInfo) {
+		dl.expiration = expiration
+	}
+}
+
+// WithRetry sets the number of acquire attempts and the delay between them
+func WithRetry(tries int, delay time.Duration) LockOption {
+	return func(dl *DistributedLockInfo) {
+		dl.SetRetry(tries, delay)
+	}
+}
+
+// lockHandle is the Locker shared by all backends. Backend-private state of
+// a held lock lives in DistributedLockInfo.handle and is owned by the service.
+type lockHandle struct {
+	info    *DistributedLockInfo
+	service DistributedLockService
+}
+
+// newLockHandle creates a Locker bound to service
+func newLockHandle(service DistributedLockService, key string, opts ...LockOption) *lockHandle {
+	info := NewDistributedLockInfo(key, "", defaultLockExpiration)
+	for _, opt := range opts {
+		opt(info)
+	}
+	if info.value == "" {
+		info.value = newLockToken()
+	}
+	return &lockHandle{info: info, service: service}
+}
+
+func (h *lockHandle) Lock(ctx context.Context) (bool, error) {
+	return h.info.acquire(ctx, h.service)
+}
+
+func (h *lockHandle) Unlock(ctx context.Context) error {
+	return h.info.release(ctx, h.service)
+}
+
+func (h *lockHandle) Key() string {
+	return h.info.key
+}
+
+func (h *lockHandle) Locked() bool {
+	h.info.mutex.Lock()
+	defer h.info.mutex.Unlock()
+	return h.info.locked
+}
+
+// newLockToken returns a random owner token
+func newLockToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// leaseSeconds rounds a TTL up to the whole seconds (at least 1) that
+// lease-based backends work in
+func leaseSeconds(ttl time.Duration) int64 {
+	return max(int64(math.Ceil(ttl.Seconds())), 1)
+}
@@ -0,0 +1,59 @@
+package distributedlock
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestLockerWithoutRegistry tests that a handle works without registering its service
+func TestLockerWithoutRegistry(t *testing.T) {
+	ctx := context.Background()
+	svc := &mockLockService{serviceType: "unregistered-mock"}
+
+	lock := newLockHandle(svc, "handle-key", WithExpiration(time.Minute), WithRetry(1, time.Millisecond))
+
+	if lock.Key() != "handle-key" {
+		t.Errorf("Expected key handle-key, got %s", lock.Key())
+	}
+	if lock.info.value == "" {
+		t.Error("Expected a generated owner token")
+	}
+
+	for round := 0; round < 2; round++ {
+		acquired, err := lock.Lock(ctx)
+		if err != nil || !acquired {
+			t.Fatalf("Round %d: expected to acquire the lock, got %v, %v", round, acquired, err)
+		}
+		if !lock.Locked() {
+			t.Fatalf("Round %d: expected handle to be locked", round)
+		}
+		if err := lock.Unlock(ctx); err != nil {
+			t.Fatalf("Round %d: failed to unlock: %v", round, err)
+		}
+		if lock.Locked() {
+			t.Fatalf("Round %d: expected handle to be unlocked", round)
+		}
+	}
+}
+
+// TestLockOptions tests that options are applied to the lock info
+func TestLockOptions(t *testing.T) {
+	svc := &mockLockService{serviceType: "unregistered-mock"}
+
+	lock := newLockHandle(svc, "handle-key",
+		WithValue("owner-1"),
+		WithExpiration(10*time.Second),
+		WithRetry(7, 5*time.Millisecond),
+	)
+
+	if lock.info.value != "owner-1" {
+		t.Errorf("Expected value owner-1, got %s", lock.info.value)
+	}
+	if lock.info.expiration != 10*time.Second {
+		t.Errorf("Expected expiration 10s, got %v", lock.info.expiration)
+	}
+	if lock.info.failTrys != 7 || lock.info.failDelay != 5*time.Millisecond {
+		t.Errorf("Expected retry 7/5ms, got %d/%v", lock.info.failTrys, lock.info.failDelay)
+	}
+}
@@ -0,0 +1,42 @@
+package distributedlock
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// markerPrefix is put in front of the backend key of a marker
+const markerPrefix = "marker/"
+
+// ErrMarkerUnsupported is returned for backends that cannot store markers
+var ErrMarkerUnsupported = errors.New("markers not supported by this backend")
+
+// MarkerStore records that keys are done, e.g. that a job occurrence ran,
+// next to the locks of a Redis, etcd or MySQL backend. A marker outlives
+// the lock that guarded the work, so late replicas can still see it.
+type MarkerStore struct {
+	store  ttlStore
+	prefix string
+}
+
+// NewMarkerStore creates a marker store on the backend under service (see
+// newTTLStore)
+func NewMarkerStore(service DistributedLockService) (*MarkerStore, error) {
+	store, prefix, err := newTTLStore(service, ErrMarkerUnsupported)
+	if err != nil {
+		return nil, err
+	}
+	return &MarkerStore{store: store, prefix: prefix + markerPrefix}, nil
+}
+
+// Mark records key as done for ttl
+func (m *MarkerStore) Mark(ctx context.Context, key string, ttl time.Duration) error {
+	return m.store.put(ctx, m.prefix+key, []byte(time.Now().UTC().Format(time.RFC3339Nano)), ttl)
+}
+
+// Marked reports whether key is marked as done
+func (m *MarkerStore) Marked(ctx context.Context, key string) (bool, error) {
+	data, err := m.store.get(ctx, m.prefix+key)
+	return data != nil, err
+}
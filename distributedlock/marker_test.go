@@ -0,0 +1,45 @@
+package distributedlock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestMarkerStore tests marking keys and their expiry
+func TestMarkerStore(t *testing.T) {
+	ctx := context.Background()
+	lock, mr := newTestRedisLock(t)
+	ns, _ := NewNamespacedService(lock, Namespace{Prefix: "tenant"})
+	markers, err := NewMarkerStore(ns)
+	if err != nil {
+		t.Fatalf("Failed to create marker store: %v", err)
+	}
+
+	if marked, err := markers.Marked(ctx, "job/1"); err != nil || marked {
+		t.Fatalf("Expected unknown key not to be marked, got %v, %v", marked, err)
+	}
+	if err := markers.Mark(ctx, "job/1", time.Minute); err != nil {
+		t.Fatalf("Failed to mark: %v", err)
+	}
+	if marked, err := markers.Marked(ctx, "job/1"); err != nil || !marked {
+		t.Errorf("Expected key to be marked, got %v, %v", marked, err)
+	}
+	if !mr.Exists("tenant/marker/job/1") {
+		t.Errorf("Expected the marker under the namespace prefix, got keys %v", mr.Keys())
+	}
+
+	mr.FastForward(2 * time.Minute)
+	if marked, _ := markers.Marked(ctx, "job/1"); marked {
+		t.Error("Expected the marker to expire")
+	}
+}
+
+// TestMarkerStoreUnsupported tests that backends without a store are refused
+func TestMarkerStoreUnsupported(t *testing.T) {
+	lock, _ := NewFileLock(t.TempDir())
+	if _, err := NewMarkerStore(lock); !errors.Is(err, ErrMarkerUnsupported) {
+		t.Errorf("Expected ErrMarkerUnsupported, got %v", err)
+	}
+}
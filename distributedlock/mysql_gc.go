@@ -0,0 +1,49 @@
+package distributedlock
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+)
+
+// defaultMySQLExpiryGCInterval is how often expired rows are deleted
+const defaultMySQLExpiryGCInterval = time.Minute
+
+// mysqlExpiryGC deletes the expired rows of a table with an expires_at
+// column. It runs on the caller's writes at most once per interval, so the
+// stores using it need no background goroutine or Close.
+type mysqlExpiryGC struct {
+	db       *sql.DB
+	table    string
+	interval time.Duration
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+func newMySQLExpiryGC(db *sql.DB, table string) *mysqlExpiryGC {
+	return &mysqlExpiryGC{db: db, table: table, interval: defaultMySQLExpiryGCInterval}
+}
+
+// collect deletes a batch of expired rows if the last run is an interval ago.
+// Errors are only logged; the next interval retries.
+func (g *mysqlExpiryGC) collect(ctx context.Context) {
+	g.mu.Lock()
+	if time.Since(g.last) < g.interval {
+		g.mu.Unlock()
+		return
+	}
+	g.last = time.Now()
+	g.mu.Unlock()
+
+	res, err := g.db.ExecContext(ctx, `DELETE FROM `+g.table+` WHERE expires_at < NOW(3) LIMIT 1000`)
+	if err != nil {
+		log.Printf("MySQL GC of %s failed: %v\n", g.table, err)
+		return
+	}
+	if deleted, _ := res.RowsAffected(); deleted > 0 {
+		log.Printf("MySQL GC removed %d expired rows from %s\n", deleted, g.table)
+	}
+}
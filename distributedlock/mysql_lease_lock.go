@@ -0,0 +1,241 @@
+package distributedlock
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"regexp"
+	"sync"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// defaultLeaseTable is the table used by MySQLLeaseLock when none is configured
+const defaultLeaseTable = "distributed_locks"
+
+var tableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// MySQLLeaseLock implements DistributedLockService on a locks table. Unlike
+// GET_LOCK, every row carries its own expiry, so renewing is a conditional
+// UPDATE and there is no window in which another client can slip in.
+//
+// Each successful acquire yields a fencing token that strictly increases per
+// key. New rows start at the database clock in microseconds and takeovers use
+// GREATEST(fence + 1, now), so tokens stay monotonic even after expired rows
+// have been garbage-collected.
+type MySQLLeaseLock struct {
+	db        *sql.DB
+	table     string
+	stopGC    chan struct{}
+	closeOnce sync.Once
+}
+
+// mysqlLeaseHandle is the lease-private state of a held lock
+type mysqlLeaseHandle struct {
+	fence int64
+}
+
+// NewMySQLLeaseLock opens the database and creates the locks table if needed
+func NewMySQLLeaseLock(dsn, table string) (*MySQLLeaseLock, error) {
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
+	return NewMySQLLeaseLockWithDB(db, table)
+}
+
+// NewMySQLLeaseLockWithDB creates a lease lock on an existing connection
+// pool, which it owns from then on, and creates the locks table if needed
+func NewMySQLLeaseLockWithDB(db *sql.DB, table string) (*MySQLLeaseLock, error) {
+	if table == "" {
+		table = defaultLeaseTable
+	}
+	if !tableNamePattern.MatchString(table) {
+		db.Close()
+		return nil, fmt.Errorf("invalid lock table name: %q", table)
+	}
+
+	m := &MySQLLeaseLock{db: db, table: table, stopGC: make(chan struct{})}
+	if err := m.EnsureSchema(context.Background()); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create lock table: %v", err)
+	}
+	return m, nil
+}
+
+// EnsureSchema creates the locks table if it does not exist
+func (m *MySQLLeaseLock) EnsureSchema(ctx context.Context) error {
+	_, err := m.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+m.table+` (
+	lock_key   VARCHAR(255)    NOT NULL,
+	owner      VARCHAR(255)    NOT NULL,
+	expires_at DATETIME(3)     NOT NULL,
+	fence      BIGINT UNSIGNED NOT NULL,
+	PRIMARY KEY (lock_key),
+	KEY idx_expires_at (expires_at)
+) ENGINE=InnoDB`)
+	return err
+}
+
+func (m *MySQLLeaseLock) AcquireLock(ctx context.Context, lockInfo *DistributedLockInfo) (bool, error) {
+	// The assignments run left to right, so expires_at must be updated last:
+	// the conditions before it still see the old expiry.
+	res, err := m.db.ExecContext(ctx, `INSERT INTO `+m.table+` (lock_key, owner, expires_at, fence)
+VALUES (?, ?, NOW(3) + INTERVAL ? MICROSECOND, CAST(UNIX_TIMESTAMP(NOW(6)) * 1000000 AS UNSIGNED))
+ON DUPLICATE KEY UPDATE
+	fence = IF(expires_at < NOW(3), GREATEST(fence + 1, VALUES(fence)), fence),
+	owner = IF(expires_at < NOW(3), VALUES(owner), owner),
+	expires_at = IF(expires_at < NOW(3), VALUES(expires_at), expires_at)`,
+		mysqlRowKey(lockInfo.key), lockInfo.value, lockInfo.expiration.Microseconds())
+	if err != nil {
+		return false, err
+	}
+
+	// 1 row affected means inserted, 2 means an expired row was taken over,
+	// 0 means the row is held by somebody else
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if affected == 0 {
+		return false, nil
+	}
+
+	var fence int64
+	err = m.db.QueryRowContext(ctx, `SELECT fence FROM `+m.table+` WHERE lock_key = ? AND owner = ?`,
+		mysqlRowKey(lockInfo.key), lockInfo.value).Scan(&fence)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+
+	lockInfo.handle = &mysqlLeaseHandle{fence: fence}
+	return true, nil
+}
+
+func (m *MySQLLeaseLock) ReleaseLock(ctx context.Context, lockInfo *DistributedLockInfo) (bool, error) {
+	h, ok := lockInfo.handle.(*mysqlLeaseHandle)
+	if !ok {
+		return false, ErrLockNotHeld
+	}
+
+	// Expire the row instead of deleting it, so the fence keeps counting up
+	res, err := m.db.ExecContext(ctx, `UPDATE `+m.table+` SET expires_at = NOW(3) - INTERVAL 1 MICROSECOND
+WHERE lock_key = ? AND owner = ? AND fence = ? AND expires_at >= NOW(3)`,
+		mysqlRowKey(lockInfo.key), lockInfo.value, h.fence)
+	if err != nil {
+		return false, err
+	}
+	lockInfo.handle = nil
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// RenewLock extends the expiry of a lock that is still owned and not expired
+func (m *MySQLLeaseLock) RenewLock(ctx context.Context, lockInfo *DistributedLockInfo) error {
+	h, ok := lockInfo.handle.(*mysqlLeaseHandle)
+	if !ok {
+		return ErrLockNotHeld
+	}
+
+	res, err := m.db.ExecContext(ctx, `UPDATE `+m.table+` SET expires_at = NOW(3) + INTERVAL ? MICROSECOND
+WHERE lock_key = ? AND owner = ? AND fence = ? AND expires_at >= NOW(3)`,
+		lockInfo.expiration.Microseconds(), mysqlRowKey(lockInfo.key), lockInfo.value, h.fence)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 1 {
+		return nil
+	}
+
+	// MySQL reports 0 rows when the new expiry equals the old one, so check
+	// ownership explicitly before declaring the lock lost
+	var owned bool
+	err = m.db.QueryRowContext(ctx, `SELECT COUNT(*) > 0 FROM `+m.table+`
+WHERE lock_key = ? AND owner = ? AND fence = ? AND expires_at >= NOW(3)`,
+		mysqlRowKey(lockInfo.key), lockInfo.value, h.fence).Scan(&owned)
+	if err != nil {
+		return err
+	}
+	if !owned {
+		lockInfo.handle = nil
+		return ErrLockLost
+	}
+	return nil
+}
+
+// FencingToken returns the fencing token of a held lock. Pass it along with
+// every write to the protected resource and reject writes carrying a smaller token.
+func (m *MySQLLeaseLock) FencingToken(lockInfo *DistributedLockInfo) (int64, error) {
+	h, ok := lockInfo.handle.(*mysqlLeaseHandle)
+	if !ok {
+		return 0, ErrLockNotHeld
+	}
+	return h.fence, nil
+}
+
+// CollectGarbage deletes rows that expired more than retention ago and
+// returns the number of deleted rows
+func (m *MySQLLeaseLock) CollectGarbage(ctx context.Context, retention time.Duration) (int64, error) {
+	res, err := m.db.ExecContext(ctx, `DELETE FROM `+m.table+` WHERE expires_at < NOW(3) - INTERVAL ? MICROSECOND`,
+		retention.Microseconds())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// StartGC runs CollectGarbage every interval until Close is called. Rows are
+// kept for one interval after expiry.
+func (m *MySQLLeaseLock) StartGC(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				deleted, err := m.CollectGarbage(context.Background(), interval)
+				if err != nil {
+					log.Printf("MySQL lease GC failed: %v\n", err)
+				} else if deleted > 0 {
+					log.Printf("MySQL lease GC removed %d expired locks\n", deleted)
+				}
+			case <-m.stopGC:
+				return
+			}
+		}
+	}()
+}
+
+// NewLock returns a lock handle bound to this MySQL lease table
+func (m *MySQLLeaseLock) NewLock(key string, opts ...LockOption) Locker {
+	return newLockHandle(m, key, opts...)
+}
+
+func (m *MySQLLeaseLock) BuildServiceType() string {
+	return "mysql-lease"
+}
+
+// Close stops garbage collection and closes the database connection
+func (m *MySQLLeaseLock) Close() error {
+	var err error
+	m.closeOnce.Do(func() {
+		close(m.stopGC)
+		err = m.db.Close()
+	})
+	return err
+}
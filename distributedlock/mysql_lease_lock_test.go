@@ -0,0 +1,132 @@
+package distributedlock
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func newTestMySQLLeaseLock(t *testing.T) (*MySQLLeaseLock, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create sqlmock: %v", err)
+	}
+	lock := &MySQLLeaseLock{db: db, table: defaultLeaseTable, stopGC: make(chan struct{})}
+	t.Cleanup(func() { lock.Close() })
+	return lock, mock
+}
+
+// TestMySQLLeaseLockLifecycle tests acquire, renew and release with a fencing token
+func TestMySQLLeaseLockLifecycle(t *testing.T) {
+	ctx := context.Background()
+	lock, mock := newTestMySQLLeaseLock(t)
+	lockInfo := NewDistributedLockInfo("lease-key", "owner", 2*time.Second)
+
+	mock.ExpectExec("INSERT INTO distributed_locks .* ON DUPLICATE KEY UPDATE").
+		WithArgs("lease-key", "owner", int64(2000000)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT fence FROM distributed_locks").
+		WithArgs("lease-key", "owner").
+		WillReturnRows(sqlmock.NewRows([]string{"fence"}).AddRow(42))
+	mock.ExpectExec("UPDATE distributed_locks SET expires_at = NOW\\(3\\) \\+ INTERVAL").
+		WithArgs(int64(2000000), "lease-key", "owner", int64(42)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE distributed_locks SET expires_at = NOW\\(3\\) - INTERVAL").
+		WithArgs("lease-key", "owner", int64(42)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if ok, err := lock.AcquireLock(ctx, lockInfo); err != nil || !ok {
+		t.Fatalf("Expected to acquire the lock, got %v, %v", ok, err)
+	}
+	fence, err := lock.FencingToken(lockInfo)
+	if err != nil || fence != 42 {
+		t.Fatalf("Expected fencing token 42, got %d, %v", fence, err)
+	}
+	if err := lock.RenewLock(ctx, lockInfo); err != nil {
+		t.Fatalf("Expected renew to succeed, got %v", err)
+	}
+	if ok, err := lock.ReleaseLock(ctx, lockInfo); err != nil || !ok {
+		t.Fatalf("Expected to release the lock, got %v, %v", ok, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestMySQLLeaseLockHeldByOther tests that an unexpired row blocks the acquire
+func TestMySQLLeaseLockHeldByOther(t *testing.T) {
+	ctx := context.Background()
+	lock, mock := newTestMySQLLeaseLock(t)
+	lockInfo := NewDistributedLockInfo("lease-key", "owner", time.Second)
+
+	mock.ExpectExec("INSERT INTO distributed_locks").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if ok, err := lock.AcquireLock(ctx, lockInfo); err != nil || ok {
+		t.Fatalf("Expected the lock to be busy, got %v, %v", ok, err)
+	}
+	if _, err := lock.FencingToken(lockInfo); !errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("Expected ErrLockNotHeld, got %v", err)
+	}
+}
+
+// TestMySQLLeaseLockRenewLost tests that renewing an expired or stolen lease reports loss
+func TestMySQLLeaseLockRenewLost(t *testing.T) {
+	ctx := context.Background()
+	lock, mock := newTestMySQLLeaseLock(t)
+	lockInfo := NewDistributedLockInfo("lease-key", "owner", time.Second)
+	lockInfo.handle = &mysqlLeaseHandle{fence: 7}
+
+	mock.ExpectExec("UPDATE distributed_locks SET expires_at").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) > 0 FROM distributed_locks").
+		WithArgs("lease-key", "owner", int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"owned"}).AddRow(false))
+
+	if err := lock.RenewLock(ctx, lockInfo); !errors.Is(err, ErrLockLost) {
+		t.Fatalf("Expected ErrLockLost, got %v", err)
+	}
+}
+
+// TestNewMySQLLeaseLockRejectsTableName tests that unsafe table names are rejected
+func TestNewMySQLLeaseLockRejectsTableName(t *testing.T) {
+	if _, err := NewMySQLLeaseLock("user:pass@tcp(localhost:3306)/db", "locks; DROP TABLE x"); err == nil {
+		t.Error("Expected error for invalid table name")
+	}
+}
+
+// TestMySQLLeaseLockHashesLongKeys tests that keys longer than the lock_key column are hashed
+func TestMySQLLeaseLockHashesLongKeys(t *testing.T) {
+	ctx := context.Background()
+	lock, mock := newTestMySQLLeaseLock(t)
+	key := "tenants/" + strings.Repeat("a", 200) + "/jobs/" + strings.Repeat("b", 100)
+	name := mysqlRowKey(key)
+	if n := utf8.RuneCountInString(name); n != maxMySQLRowKey || !strings.HasPrefix(name, "tenants/") {
+		t.Fatalf("Expected a %d character key keeping the start, got %d: %s", maxMySQLRowKey, n, name)
+	}
+	lockInfo := NewDistributedLockInfo(key, "owner", time.Second)
+
+	mock.ExpectExec("INSERT INTO distributed_locks .* ON DUPLICATE KEY UPDATE").
+		WithArgs(name, "owner", int64(1000000)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT fence FROM distributed_locks").
+		WithArgs(name, "owner").
+		WillReturnRows(sqlmock.NewRows([]string{"fence"}).AddRow(1))
+	mock.ExpectExec("UPDATE distributed_locks SET expires_at = NOW\\(3\\) - INTERVAL").
+		WithArgs(name, "owner", int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if ok, err := lock.AcquireLock(ctx, lockInfo); err != nil || !ok {
+		t.Fatalf("Expected to acquire the lock, got %v, %v", ok, err)
+	}
+	if ok, err := lock.ReleaseLock(ctx, lockInfo); err != nil || !ok {
+		t.Fatalf("Expected to release the lock, got %v, %v", ok, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -2,14 +2,36 @@ package distributedlock
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
 	"fmt"
+	"math"
+	"time"
+	"unicode/utf8"
 
 	_ "github.com/go-sql-driver/mysql"
 )
 
+// maxMySQLLockName is the longest name GET_LOCK accepts, in characters
+const maxMySQLLockName = 64
+
+// maxMySQLRowKey is the length of the VARCHAR(255) key columns of the MySQL
+// tables, in characters
+const maxMySQLRowKey = 255
+
+// MySQLLock implements DistributedLockService with MySQL named locks
+// (GET_LOCK/RELEASE_LOCK). Named locks belong to the session that took them,
+// so every held lock pins one connection of the pool until it is released.
 type MySQLLock struct {
-	db *sql.DB
+	db          *sql.DB
+	waitTimeout time.Duration
+}
+
+// mysqlHandle is the MySQL-private state of a held lock
+type mysqlHandle struct {
+	conn *sql.Conn
 }
 
 func NewMySQLLock(dsn string) (*MySQLLock, error) {
@@ -17,7 +39,12 @@ func NewMySQLLock(dsn string) (*MySQLLock, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %v", err)
 	}
+	return NewMySQLLockWithDB(db)
+}
 
+// NewMySQLLockWithDB creates a MySQL lock on an existing connection pool,
+// which it owns from then on
+func NewMySQLLockWithDB(db *sql.DB) (*MySQLLock, error) {
 	// Test the connection
 	if err := db.Ping(); err != nil {
 		db.Close()
@@ -27,52 +54,88 @@ func NewMySQLLock(dsn string) (*MySQLLock, error) {
 	return &MySQLLock{db: db}, nil
 }
 
+// SetWaitTimeout sets how long a single GET_LOCK call waits for a busy lock.
+// It is independent of the lock expiration; the default of 0 makes every
+// attempt non-blocking and leaves waiting to the retry policy.
+func (m *MySQLLock) SetWaitTimeout(timeout time.Duration) {
+	m.waitTimeout = timeout
+}
+
 func (m *MySQLLock) AcquireLock(ctx context.Context, lockInfo *DistributedLockInfo) (bool, error) {
+	// The lock lives as long as the session, so take a dedicated connection
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+
 	// Use MySQL's GET_LOCK function
-	var result int
-	err := m.db.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", lockInfo.key, int(lockInfo.expiration.Seconds())).Scan(&result)
+	var result sql.NullInt64
+	err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", mysqlLockName(lockInfo.key), waitSeconds(m.waitTimeout)).Scan(&result)
 	if err != nil {
+		discardConn(conn)
 		return false, err
 	}
 
-	// GET_LOCK returns 1 if the lock was obtained, 0 if it timed out
-	return result == 1, nil
+	// GET_LOCK returns 1 if the lock was obtained, 0 if it timed out and NULL on error
+	if result.Int64 != 1 {
+		conn.Close()
+		return false, nil
+	}
+
+	lockInfo.handle = &mysqlHandle{conn: conn}
+	return true, nil
 }
 
 func (m *MySQLLock) ReleaseLock(ctx context.Context, lockInfo *DistributedLockInfo) (bool, error) {
-	// Use MySQL's RELEASE_LOCK function
-	var result int
-	err := m.db.QueryRowContext(ctx, "SELECT RELEASE_LOCK(?)", lockInfo.key).Scan(&result)
+	h, ok := lockInfo.handle.(*mysqlHandle)
+	if !ok {
+		return false, ErrLockNotHeld
+	}
+
+	// Use MySQL's RELEASE_LOCK function on the connection that holds the lock
+	var result sql.NullInt64
+	err := h.conn.QueryRowContext(ctx, "SELECT RELEASE_LOCK(?)", mysqlLockName(lockInfo.key)).Scan(&result)
+	lockInfo.handle = nil
 	if err != nil {
+		// Ending the session is the only other way to drop the lock
+		discardConn(h.conn)
 		return false, err
 	}
+	h.conn.Close()
 
 	// RELEASE_LOCK returns 1 if the lock was released, 0 if the lock wasn't held by this thread, or NULL if the lock didn't exist
-	return result == 1, nil
+	return result.Int64 == 1, nil
 }
 
+// RenewLock verifies that the pinned connection is alive and still owns the
+// lock. GET_LOCK has no expiration, so there is nothing to extend; a dead
+// connection means MySQL has already dropped the lock and ErrLockLost is returned.
 func (m *MySQLLock) RenewLock(ctx context.Context, lockInfo *DistributedLockInfo) error {
-	// MySQL's GET_LOCK doesn't support renewing the lock directly
-	// We need to release and re-acquire the lock
-	released, err := m.ReleaseLock(ctx, lockInfo)
-	if err != nil {
-		return err
-	}
-	if !released {
+	h, ok := lockInfo.handle.(*mysqlHandle)
+	if !ok {
 		return ErrLockNotHeld
 	}
 
-	acquired, err := m.AcquireLock(ctx, lockInfo)
+	var owned sql.NullInt64
+	err := h.conn.QueryRowContext(ctx, "SELECT IS_USED_LOCK(?) = CONNECTION_ID()", mysqlLockName(lockInfo.key)).Scan(&owned)
 	if err != nil {
-		return err
+		lockInfo.handle = nil
+		discardConn(h.conn)
+		return fmt.Errorf("%w: %v", ErrLockLost, err)
 	}
-	if !acquired {
-		return ErrLockNotAcquired
+	if owned.Int64 != 1 {
+		lockInfo.handle = nil
+		h.conn.Close()
+		return ErrLockLost
 	}
-
 	return nil
 }
 
+// NewLock returns a lock handle bound to this MySQL instance
+func (m *MySQLLock) NewLock(key string, opts ...LockOption) Locker {
+	return newLockHandle(m, key, opts...)
+}
+
 func (m *MySQLLock) BuildServiceType() string {
 	return "mysql"
 }
@@ -81,3 +144,52 @@ func (m *MySQLLock) BuildServiceType() string {
 func (m *MySQLLock) Close() error {
 	return m.db.Close()
 }
+
+// mysqlLockName maps a lock key to a GET_LOCK name (see shortenMySQLKey)
+func mysqlLockName(key string) string {
+	return shortenMySQLKey(key, maxMySQLLockName)
+}
+
+// mysqlRowKey maps a key to a value of a table's key column (see shortenMySQLKey)
+func mysqlRowKey(key string) string {
+	return shortenMySQLKey(key, maxMySQLRowKey)
+}
+
+// shortenMySQLKey fits a key into maxLen characters. Keys that fit are used
+// as-is; longer ones, e.g. under a namespace prefix, keep their start and are
+// suffixed with a hash of the whole key to stay unique.
+func shortenMySQLKey(key string, maxLen int) string {
+	if utf8.RuneCountInString(key) <= maxLen {
+		return key
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	suffix := hex.EncodeToString(sum[:16])
+	limit := maxLen - len(suffix) - 1
+	prefix := key
+	for i := range key {
+		if limit == 0 {
+			prefix = key[:i]
+			break
+		}
+		limit--
+	}
+	return prefix + "-" + suffix
+}
+
+// waitSeconds converts a wait timeout into the whole seconds GET_LOCK expects
+func waitSeconds(timeout time.Duration) int {
+	if timeout <= 0 {
+		return 0
+	}
+	return int(math.Ceil(timeout.Seconds()))
+}
+
+// discardConn closes conn without returning it to the pool, which ends the
+// session and with it every named lock the session might still hold
+func discardConn(conn *sql.Conn) {
+	conn.Raw(func(driverConn interface{}) error {
+		return driver.ErrBadConn
+	})
+	conn.Close()
+}
@@ -0,0 +1,209 @@
+package distributedlock
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func newTestMySQLLock(t *testing.T) (*MySQLLock, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("Failed to create sqlmock: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return &MySQLLock{db: db}, mock
+}
+
+// TestMySQLLockPinsConnection tests acquire, renew and release on the pinned connection
+func TestMySQLLockPinsConnection(t *testing.T) {
+	ctx := context.Background()
+	lock, mock := newTestMySQLLock(t)
+	lock.SetWaitTimeout(1500 * time.Millisecond)
+	lockInfo := NewDistributedLockInfo("mysql-key", "owner", 30*time.Second)
+
+	mock.ExpectQuery("SELECT GET_LOCK(?, ?)").WithArgs("mysql-key", 2).
+		WillReturnRows(sqlmock.NewRows([]string{"r"}).AddRow(1))
+	mock.ExpectQuery("SELECT IS_USED_LOCK(?) = CONNECTION_ID()").WithArgs("mysql-key").
+		WillReturnRows(sqlmock.NewRows([]string{"r"}).AddRow(1))
+	mock.ExpectQuery("SELECT RELEASE_LOCK(?)").WithArgs("mysql-key").
+		WillReturnRows(sqlmock.NewRows([]string{"r"}).AddRow(1))
+
+	if ok, err := lock.AcquireLock(ctx, lockInfo); err != nil || !ok {
+		t.Fatalf("Expected to acquire the lock, got %v, %v", ok, err)
+	}
+	if _, ok := lockInfo.handle.(*mysqlHandle); !ok {
+		t.Fatal("Expected the lock to pin a connection")
+	}
+	if err := lock.RenewLock(ctx, lockInfo); err != nil {
+		t.Fatalf("Expected renew to succeed, got %v", err)
+	}
+	if ok, err := lock.ReleaseLock(ctx, lockInfo); err != nil || !ok {
+		t.Fatalf("Expected to release the lock, got %v, %v", ok, err)
+	}
+	if lockInfo.handle != nil {
+		t.Error("Expected the pinned connection to be dropped after release")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestMySQLLockRenewDetectsLoss tests that a lock no longer owned by the session is reported lost
+func TestMySQLLockRenewDetectsLoss(t *testing.T) {
+	ctx := context.Background()
+	lock, mock := newTestMySQLLock(t)
+	lockInfo := NewDistributedLockInfo("mysql-key", "owner", 30*time.Second)
+
+	mock.ExpectQuery("SELECT GET_LOCK(?, ?)").WithArgs("mysql-key", 0).
+		WillReturnRows(sqlmock.NewRows([]string{"r"}).AddRow(1))
+	mock.ExpectQuery("SELECT IS_USED_LOCK(?) = CONNECTION_ID()").WithArgs("mysql-key").
+		WillReturnRows(sqlmock.NewRows([]string{"r"}).AddRow(nil))
+
+	if ok, err := lock.AcquireLock(ctx, lockInfo); err != nil || !ok {
+		t.Fatalf("Expected to acquire the lock, got %v, %v", ok, err)
+	}
+	if err := lock.RenewLock(ctx, lockInfo); !errors.Is(err, ErrLockLost) {
+		t.Fatalf("Expected ErrLockLost, got %v", err)
+	}
+	if _, err := lock.ReleaseLock(ctx, lockInfo); !errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("Expected ErrLockNotHeld after loss, got %v", err)
+	}
+}
+
+// TestMySQLLockBusy tests that a busy lock returns the connection to the pool
+func TestMySQLLockBusy(t *testing.T) {
+	ctx := context.Background()
+	lock, mock := newTestMySQLLock(t)
+	lockInfo := NewDistributedLockInfo("mysql-key", "owner", 30*time.Second)
+
+	mock.ExpectQuery("SELECT GET_LOCK(?, ?)").WithArgs("mysql-key", 0).
+		WillReturnRows(sqlmock.NewRows([]string{"r"}).AddRow(0))
+
+	if ok, err := lock.AcquireLock(ctx, lockInfo); err != nil || ok {
+		t.Fatalf("Expected the lock to be busy, got %v, %v", ok, err)
+	}
+	if lockInfo.handle != nil {
+		t.Error("Expected no pinned connection for a busy lock")
+	}
+}
+
+// TestMySQLLockName tests that over-long keys are hashed to a valid GET_LOCK name
+func TestMySQLLockName(t *testing.T) {
+	short := "billing/invoices/2024"
+	if got := mysqlLockName(short); got != short {
+		t.Errorf("Expected short key to be kept, got %s", got)
+	}
+	exact := strings.Repeat("k", maxMySQLLockName)
+	if got := mysqlLockName(exact); got != exact {
+		t.Errorf("Expected a 64 character key to be kept, got %s", got)
+	}
+
+	long := "tenant-with-a-rather-long-name/" + strings.Repeat("ä", 40)
+	name := mysqlLockName(long)
+	if n := utf8.RuneCountInString(name); n != maxMySQLLockName {
+		t.Errorf("Expected a %d character name, got %d: %s", maxMySQLLockName, n, name)
+	}
+	if !utf8.ValidString(name) || !strings.HasPrefix(name, "tenant-with-a-rather-long-name/") {
+		t.Errorf("Expected a valid name keeping the key's start, got %s", name)
+	}
+	if mysqlLockName(long+"x") == name {
+		t.Error("Expected different long keys to map to different names")
+	}
+}
+
+// TestMySQLLockHashesLongKeys tests that all lock calls use the hashed name
+func TestMySQLLockHashesLongKeys(t *testing.T) {
+	ctx := context.Background()
+	lock, mock := newTestMySQLLock(t)
+	key := "tenants/" + strings.Repeat("a", 40) + "/jobs/" + strings.Repeat("b", 40)
+	name := mysqlLockName(key)
+	lockInfo := NewDistributedLockInfo(key, "owner", 30*time.Second)
+
+	mock.ExpectQuery("SELECT GET_LOCK(?, ?)").WithArgs(name, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"r"}).AddRow(1))
+	mock.ExpectQuery("SELECT IS_USED_LOCK(?) = CONNECTION_ID()").WithArgs(name).
+		WillReturnRows(sqlmock.NewRows([]string{"r"}).AddRow(1))
+	mock.ExpectQuery("SELECT RELEASE_LOCK(?)").WithArgs(name).
+		WillReturnRows(sqlmock.NewRows([]string{"r"}).AddRow(1))
+
+	if ok, err := lock.AcquireLock(ctx, lockInfo); err != nil || !ok {
+		t.Fatalf("Expected to acquire the lock, got %v, %v", ok, err)
+	}
+	if err := lock.RenewLock(ctx, lockInfo); err != nil {
+		t.Fatalf("Expected renew to succeed, got %v", err)
+	}
+	if ok, err := lock.ReleaseLock(ctx, lockInfo); err != nil || !ok {
+		t.Fatalf("Expected to release the lock, got %v, %v", ok, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestMySQLConfigDriverConfig tests that DSN parameters, timeouts and TLS are applied
+func TestMySQLConfigDriverConfig(t *testing.T) {
+	cfg, err := MySQLConfig{
+		User:         "locker",
+		Password:     "secret",
+		Host:         "mysql.internal",
+		Port:         3306,
+		DBName:       "locks",
+		Params:       map[string]string{"charset": "utf8mb4"},
+		DialTimeout:  2 * time.Second,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 5 * time.Second,
+		TLS:          TLSConfig{Enabled: true, ServerName: "mysql.internal"},
+	}.driverConfig()
+	if err != nil {
+		t.Fatalf("Failed to build driver config: %v", err)
+	}
+	if cfg.Addr != "mysql.internal:3306" || cfg.User != "locker" || cfg.DBName != "locks" {
+		t.Errorf("Expected connection fields to be applied, got %s@%s/%s", cfg.User, cfg.Addr, cfg.DBName)
+	}
+	// charset is a driver option, not a server variable
+	if _, ok := cfg.Params["charset"]; ok || !strings.Contains(cfg.FormatDSN(), "charset=utf8mb4") {
+		t.Errorf("Expected charset as a driver option, got params %v and DSN %s", cfg.Params, cfg.FormatDSN())
+	}
+	if cfg.Timeout != 2*time.Second || cfg.ReadTimeout != 10*time.Second || cfg.WriteTimeout != 5*time.Second {
+		t.Errorf("Expected timeouts to be applied, got %v/%v/%v", cfg.Timeout, cfg.ReadTimeout, cfg.WriteTimeout)
+	}
+	if cfg.TLS == nil || cfg.TLS.ServerName != "mysql.internal" {
+		t.Errorf("Expected TLS config, got %+v", cfg.TLS)
+	}
+
+	// Settings are layered over an explicit DSN
+	cfg, err = MySQLConfig{
+		DSN:         "root:pw@tcp(db:3306)/app?parseTime=true",
+		Params:      map[string]string{"time_zone": "'+00:00'", "collation": "utf8mb4_bin"},
+		ReadTimeout: time.Second,
+	}.driverConfig()
+	if err != nil {
+		t.Fatalf("Failed to build driver config from DSN: %v", err)
+	}
+	if !cfg.ParseTime || cfg.Params["time_zone"] != "'+00:00'" || cfg.ReadTimeout != time.Second {
+		t.Errorf("Expected DSN and overrides to be combined, got %+v", cfg)
+	}
+	if cfg.Collation != "utf8mb4_bin" || len(cfg.Params) != 1 {
+		t.Errorf("Expected collation as a driver option, got %s and params %v", cfg.Collation, cfg.Params)
+	}
+
+	// Driver options from Params are parsed like in a DSN
+	cfg, err = MySQLConfig{Host: "db", Port: 3306, Params: map[string]string{"parseTime": "true"}}.driverConfig()
+	if err != nil || !cfg.ParseTime || len(cfg.Params) != 0 {
+		t.Errorf("Expected parseTime to be applied, got %+v, %v", cfg, err)
+	}
+	if _, err := (MySQLConfig{Params: map[string]string{"parseTime": "maybe"}}).driverConfig(); err == nil {
+		t.Error("Expected error for an invalid driver option")
+	}
+
+	if _, err := (MySQLConfig{DSN: "not a dsn"}).driverConfig(); err == nil {
+		t.Error("Expected error for an invalid DSN")
+	}
+}
@@ -0,0 +1,281 @@
+package distributedlock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultNamespaceSeparator joins the namespace prefix and the lock key. A
+	// slash nests tenants as directories on ZooKeeper, etcd, Consul and files.
+	defaultNamespaceSeparator = "/"
+	// namespaceQuotaPrefix is put in front of the quota key of a namespace
+	namespaceQuotaPrefix = "namespace-quota/"
+)
+
+// ErrQuotaUnsupported is returned for a quota on a backend that cannot store its slots
+var ErrQuotaUnsupported = errors.New("namespace quota not supported by this backend")
+
+// Namespace describes the key prefix and quota of one tenant or application
+type Namespace struct {
+	// Prefix is put in front of every lock key
+	Prefix string
+	// Separator joins prefix and key; empty means "/"
+	Separator string
+	// MaxLocks is how many locks the namespace may hold at once across all
+	// processes sharing the backend (0 = unlimited)
+	MaxLocks int
+}
+
+// NamespacedService wraps a DistributedLockService so that all keys live
+// under one prefix and at most MaxLocks locks are held at a time. Several
+// namespaces can share one backend; the wrapper does not own it and does
+// not close it.
+//
+// The quota is kept on the backend as one slot per held lock, so it holds
+// across replicas; it needs a Redis, etcd or MySQL backend. A slot is taken
+// before the lock and given back if the lock is not acquired, so the held
+// locks never exceed it. Slots expire with the lock expiration unless a
+// renewal takes them again, which frees those of a crashed replica. A
+// renewal error gives the slot back, as the watchdog gives up such a lock.
+type NamespacedService struct {
+	service   DistributedLockService
+	prefix    string
+	maxLocks  int
+	quota     quotaStore
+	quotaKey  string
+	mu        sync.Mutex
+	heldLocks map[*DistributedLockInfo]struct{}
+}
+
+// quotaStore counts the slots of namespace quotas across the replicas
+// sharing a backend
+type quotaStore interface {
+	// take takes or refreshes the slot of member under key for ttl, unless
+	// limit other slots are taken
+	take(ctx context.Context, key, member string, limit int, ttl time.Duration) (bool, error)
+	give(ctx context.Context, key, member string) error
+}
+
+// namespaceHandle is the wrapper-private state of a lock: the lock info with
+// the namespaced key that is passed to the wrapped backend, and the member
+// of its quota slot
+type namespaceHandle struct {
+	inner *DistributedLockInfo
+	slot  string
+}
+
+// lostSignal passes on the loss signal of the wrapped backend's handle
+func (h *namespaceHandle) lostSignal() <-chan struct{} {
+	if notifier, ok := h.inner.handle.(lossNotifier); ok {
+		return notifier.lostSignal()
+	}
+	return nil
+}
+
+// NewNamespacedService creates a namespace layer over service
+func NewNamespacedService(service DistributedLockService, ns Namespace) (*NamespacedService, error) {
+	if ns.Prefix == "" {
+		return nil, fmt.Errorf("namespace prefix must not be empty")
+	}
+	if ns.MaxLocks < 0 {
+		return nil, fmt.Errorf("invalid namespace quota: %d", ns.MaxLocks)
+	}
+	separator := ns.Separator
+	if separator == "" {
+		separator = defaultNamespaceSeparator
+	}
+	n := &NamespacedService{
+		service:   service,
+		prefix:    strings.TrimSuffix(ns.Prefix, separator) + separator,
+		maxLocks:  ns.MaxLocks,
+		heldLocks: make(map[*DistributedLockInfo]struct{}),
+	}
+	if n.maxLocks > 0 {
+		quota, prefix, err := newQuotaStore(service)
+		if err != nil {
+			return nil, err
+		}
+		n.quota = quota
+		n.quotaKey = prefix + namespaceQuotaPrefix + strings.TrimSuffix(ns.Prefix, separator)
+	}
+	return n, nil
+}
+
+// newQuotaStore returns the quota store of the backend under service (see
+// unwrapBackend) and the key prefix of the namespaces in between
+func newQuotaStore(service DistributedLockService) (quotaStore, string, error) {
+	backend, prefix := unwrapBackend(service)
+	switch s := backend.(type) {
+	case *RedisLock:
+		return &redisQuotaStore{client: s.client}, prefix, nil
+	case *EtcdLock:
+		return &etcdQuotaStore{client: s.pool.client}, prefix, nil
+	case *MySQLLock:
+		store, err := newMySQLQuotaStore(s.db)
+		return store, prefix, err
+	case *MySQLLeaseLock:
+		store, err := newMySQLQuotaStore(s.db)
+		return store, prefix, err
+	default:
+		return nil, "", fmt.Errorf("%w: %s", ErrQuotaUnsupported, service.BuildServiceType())
+	}
+}
+
+// AcquireLock acquires the namespaced key. It fails with ErrQuotaExceeded
+// while the namespace already holds MaxLocks locks.
+func (n *NamespacedService) AcquireLock(ctx context.Context, lockInfo *DistributedLockInfo) (bool, error) {
+	inner := n.inner(lockInfo)
+	if err := n.reserve(ctx, lockInfo); err != nil {
+		return false, err
+	}
+
+	acquired, err := n.service.AcquireLock(ctx, inner)
+	if err != nil || !acquired {
+		n.free(ctx, lockInfo)
+	}
+	return acquired, err
+}
+
+// CancelWait passes a gave-up waiter on to backends that queue waiters
+func (n *NamespacedService) CancelWait(ctx context.Context, lockInfo *DistributedLockInfo) error {
+	canceler, ok := n.service.(waitCanceler)
+	if !ok {
+		return nil
+	}
+	return canceler.CancelWait(ctx, n.inner(lockInfo))
+}
+
+func (n *NamespacedService) ReleaseLock(ctx context.Context, lockInfo *DistributedLockInfo) (bool, error) {
+	h, ok := lockInfo.handle.(*namespaceHandle)
+	if !ok {
+		return false, ErrLockNotHeld
+	}
+
+	released, err := n.service.ReleaseLock(ctx, h.inner)
+	if err != nil {
+		return false, err
+	}
+	n.free(ctx, lockInfo)
+	return released, nil
+}
+
+func (n *NamespacedService) RenewLock(ctx context.Context, lockInfo *DistributedLockInfo) error {
+	h, ok := lockInfo.handle.(*namespaceHandle)
+	if !ok {
+		return ErrLockNotHeld
+	}
+
+	h.inner.expiration = lockInfo.expiration
+	if err := n.service.RenewLock(ctx, h.inner); err != nil {
+		n.free(ctx, lockInfo)
+		return err
+	}
+	if n.quota == nil {
+		return nil
+	}
+	taken, err := n.quota.take(ctx, n.quotaKey, h.slot, n.maxLocks, lockInfo.expiration)
+	if err == nil && !taken {
+		err = fmt.Errorf("%w: quota slot expired and the namespace is full", ErrLockLost)
+	}
+	if err != nil {
+		n.free(ctx, lockInfo)
+	}
+	return err
+}
+
+// NewLock returns a lock handle whose key lives in this namespace
+func (n *NamespacedService) NewLock(key string, opts ...LockOption) Locker {
+	return newLockHandle(n, key, opts...)
+}
+
+// BuildServiceType returns the type of the wrapped backend
+func (n *NamespacedService) BuildServiceType() string {
+	return n.service.BuildServiceType()
+}
+
+// Key returns the backend key used for a lock key
+func (n *NamespacedService) Key(key string) string {
+	return n.prefix + key
+}
+
+// HeldLocks returns how many locks this process holds in the namespace
+func (n *NamespacedService) HeldLocks() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.heldLocks)
+}
+
+// unwrapBackend returns the backend under any namespaces and fault
+// injectors wrapping service and the key prefix of its namespaces, for
+// features that keep their own state on the backend. Those features support
+// the Redis, etcd and MySQL backends and refuse the others.
+func unwrapBackend(service DistributedLockService) (DistributedLockService, string) {
+	prefix := ""
+	for {
+		switch s := service.(type) {
+		case *NamespacedService:
+			service, prefix = s.service, s.prefix+prefix
+		case *FaultInjector:
+			service = s.service
+		default:
+			return service, prefix
+		}
+	}
+}
+
+// inner returns the lock info passed to the wrapped backend, creating it on
+// first use. Value and expiration follow the caller's lock info.
+func (n *NamespacedService) inner(lockInfo *DistributedLockInfo) *DistributedLockInfo {
+	h, ok := lockInfo.handle.(*namespaceHandle)
+	if !ok {
+		h = &namespaceHandle{
+			inner: NewDistributedLockInfo(n.Key(lockInfo.key), lockInfo.value, lockInfo.expiration),
+			slot:  newLockToken(),
+		}
+		lockInfo.handle = h
+	}
+	h.inner.value = lockInfo.value
+	h.inner.expiration = lockInfo.expiration
+	return h.inner
+}
+
+// reserve takes a quota slot for lockInfo, failing with ErrQuotaExceeded
+// if the namespace is full
+func (n *NamespacedService) reserve(ctx context.Context, lockInfo *DistributedLockInfo) error {
+	if n.quota != nil {
+		h := lockInfo.handle.(*namespaceHandle)
+		taken, err := n.quota.take(ctx, n.quotaKey, h.slot, n.maxLocks, lockInfo.expiration)
+		if err != nil {
+			return err
+		}
+		if !taken {
+			return ErrQuotaExceeded
+		}
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.heldLocks[lockInfo] = struct{}{}
+	return nil
+}
+
+// free gives back the quota slot of lockInfo. If that fails, the slot
+// still expires with the lock.
+func (n *NamespacedService) free(ctx context.Context, lockInfo *DistributedLockInfo) {
+	n.mu.Lock()
+	delete(n.heldLocks, lockInfo)
+	n.mu.Unlock()
+
+	if n.quota == nil {
+		return
+	}
+	h := lockInfo.handle.(*namespaceHandle)
+	if err := n.quota.give(context.WithoutCancel(ctx), n.quotaKey, h.slot); err != nil {
+		log.Printf("Namespace %s failed to give back quota slot: %v\n", n.prefix, err)
+	}
+}
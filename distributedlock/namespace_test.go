@@ -0,0 +1,226 @@
+package distributedlock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestNamespacedServicePrefixesKeys tests that tenants sharing a backend do not collide
+func TestNamespacedServicePrefixesKeys(t *testing.T) {
+	ctx := context.Background()
+	backend, mr := newTestRedisLock(t)
+
+	billing, err := NewNamespacedService(backend, Namespace{Prefix: "billing"})
+	if err != nil {
+		t.Fatalf("Failed to create namespace: %v", err)
+	}
+	search, err := NewNamespacedService(backend, Namespace{Prefix: "search/"})
+	if err != nil {
+		t.Fatalf("Failed to create namespace: %v", err)
+	}
+
+	billingLock := NewDistributedLockInfo("reindex", "billing-owner", 30*time.Second)
+	searchLock := NewDistributedLockInfo("reindex", "search-owner", 30*time.Second)
+	if ok, err := billing.AcquireLock(ctx, billingLock); err != nil || !ok {
+		t.Fatalf("Expected billing to acquire, got %v, %v", ok, err)
+	}
+	if ok, err := search.AcquireLock(ctx, searchLock); err != nil || !ok {
+		t.Fatalf("Expected search to acquire the same key in its namespace, got %v, %v", ok, err)
+	}
+
+	if got, _ := mr.Get("billing/reindex"); got != "billing-owner" {
+		t.Errorf("Expected billing/reindex to hold billing-owner, got %q", got)
+	}
+	if got, _ := mr.Get("search/reindex"); got != "search-owner" {
+		t.Errorf("Expected search/reindex to hold search-owner, got %q", got)
+	}
+
+	if err := billing.RenewLock(ctx, billingLock); err != nil {
+		t.Errorf("Expected renew to succeed, got %v", err)
+	}
+	if ok, err := billing.ReleaseLock(ctx, billingLock); err != nil || !ok {
+		t.Errorf("Expected release to succeed, got %v, %v", ok, err)
+	}
+	if mr.Exists("billing/reindex") {
+		t.Error("Expected billing/reindex to be deleted")
+	}
+}
+
+// TestNamespacedServiceQuota tests that the quota holds across replicas and
+// frees a slot on release
+func TestNamespacedServiceQuota(t *testing.T) {
+	ctx := context.Background()
+	backend, _ := newTestRedisLock(t)
+	// Two wrappers over one backend stand for two replicas
+	var replicas []*NamespacedService
+	for i := 0; i < 2; i++ {
+		ns, err := NewNamespacedService(backend, Namespace{Prefix: "tenant", MaxLocks: 2})
+		if err != nil {
+			t.Fatalf("Failed to create namespace: %v", err)
+		}
+		replicas = append(replicas, ns)
+	}
+
+	first := replicas[0].NewLock("a", WithRetry(1, 0))
+	second := replicas[1].NewLock("b", WithRetry(1, 0))
+	third := replicas[1].NewLock("c", WithRetry(1, 0))
+	for _, l := range []Locker{first, second} {
+		if ok, err := l.Lock(ctx); err != nil || !ok {
+			t.Fatalf("Expected %s to be acquired, got %v, %v", l.Key(), ok, err)
+		}
+	}
+	if ok, err := third.Lock(ctx); ok || !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Expected ErrQuotaExceeded, got %v, %v", ok, err)
+	}
+	if replicas[0].HeldLocks() != 1 || replicas[1].HeldLocks() != 1 {
+		t.Errorf("Expected one held lock per replica, got %d and %d", replicas[0].HeldLocks(), replicas[1].HeldLocks())
+	}
+
+	if err := first.Unlock(ctx); err != nil {
+		t.Fatalf("Failed to unlock: %v", err)
+	}
+	if ok, err := third.Lock(ctx); err != nil || !ok {
+		t.Errorf("Expected third to be acquired after a release, got %v, %v", ok, err)
+	}
+	second.Unlock(ctx)
+	third.Unlock(ctx)
+
+	if _, err := NewNamespacedService(backend, Namespace{}); err == nil {
+		t.Error("Expected error for an empty prefix")
+	}
+}
+
+// TestNamespacedServiceQuotaExpires tests that the slot of a crashed replica
+// frees itself once its lock would have expired
+func TestNamespacedServiceQuotaExpires(t *testing.T) {
+	ctx := context.Background()
+	backend, mr := newTestRedisLock(t)
+	crashed, _ := NewNamespacedService(backend, Namespace{Prefix: "tenant", MaxLocks: 1})
+	alive, _ := NewNamespacedService(backend, Namespace{Prefix: "tenant", MaxLocks: 1})
+
+	// The crashed replica's lock is never renewed or released
+	if ok, err := crashed.AcquireLock(ctx, NewDistributedLockInfo("a", "crashed", time.Second)); err != nil || !ok {
+		t.Fatalf("Expected to acquire, got %v, %v", ok, err)
+	}
+	info := NewDistributedLockInfo("b", "alive", time.Second)
+	if ok, err := alive.AcquireLock(ctx, info); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Expected ErrQuotaExceeded, got %v, %v", ok, err)
+	}
+
+	mr.SetTime(time.Now().Add(2 * time.Second))
+	if ok, err := alive.AcquireLock(ctx, info); err != nil || !ok {
+		t.Errorf("Expected the expired slot to be free, got %v, %v", ok, err)
+	}
+	if err := alive.RenewLock(ctx, info); err != nil {
+		t.Errorf("Expected renew to refresh the slot, got %v", err)
+	}
+}
+
+// TestNamespacedServiceQuotaUnsupported tests that a quota needs a backend that stores its slots
+func TestNamespacedServiceQuotaUnsupported(t *testing.T) {
+	lock, _ := NewFileLock(t.TempDir())
+	if _, err := NewNamespacedService(lock, Namespace{Prefix: "tenant", MaxLocks: 1}); !errors.Is(err, ErrQuotaUnsupported) {
+		t.Errorf("Expected ErrQuotaUnsupported, got %v", err)
+	}
+	if _, err := NewNamespacedService(lock, Namespace{Prefix: "tenant"}); err != nil {
+		t.Errorf("Expected a namespace without quota on any backend, got %v", err)
+	}
+}
+
+// TestEtcdQuotaStore tests that racing replicas cannot take more slots than
+// the limit and that a given back slot is free again
+func TestEtcdQuotaStore(t *testing.T) {
+	ctx := context.Background()
+	client := newTestEtcd(t)
+	store := &etcdQuotaStore{client: client}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var taken []string
+	for i := 0; i < 10; i++ {
+		member := fmt.Sprintf("member-%d", i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := store.take(ctx, "namespace-quota/tenant", member, 3, 30*time.Second)
+			if err != nil {
+				t.Errorf("Take failed: %v", err)
+				return
+			}
+			if ok {
+				mu.Lock()
+				taken = append(taken, member)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(taken) != 3 {
+		t.Fatalf("Expected 3 of 10 racing takes to succeed, got %d", len(taken))
+	}
+
+	// Holders refresh their slot even though the quota is full
+	if ok, err := store.take(ctx, "namespace-quota/tenant", taken[0], 3, 30*time.Second); err != nil || !ok {
+		t.Errorf("Expected a holder to refresh its slot, got %v, %v", ok, err)
+	}
+	if err := store.give(ctx, "namespace-quota/tenant", taken[0]); err != nil {
+		t.Fatalf("Failed to give back the slot: %v", err)
+	}
+	if ok, err := store.take(ctx, "namespace-quota/tenant", "late", 3, 30*time.Second); err != nil || !ok {
+		t.Errorf("Expected the given back slot to be free, got %v, %v", ok, err)
+	}
+	leases, err := client.Leases(ctx)
+	if err != nil || len(leases.Leases) != 3 {
+		t.Errorf("Expected one lease per slot, got %+v, %v", leases, err)
+	}
+}
+
+// TestMySQLQuotaStore tests the anchor row lock and the slot count
+func TestMySQLQuotaStore(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("Failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+	store := &mysqlQuotaStore{db: db, gc: newMySQLExpiryGC(db, quotaTable)}
+
+	anchor := "INSERT IGNORE INTO distributed_quota_slots (name, member, expires_at) VALUES (?, '', '9999-12-31 23:59:59')"
+	lock := "SELECT member FROM distributed_quota_slots WHERE name = ? AND member = '' FOR UPDATE"
+	count := "SELECT COUNT(*) FROM distributed_quota_slots WHERE name = ? AND member NOT IN ('', ?) AND expires_at > NOW(3)"
+	mock.ExpectBegin()
+	mock.ExpectExec(anchor).WithArgs("tenant").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(lock).WithArgs("tenant").WillReturnRows(sqlmock.NewRows([]string{"member"}).AddRow(""))
+	mock.ExpectQuery(count).WithArgs("tenant", "a").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectExec(`INSERT INTO distributed_quota_slots (name, member, expires_at)
+VALUES (?, ?, NOW(3) + INTERVAL ? MICROSECOND)
+ON DUPLICATE KEY UPDATE expires_at = VALUES(expires_at)`).
+		WithArgs("tenant", "a", int64(time.Second/time.Microsecond)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec("DELETE FROM distributed_quota_slots WHERE expires_at < NOW(3) LIMIT 1000").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if ok, err := store.take(ctx, "tenant", "a", 2, time.Second); err != nil || !ok {
+		t.Fatalf("Expected a free slot to be taken, got %v, %v", ok, err)
+	}
+
+	// A full quota writes nothing
+	mock.ExpectBegin()
+	mock.ExpectExec(anchor).WithArgs("tenant").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(lock).WithArgs("tenant").WillReturnRows(sqlmock.NewRows([]string{"member"}).AddRow(""))
+	mock.ExpectQuery(count).WithArgs("tenant", "b").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectCommit()
+
+	if ok, err := store.take(ctx, "tenant", "b", 2, time.Second); err != nil || ok {
+		t.Errorf("Expected a full quota to refuse the slot, got %v, %v", ok, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,227 @@
+package distributedlock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	// onceLockPrefix is put in front of the lock key of a RunOnce call
+	onceLockPrefix = "once/"
+	// onceResultPrefix is put in front of the backend key of a published result
+	onceResultPrefix = "once-result/"
+	// defaultOncePollInterval is how often waiters look for the result on
+	// backends that cannot notify them
+	defaultOncePollInterval = 100 * time.Millisecond
+)
+
+// ErrOnceUnsupported is returned for backends that cannot store results
+var ErrOnceUnsupported = errors.New("run once not supported by this backend")
+
+// OnceError is returned to waiters when the replica that ran fn failed.
+// The winner itself gets fn's original error.
+type OnceError struct {
+	Key     string
+	Message string
+}
+
+func (e *OnceError) Error() string {
+	return fmt.Sprintf("run once %s failed: %s", e.Key, e.Message)
+}
+
+// onceResult is the published outcome of one run
+type onceResult struct {
+	Value  []byte `json:"value,omitempty"`
+	Failed bool   `json:"failed,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Once runs a function on exactly one replica at a time per key and shares
+// its result with the others. Results are kept on a Redis, etcd or MySQL
+// backend for the TTL, so calls within the TTL reuse them without running
+// fn again.
+//
+// Waiters on Redis and etcd are woken when the result is published or the
+// winner's lock is released (on etcd also when it expires); only then do
+// they try the lock again. On MySQL they poll.
+type Once struct {
+	service      DistributedLockService
+	store        ttlStore
+	prefix       string
+	lockPrefix   string
+	ttl          time.Duration
+	expiration   time.Duration
+	pollInterval time.Duration
+}
+
+// NewOnce creates a Once on the backend under service (see newTTLStore).
+// Results are kept for ttl.
+func NewOnce(service DistributedLockService, ttl time.Duration) (*Once, error) {
+	if ttl < time.Millisecond {
+		return nil, fmt.Errorf("invalid result ttl: %v", ttl)
+	}
+
+	store, prefix, err := newTTLStore(service, ErrOnceUnsupported)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Once{
+		service:      service,
+		store:        store,
+		prefix:       prefix + onceResultPrefix,
+		lockPrefix:   prefix + onceLockPrefix,
+		ttl:          ttl,
+		expiration:   defaultLockExpiration,
+		pollInterval: defaultOncePollInterval,
+	}, nil
+}
+
+// SetLockExpiration sets the expiration of the lock held while fn runs; the
+// watchdog keeps renewing it for long runs
+func (o *Once) SetLockExpiration(expiration time.Duration) {
+	o.expiration = expiration
+}
+
+// SetPollInterval sets how often waiters look for the result on backends
+// that cannot notify them (MySQL)
+func (o *Once) SetPollInterval(interval time.Duration) {
+	o.pollInterval = interval
+}
+
+// RunOnce returns the published result of key if there is one. Otherwise
+// the caller that gets the lock runs fn and publishes its value or error;
+// concurrent callers block until it is published and return the same value,
+// or an *OnceError carrying the winner's error message.
+//
+// If the winner goes away without publishing, its lock is freed and one of
+// the waiters takes over. A winner that cannot publish still returns its
+// own value together with the publish error. Lock errors, including
+// ErrQuotaExceeded of a namespace, are returned as they are.
+func (o *Once) RunOnce(ctx context.Context, key string, fn func(ctx context.Context) ([]byte, error)) ([]byte, error) {
+	changed, timeout, stop, err := o.watch(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer stop()
+
+	var timer *time.Timer
+	for {
+		result, err := o.result(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if result != nil {
+			return result.unwrap(key)
+		}
+
+		lock := newLockHandle(o.service, onceLockPrefix+key, WithExpiration(o.expiration), WithRetry(1, 0))
+		acquired, err := lock.Lock(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if acquired {
+			return o.run(ctx, key, lock, fn)
+		}
+
+		if timer == nil {
+			timer = time.NewTimer(timeout)
+			defer timer.Stop()
+		} else {
+			timer.Reset(timeout)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-changed:
+		case <-timer.C:
+		}
+	}
+}
+
+// watch starts following key for a waiter. It returns the channel that
+// signals a published result or released lock, and how long to wait for it
+// before trying the lock anyway: the lock expiration, after which a crashed
+// winner's lock is gone, or the poll interval without notifications.
+func (o *Once) watch(ctx context.Context, key string) (<-chan struct{}, time.Duration, func(), error) {
+	watcher, ok := o.store.(ttlWatcher)
+	if !ok {
+		return nil, o.pollInterval, func() {}, nil
+	}
+	changed, stop, err := watcher.watch(ctx, o.prefix+key, o.lockPrefix+key)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	return changed, o.expiration, stop, nil
+}
+
+// run calls fn as the winner and publishes its outcome before unlocking
+// and waking the waiters
+func (o *Once) run(ctx context.Context, key string, lock Locker, fn func(ctx context.Context) ([]byte, error)) ([]byte, error) {
+	defer func() {
+		ctx := context.WithoutCancel(ctx)
+		if err := lock.Unlock(ctx); err != nil {
+			log.Printf("Run once %s failed to release lock: %v\n", key, err)
+		}
+		if watcher, ok := o.store.(ttlWatcher); ok {
+			if err := watcher.notify(ctx, o.prefix+key); err != nil {
+				log.Printf("Run once %s failed to wake waiters: %v\n", key, err)
+			}
+		}
+	}()
+
+	// The previous winner may have published between our lookup and the lock
+	result, err := o.result(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if result != nil {
+		return result.unwrap(key)
+	}
+
+	value, fnErr := fn(ctx)
+	result = &onceResult{Value: value}
+	if fnErr != nil {
+		result = &onceResult{Failed: true, Error: fnErr.Error()}
+	}
+	if err := o.publish(context.WithoutCancel(ctx), key, result); err != nil {
+		if fnErr != nil {
+			return nil, fnErr
+		}
+		return value, fmt.Errorf("failed to publish run once result: %w", err)
+	}
+	return value, fnErr
+}
+
+// result returns the published result of key, or nil if there is none
+func (o *Once) result(ctx context.Context, key string) (*onceResult, error) {
+	data, err := o.store.get(ctx, o.prefix+key)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	var result onceResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (o *Once) publish(ctx context.Context, key string, result *onceResult) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return o.store.put(ctx, o.prefix+key, data, o.ttl)
+}
+
+// unwrap turns a published result into RunOnce's return values
+func (r *onceResult) unwrap(key string) ([]byte, error) {
+	if r.Failed {
+		return nil, &OnceError{Key: key, Message: r.Error}
+	}
+	return r.Value, nil
+}
@@ -0,0 +1,261 @@
+package distributedlock
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// newTestOnce returns a Once on its own Redis client, like one replica
+func newTestOnce(t *testing.T, mr *miniredis.Miniredis, ttl time.Duration) *Once {
+	t.Helper()
+	lock := NewRedisLock(mr.Addr(), "", 0)
+	t.Cleanup(func() { lock.client.Close() })
+	once, err := NewOnce(lock, ttl)
+	if err != nil {
+		t.Fatalf("Failed to create once: %v", err)
+	}
+	once.SetPollInterval(10 * time.Millisecond)
+	return once
+}
+
+// TestRunOnceSharesResult tests that concurrent replicas run fn once and all get its value
+func TestRunOnceSharesResult(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+
+	var calls int32
+	fn := func(ctx context.Context) ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(50 * time.Millisecond)
+		return []byte("warm"), nil
+	}
+
+	var wg sync.WaitGroup
+	values := make([][]byte, 5)
+	errs := make([]error, 5)
+	for i := range values {
+		once := newTestOnce(t, mr, time.Minute)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values[i], errs[i] = once.RunOnce(ctx, "cache", fn)
+		}(i)
+	}
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("Expected fn to run once, ran %d times", calls)
+	}
+	for i := range values {
+		if errs[i] != nil || string(values[i]) != "warm" {
+			t.Errorf("Expected caller %d to get warm, got %q, %v", i, values[i], errs[i])
+		}
+	}
+}
+
+// TestRunOnceSharesError tests that waiters get the winner's error as OnceError
+func TestRunOnceSharesError(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	winner := newTestOnce(t, mr, time.Minute)
+	waiter := newTestOnce(t, mr, time.Minute)
+
+	fnErr := errors.New("backend down")
+	if _, err := winner.RunOnce(ctx, "cache", func(ctx context.Context) ([]byte, error) {
+		return nil, fnErr
+	}); err != fnErr {
+		t.Errorf("Expected winner to get fn's error, got %v", err)
+	}
+
+	_, err := waiter.RunOnce(ctx, "cache", func(ctx context.Context) ([]byte, error) {
+		t.Error("Expected fn not to run again")
+		return nil, nil
+	})
+	var onceErr *OnceError
+	if !errors.As(err, &onceErr) || onceErr.Message != "backend down" {
+		t.Errorf("Expected OnceError with the winner's message, got %v", err)
+	}
+}
+
+// TestRunOnceTTL tests that fn runs again once the published result expired
+func TestRunOnceTTL(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	once := newTestOnce(t, mr, time.Second)
+
+	var calls int32
+	fn := func(ctx context.Context) ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		return []byte("v"), nil
+	}
+	once.RunOnce(ctx, "cache", fn)
+	once.RunOnce(ctx, "cache", fn)
+	if calls != 1 {
+		t.Errorf("Expected result to be reused within the TTL, fn ran %d times", calls)
+	}
+
+	mr.FastForward(2 * time.Second)
+	once.RunOnce(ctx, "cache", fn)
+	if calls != 2 {
+		t.Errorf("Expected fn to run again after the TTL, ran %d times", calls)
+	}
+}
+
+// TestRunOnceTakeover tests that a waiter runs fn when the winner leaves without a result
+func TestRunOnceTakeover(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	once := newTestOnce(t, mr, time.Minute)
+
+	// Redis cannot tell waiters about an expired lock, so they retry after the lock expiration
+	once.SetLockExpiration(200 * time.Millisecond)
+
+	// A crashed winner: its lock is held but it never publishes or notifies
+	crashed := newLockHandle(once.service, onceLockPrefix+"cache", WithRetry(1, 0))
+	if acquired, err := crashed.Lock(ctx); err != nil || !acquired {
+		t.Fatalf("Failed to acquire: %v, %v", acquired, err)
+	}
+	time.AfterFunc(50*time.Millisecond, func() { crashed.Unlock(ctx) })
+
+	value, err := once.RunOnce(ctx, "cache", func(ctx context.Context) ([]byte, error) {
+		return []byte("mine"), nil
+	})
+	if err != nil || string(value) != "mine" {
+		t.Errorf("Expected waiter to take over, got %q, %v", value, err)
+	}
+}
+
+// TestRunOnceWakesWaiters tests that waiters get the result when it is
+// published rather than at their next timeout
+func TestRunOnceWakesWaiters(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	winner := newTestOnce(t, mr, time.Minute)
+	waiter := newTestOnce(t, mr, time.Minute)
+	waiter.SetLockExpiration(time.Hour)
+
+	release := make(chan struct{})
+	running := make(chan struct{})
+	go winner.RunOnce(ctx, "cache", func(ctx context.Context) ([]byte, error) {
+		close(running)
+		<-release
+		return []byte("warm"), nil
+	})
+	<-running
+
+	done := make(chan []byte)
+	go func() {
+		value, _ := waiter.RunOnce(ctx, "cache", func(ctx context.Context) ([]byte, error) {
+			t.Error("Expected fn not to run again")
+			return nil, nil
+		})
+		done <- value
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	select {
+	case value := <-done:
+		if string(value) != "warm" {
+			t.Errorf("Expected the winner's value, got %q", value)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the waiter to be woken by the published result")
+	}
+}
+
+// TestRunOnceEtcdWatchesLock tests that etcd waiters notice a lock that goes
+// away without a result and take over right away
+func TestRunOnceEtcdWatchesLock(t *testing.T) {
+	ctx := context.Background()
+	client := newTestEtcd(t)
+	lock := &EtcdLock{pool: newEtcdSessionPool(client)}
+	defer lock.Close()
+	once, err := NewOnce(lock, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to create once: %v", err)
+	}
+	once.SetLockExpiration(time.Hour)
+
+	held := newLockHandle(lock, onceLockPrefix+"cache", WithRetry(1, 0))
+	if acquired, err := held.Lock(ctx); err != nil || !acquired {
+		t.Fatalf("Failed to acquire: %v, %v", acquired, err)
+	}
+	time.AfterFunc(100*time.Millisecond, func() { held.Unlock(ctx) })
+
+	start := time.Now()
+	value, err := once.RunOnce(ctx, "cache", func(ctx context.Context) ([]byte, error) {
+		return []byte("mine"), nil
+	})
+	if err != nil || string(value) != "mine" {
+		t.Fatalf("Expected waiter to take over, got %q, %v", value, err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the release to wake the waiter, took %v", elapsed)
+	}
+}
+
+// TestRunOnceQuota tests that a full namespace fails RunOnce instead of waiting
+func TestRunOnceQuota(t *testing.T) {
+	ctx := context.Background()
+	lock, _ := newTestRedisLock(t)
+	ns, err := NewNamespacedService(lock, Namespace{Prefix: "tenant", MaxLocks: 1})
+	if err != nil {
+		t.Fatalf("Failed to create namespace: %v", err)
+	}
+	other := ns.NewLock("other", WithRetry(1, 0))
+	other.Lock(ctx)
+	defer other.Unlock(ctx)
+
+	once, err := NewOnce(ns, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to create once: %v", err)
+	}
+	done := make(chan error)
+	go func() {
+		_, err := once.RunOnce(ctx, "cache", func(ctx context.Context) ([]byte, error) {
+			return nil, nil
+		})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrQuotaExceeded) {
+			t.Errorf("Expected ErrQuotaExceeded, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected RunOnce to give up on a full namespace")
+	}
+}
+
+// TestRunOnceContext tests that waiters give up when their context is done
+func TestRunOnceContext(t *testing.T) {
+	mr := miniredis.RunT(t)
+	once := newTestOnce(t, mr, time.Minute)
+
+	held := newLockHandle(once.service, onceLockPrefix+"cache", WithRetry(1, 0))
+	held.Lock(context.Background())
+	defer held.Unlock(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	if _, err := once.RunOnce(ctx, "cache", func(ctx context.Context) ([]byte, error) {
+		return nil, nil
+	}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected DeadlineExceeded, got %v", err)
+	}
+}
+
+// TestRunOnceUnsupported tests that backends without a result store are refused
+func TestRunOnceUnsupported(t *testing.T) {
+	lock, _ := NewFileLock(t.TempDir())
+	if _, err := NewOnce(lock, time.Minute); !errors.Is(err, ErrOnceUnsupported) {
+		t.Errorf("Expected ErrOnceUnsupported, got %v", err)
+	}
+}
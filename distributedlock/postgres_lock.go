@@ -0,0 +1,194 @@
+package distributedlock
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// PostgresLock implements DistributedLockService with PostgreSQL advisory
+// locks. Like MySQL named locks they belong to a session, so every held lock
+// pins one connection of the pool until it is released.
+//
+// In transaction-scoped mode the lock is taken with pg_try_advisory_xact_lock
+// inside a transaction on the pinned connection and released by committing
+// it; callers can run their own statements in that transaction via Tx.
+type PostgresLock struct {
+	db       *sql.DB
+	txScoped bool
+}
+
+// postgresHandle is the PostgreSQL-private state of a held lock
+type postgresHandle struct {
+	conn *sql.Conn
+	tx   *sql.Tx
+}
+
+// NewPostgresLock opens the database behind dsn (URL or key=value form)
+func NewPostgresLock(dsn string) (*PostgresLock, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
+
+	// Test the connection
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %v", err)
+	}
+
+	return &PostgresLock{db: db}, nil
+}
+
+// SetTransactionScoped switches between session-level locks (the default)
+// and transaction-scoped locks
+func (p *PostgresLock) SetTransactionScoped(enabled bool) {
+	p.txScoped = enabled
+}
+
+// AdvisoryLockID maps a string key to the 64-bit advisory lock ID used by
+// PostgresLock: the 64-bit FNV-1a hash of the UTF-8 key, reinterpreted as a
+// signed integer. Other services taking the same lock from SQL must use the
+// same mapping.
+func AdvisoryLockID(key string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return int64(h.Sum64())
+}
+
+func (p *PostgresLock) AcquireLock(ctx context.Context, lockInfo *DistributedLockInfo) (bool, error) {
+	// The lock lives as long as the session, so take a dedicated connection
+	conn, err := p.db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	h := &postgresHandle{conn: conn}
+	var acquired bool
+	if p.txScoped {
+		h.tx, err = conn.BeginTx(ctx, nil)
+		if err != nil {
+			conn.Close()
+			return false, err
+		}
+		err = h.tx.QueryRowContext(ctx, "SELECT pg_try_advisory_xact_lock($1)", AdvisoryLockID(lockInfo.key)).Scan(&acquired)
+	} else {
+		err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", AdvisoryLockID(lockInfo.key)).Scan(&acquired)
+	}
+	if err != nil {
+		h.close(true)
+		return false, err
+	}
+	if !acquired {
+		h.close(false)
+		return false, nil
+	}
+
+	lockInfo.handle = h
+	return true, nil
+}
+
+func (p *PostgresLock) ReleaseLock(ctx context.Context, lockInfo *DistributedLockInfo) (bool, error) {
+	h, ok := lockInfo.handle.(*postgresHandle)
+	if !ok {
+		return false, ErrLockNotHeld
+	}
+	lockInfo.handle = nil
+
+	// Transaction-scoped locks are released when the transaction ends
+	if h.tx != nil {
+		if err := h.tx.Commit(); err != nil {
+			h.close(true)
+			return false, err
+		}
+		h.close(false)
+		return true, nil
+	}
+
+	var released bool
+	err := h.conn.QueryRowContext(ctx, "SELECT pg_advisory_unlock($1)", AdvisoryLockID(lockInfo.key)).Scan(&released)
+	if err != nil {
+		// Ending the session is the only other way to drop the lock
+		h.close(true)
+		return false, err
+	}
+	h.close(false)
+	return released, nil
+}
+
+// RenewLock verifies that the pinned connection is alive and its backend
+// still holds the advisory lock. Advisory locks have no expiration, so a dead
+// connection means PostgreSQL has already dropped the lock and ErrLockLost is returned.
+func (p *PostgresLock) RenewLock(ctx context.Context, lockInfo *DistributedLockInfo) error {
+	h, ok := lockInfo.handle.(*postgresHandle)
+	if !ok {
+		return ErrLockNotHeld
+	}
+
+	// A bigint advisory key is split into classid (high half) and objid (low half)
+	id := uint64(AdvisoryLockID(lockInfo.key))
+	query := `SELECT COUNT(*) > 0 FROM pg_locks
+WHERE locktype = 'advisory' AND pid = pg_backend_pid() AND granted
+	AND classid::bigint = $1 AND objid::bigint = $2 AND objsubid = 1`
+	args := []interface{}{int64(id >> 32), int64(id & 0xffffffff)}
+
+	var held bool
+	var err error
+	if h.tx != nil {
+		err = h.tx.QueryRowContext(ctx, query, args...).Scan(&held)
+	} else {
+		err = h.conn.QueryRowContext(ctx, query, args...).Scan(&held)
+	}
+	if err != nil {
+		lockInfo.handle = nil
+		h.close(true)
+		return fmt.Errorf("%w: %v", ErrLockLost, err)
+	}
+	if !held {
+		lockInfo.handle = nil
+		h.close(false)
+		return ErrLockLost
+	}
+	return nil
+}
+
+// Tx returns the transaction holding a transaction-scoped lock, so work can
+// be done atomically with the lock. It returns ErrLockNotHeld for session-level locks.
+func (p *PostgresLock) Tx(lockInfo *DistributedLockInfo) (*sql.Tx, error) {
+	h, ok := lockInfo.handle.(*postgresHandle)
+	if !ok || h.tx == nil {
+		return nil, ErrLockNotHeld
+	}
+	return h.tx, nil
+}
+
+// NewLock returns a lock handle bound to this PostgreSQL instance
+func (p *PostgresLock) NewLock(key string, opts ...LockOption) Locker {
+	return newLockHandle(p, key, opts...)
+}
+
+func (p *PostgresLock) BuildServiceType() string {
+	return "postgres"
+}
+
+// Close closes the database connection
+func (p *PostgresLock) Close() error {
+	return p.db.Close()
+}
+
+// close ends the transaction (if any) and gives the connection back. With
+// discard set the connection is closed instead of being pooled, which ends
+// the session and every advisory lock it might still hold.
+func (h *postgresHandle) close(discard bool) {
+	if h.tx != nil {
+		h.tx.Rollback()
+	}
+	if discard {
+		discardConn(h.conn)
+		return
+	}
+	h.conn.Close()
+}
@@ -0,0 +1,101 @@
+package distributedlock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestAdvisoryLockID tests that the documented key hash is stable
+func TestAdvisoryLockID(t *testing.T) {
+	if got := AdvisoryLockID("orders"); got != 5169432582064972 {
+		t.Errorf("AdvisoryLockID(orders) = %d, the key mapping must not change", got)
+	}
+	if AdvisoryLockID("orders") == AdvisoryLockID("payments") {
+		t.Error("Expected different keys to map to different IDs")
+	}
+}
+
+// TestPostgresConfigDSN tests building a connection URL from fields
+func TestPostgresConfigDSN(t *testing.T) {
+	cfg := PostgresConfig{User: "app", Password: "p@ss", Host: "db", Port: 5432, DBName: "locks", SSLMode: "require"}
+	want := "postgres://app:p%40ss@db:5432/locks?sslmode=require"
+	if got := cfg.dsn(); got != want {
+		t.Errorf("dsn() = %q, want %q", got, want)
+	}
+}
+
+// TestPostgresLockSession tests session-level acquire, renew and release
+func TestPostgresLockSession(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+	lock := &PostgresLock{db: db}
+	lockInfo := NewDistributedLockInfo("orders", "owner", 30*time.Second)
+	id := AdvisoryLockID("orders")
+
+	mock.ExpectQuery("SELECT pg_try_advisory_lock").WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"r"}).AddRow(true))
+	mock.ExpectQuery("FROM pg_locks").WithArgs(id>>32, id&0xffffffff).
+		WillReturnRows(sqlmock.NewRows([]string{"r"}).AddRow(true))
+	mock.ExpectQuery("SELECT pg_advisory_unlock").WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"r"}).AddRow(true))
+
+	if ok, err := lock.AcquireLock(ctx, lockInfo); err != nil || !ok {
+		t.Fatalf("Expected to acquire the lock, got %v, %v", ok, err)
+	}
+	if err := lock.RenewLock(ctx, lockInfo); err != nil {
+		t.Fatalf("Expected renew to succeed, got %v", err)
+	}
+	if _, err := lock.Tx(lockInfo); !errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("Expected no transaction for a session lock, got %v", err)
+	}
+	if ok, err := lock.ReleaseLock(ctx, lockInfo); err != nil || !ok {
+		t.Fatalf("Expected to release the lock, got %v, %v", ok, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestPostgresLockTransactionScoped tests that a transaction-scoped lock is released by commit
+func TestPostgresLockTransactionScoped(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+	lock := &PostgresLock{db: db}
+	lock.SetTransactionScoped(true)
+	lockInfo := NewDistributedLockInfo("orders", "owner", 30*time.Second)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT pg_try_advisory_xact_lock").WithArgs(AdvisoryLockID("orders")).
+		WillReturnRows(sqlmock.NewRows([]string{"r"}).AddRow(true))
+	mock.ExpectExec("UPDATE jobs").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if ok, err := lock.AcquireLock(ctx, lockInfo); err != nil || !ok {
+		t.Fatalf("Expected to acquire the lock, got %v, %v", ok, err)
+	}
+	tx, err := lock.Tx(lockInfo)
+	if err != nil {
+		t.Fatalf("Expected the lock transaction, got %v", err)
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE jobs SET done = true"); err != nil {
+		t.Fatalf("Failed to use lock transaction: %v", err)
+	}
+	if ok, err := lock.ReleaseLock(ctx, lockInfo); err != nil || !ok {
+		t.Fatalf("Expected to release the lock, got %v, %v", ok, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
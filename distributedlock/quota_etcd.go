@@ -0,0 +1,72 @@
+package distributedlock
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// etcdQuotaStore keeps each slot of a namespace as a key under key/ attached
+// to its own lease. A new slot is put with a compare on the revision of the
+// whole prefix, so two replicas cannot both take the last one.
+type etcdQuotaStore struct {
+	client *clientv3.Client
+}
+
+func (s *etcdQuotaStore) take(ctx context.Context, key, member string, limit int, ttl time.Duration) (bool, error) {
+	prefix := key + "/"
+	slot := prefix + member
+	for {
+		resp, err := s.client.Get(ctx, slot)
+		if err != nil {
+			return false, err
+		}
+		if len(resp.Kvs) > 0 {
+			_, err := s.client.KeepAliveOnce(ctx, clientv3.LeaseID(resp.Kvs[0].Lease))
+			if !errors.Is(err, rpctypes.ErrLeaseNotFound) {
+				return err == nil, err
+			}
+			// The slot expired meanwhile; take a new one
+		}
+
+		count, err := s.client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+		if err != nil {
+			return false, err
+		}
+		if count.Count >= int64(limit) {
+			return false, nil
+		}
+		lease, err := s.client.Grant(ctx, leaseSeconds(ttl))
+		if err != nil {
+			return false, err
+		}
+		txn, err := s.client.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(prefix), "<", count.Header.Revision+1).WithPrefix()).
+			Then(clientv3.OpPut(slot, "", clientv3.WithLease(lease.ID))).
+			Commit()
+		if err != nil || !txn.Succeeded {
+			s.client.Revoke(context.WithoutCancel(ctx), lease.ID)
+			if err != nil {
+				return false, err
+			}
+			continue
+		}
+		return true, nil
+	}
+}
+
+// give revokes the slot's lease, which deletes the slot
+func (s *etcdQuotaStore) give(ctx context.Context, key, member string) error {
+	resp, err := s.client.Get(ctx, key+"/"+member)
+	if err != nil || len(resp.Kvs) == 0 {
+		return err
+	}
+	_, err = s.client.Revoke(ctx, clientv3.LeaseID(resp.Kvs[0].Lease))
+	if errors.Is(err, rpctypes.ErrLeaseNotFound) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,77 @@
+package distributedlock
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// quotaTable holds the slots of every namespace quota
+const quotaTable = "distributed_quota_slots"
+
+// mysqlQuotaStore keeps each slot in one row with its expiry on the
+// database clock. Takes of one namespace serialize on an anchor row with an
+// empty member, which never expires; expired slots are deleted by gc.
+type mysqlQuotaStore struct {
+	db *sql.DB
+	gc *mysqlExpiryGC
+}
+
+// newMySQLQuotaStore creates the slot table if needed
+func newMySQLQuotaStore(db *sql.DB) (*mysqlQuotaStore, error) {
+	_, err := db.ExecContext(context.Background(), `CREATE TABLE IF NOT EXISTS `+quotaTable+` (
+	name       VARCHAR(255) NOT NULL,
+	member     VARCHAR(64)  NOT NULL,
+	expires_at DATETIME(3)  NOT NULL,
+	PRIMARY KEY (name, member),
+	KEY (expires_at)
+) ENGINE=InnoDB`)
+	if err != nil {
+		return nil, err
+	}
+	return &mysqlQuotaStore{db: db, gc: newMySQLExpiryGC(db, quotaTable)}, nil
+}
+
+func (s *mysqlQuotaStore) take(ctx context.Context, key, member string, limit int, ttl time.Duration) (bool, error) {
+	taken, err := s.update(ctx, mysqlRowKey(key), member, limit, ttl)
+	if err == nil {
+		s.gc.collect(ctx)
+	}
+	return taken, err
+}
+
+func (s *mysqlQuotaStore) update(ctx context.Context, key, member string, limit int, ttl time.Duration) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `INSERT IGNORE INTO `+quotaTable+` (name, member, expires_at) VALUES (?, '', '9999-12-31 23:59:59')`, key); err != nil {
+		return false, err
+	}
+	var anchor string
+	if err := tx.QueryRowContext(ctx, `SELECT member FROM `+quotaTable+` WHERE name = ? AND member = '' FOR UPDATE`, key).Scan(&anchor); err != nil {
+		return false, err
+	}
+
+	var others int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+quotaTable+` WHERE name = ? AND member NOT IN ('', ?) AND expires_at > NOW(3)`,
+		key, member).Scan(&others); err != nil {
+		return false, err
+	}
+	if others >= limit {
+		return false, tx.Commit()
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO `+quotaTable+` (name, member, expires_at)
+VALUES (?, ?, NOW(3) + INTERVAL ? MICROSECOND)
+ON DUPLICATE KEY UPDATE expires_at = VALUES(expires_at)`, key, member, ttl.Microseconds()); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func (s *mysqlQuotaStore) give(ctx context.Context, key, member string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM `+quotaTable+` WHERE name = ? AND member = ?`, mysqlRowKey(key), member)
+	return err
+}
@@ -0,0 +1,41 @@
+package distributedlock
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// quotaTakeScript takes or refreshes a slot in a zset scored by expiry. Time
+// comes from the Redis clock, so replicas need not agree on theirs.
+// KEYS[1] slot zset
+// ARGV[1] member, ARGV[2] limit, ARGV[3] ttl ms
+var quotaTakeScript = redis.NewScript(`
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now)
+if not redis.call('ZSCORE', KEYS[1], ARGV[1]) and redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], now + tonumber(ARGV[3]), ARGV[1])
+local last = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+redis.call('PEXPIRE', KEYS[1], tonumber(last[2]) - now)
+return 1
+`)
+
+// redisQuotaStore keeps the slots of a namespace in one zset, so a take is
+// one atomic round trip and stays on one slot in cluster mode
+type redisQuotaStore struct {
+	client redis.UniversalClient
+}
+
+func (s *redisQuotaStore) take(ctx context.Context, key, member string, limit int, ttl time.Duration) (bool, error) {
+	taken, err := quotaTakeScript.Run(ctx, s.client, []string{key}, member, limit, ttl.Milliseconds()).Int()
+	return taken == 1, err
+}
+
+func (s *redisQuotaStore) give(ctx context.Context, key, member string) error {
+	return s.client.ZRem(ctx, key, member).Err()
+}
@@ -0,0 +1,300 @@
+package distributedlock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RateAlgorithm selects how a RateLimiter counts calls
+type RateAlgorithm string
+
+const (
+	// TokenBucket refills Rate tokens per Per up to Burst; a call takes one token
+	TokenBucket RateAlgorithm = "token-bucket"
+	// SlidingWindow allows at most Rate calls in any window of length Per
+	SlidingWindow RateAlgorithm = "sliding-window"
+)
+
+// rateLimitPrefix is put in front of every rate limit key in the backend
+const rateLimitPrefix = "ratelimit/"
+
+var (
+	// ErrRateLimiterUnsupported is returned for backends without rate limit support
+	ErrRateLimiterUnsupported = errors.New("rate limiter not supported by this backend")
+	// ErrRateLimitExceedsBurst is returned when one call asks for more than the limit can ever allow
+	ErrRateLimitExceedsBurst = errors.New("rate limit request exceeds burst")
+)
+
+// Limit is the number of calls allowed per period
+type Limit struct {
+	Rate int
+	Per  time.Duration
+	// Burst is the token bucket size; 0 means Rate. Unused by SlidingWindow.
+	Burst int
+}
+
+func (l Limit) validate() error {
+	if l.Rate <= 0 || l.Per < time.Millisecond || l.Burst < 0 {
+		return fmt.Errorf("invalid rate limit: %d per %v (burst %d)", l.Rate, l.Per, l.Burst)
+	}
+	return nil
+}
+
+// capacity is the most calls one request may ask for
+func (l Limit) capacity(algorithm RateAlgorithm) int {
+	if algorithm == TokenBucket && l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Rate
+}
+
+// Reservation is a call slot granted by Reserve. The caller should act
+// after Delay, at TimeToAct, to stay within the limit.
+type Reservation struct {
+	Delay     time.Duration
+	TimeToAct time.Time
+}
+
+// rateResult is the outcome of one take on a rate store
+type rateResult struct {
+	allowed bool
+	// wait is how long until the request would be allowed (or, for a
+	// reservation, until the reserved slot)
+	wait time.Duration
+}
+
+// rateStore atomically applies a rate limit algorithm to the state of a key
+type rateStore interface {
+	// take applies the algorithm to key at now (unix ms)
+	take(ctx context.Context, key string, algorithm RateAlgorithm, limit Limit, n int, reserve bool, now int64) (rateResult, error)
+}
+
+// RateLimiter limits calls per key across all replicas sharing a backend.
+// It stores its state next to the locks of a Redis, etcd or MySQL backend,
+// reusing that backend's client. Redis takes the time from its own clock;
+// etcd and MySQL take it from the local one, so there the replicas' clocks
+// should be in sync.
+type RateLimiter struct {
+	store     rateStore
+	algorithm RateAlgorithm
+	prefix    string
+	now       func() time.Time
+
+	mu        sync.RWMutex
+	limit     Limit
+	keyLimits map[string]Limit
+}
+
+// NewRateLimiter creates a rate limiter on the backend under service (see
+// unwrapBackend). limit applies to every key without its own limit.
+func NewRateLimiter(service DistributedLockService, algorithm RateAlgorithm, limit Limit) (*RateLimiter, error) {
+	if algorithm != TokenBucket && algorithm != SlidingWindow {
+		return nil, fmt.Errorf("unsupported rate limit algorithm: %s", algorithm)
+	}
+	if err := limit.validate(); err != nil {
+		return nil, err
+	}
+
+	backend, prefix := unwrapBackend(service)
+	var store rateStore
+	switch s := backend.(type) {
+	case *RedisLock:
+		store = &redisRateStore{client: s.client}
+	case *EtcdLock:
+		store = &etcdRateStore{client: s.pool.client}
+	case *MySQLLock:
+		mysqlStore, err := newMySQLRateStore(s.db)
+		if err != nil {
+			return nil, err
+		}
+		store = mysqlStore
+	case *MySQLLeaseLock:
+		mysqlStore, err := newMySQLRateStore(s.db)
+		if err != nil {
+			return nil, err
+		}
+		store = mysqlStore
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrRateLimiterUnsupported, service.BuildServiceType())
+	}
+
+	return &RateLimiter{
+		store:     store,
+		algorithm: algorithm,
+		prefix:    prefix + rateLimitPrefix,
+		now:       time.Now,
+		limit:     limit,
+		keyLimits: make(map[string]Limit),
+	}, nil
+}
+
+// SetKeyLimit overrides the limit for one key
+func (r *RateLimiter) SetKeyLimit(key string, limit Limit) error {
+	if err := limit.validate(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keyLimits[key] = limit
+	return nil
+}
+
+// Allow reports whether one call for key may happen now and counts it if so
+func (r *RateLimiter) Allow(ctx context.Context, key string) (bool, error) {
+	return r.AllowN(ctx, key, 1)
+}
+
+// AllowN reports whether n calls for key may happen now and counts them if so
+func (r *RateLimiter) AllowN(ctx context.Context, key string, n int) (bool, error) {
+	result, err := r.take(ctx, key, n, false)
+	return result.allowed, err
+}
+
+// Reserve counts one call for key right away and returns when it may happen
+func (r *RateLimiter) Reserve(ctx context.Context, key string) (Reservation, error) {
+	return r.ReserveN(ctx, key, 1)
+}
+
+// ReserveN counts n calls for key right away and returns when they may happen.
+// Unlike Allow, a reservation is always granted, possibly in the future.
+func (r *RateLimiter) ReserveN(ctx context.Context, key string, n int) (Reservation, error) {
+	result, err := r.take(ctx, key, n, true)
+	if err != nil {
+		return Reservation{}, err
+	}
+	return Reservation{Delay: result.wait, TimeToAct: r.now().Add(result.wait)}, nil
+}
+
+// Wait blocks until one call for key is allowed or ctx is done
+func (r *RateLimiter) Wait(ctx context.Context, key string) error {
+	return r.WaitN(ctx, key, 1)
+}
+
+// WaitN blocks until n calls for key are allowed or ctx is done
+func (r *RateLimiter) WaitN(ctx context.Context, key string, n int) error {
+	for {
+		result, err := r.take(ctx, key, n, false)
+		if err != nil {
+			return err
+		}
+		if result.allowed {
+			return nil
+		}
+
+		timer := time.NewTimer(result.wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (r *RateLimiter) take(ctx context.Context, key string, n int, reserve bool) (rateResult, error) {
+	limit := r.limitFor(key)
+	if n <= 0 {
+		return rateResult{allowed: true}, nil
+	}
+	if n > limit.capacity(r.algorithm) {
+		return rateResult{}, ErrRateLimitExceedsBurst
+	}
+	return r.store.take(ctx, r.prefix+key, r.algorithm, limit, n, reserve, r.now().UnixMilli())
+}
+
+func (r *RateLimiter) limitFor(key string) Limit {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if limit, ok := r.keyLimits[key]; ok {
+		return limit
+	}
+	return r.limit
+}
+
+// rateState is the state of one key for stores that run the algorithms in Go
+type rateState struct {
+	// Tokens and Updated (unix ms) are used by TokenBucket
+	Tokens  float64 `json:"tokens,omitempty"`
+	Updated int64   `json:"updated,omitempty"`
+	// Events are the sorted call times (unix ms) used by SlidingWindow
+	Events []int64 `json:"events,omitempty"`
+}
+
+// take applies the algorithm at now (unix ms). The state only changes if
+// the result is allowed or reserve is set.
+func (s *rateState) take(algorithm RateAlgorithm, limit Limit, n int, reserve bool, now int64) rateResult {
+	if algorithm == SlidingWindow {
+		return s.takeWindow(limit, n, reserve, now)
+	}
+	return s.takeTokens(limit, n, reserve, now)
+}
+
+// ttl is how long the state matters after now. Once it has passed, a take
+// sees the same as on an empty state, so stores may delete it.
+func (s *rateState) ttl(algorithm RateAlgorithm, limit Limit, now int64) time.Duration {
+	if algorithm == SlidingWindow {
+		if len(s.Events) == 0 {
+			return limit.Per
+		}
+		return time.Duration(s.Events[len(s.Events)-1]-now)*time.Millisecond + limit.Per
+	}
+	rate := float64(limit.Rate) / float64(limit.Per.Milliseconds())
+	refill := math.Ceil((float64(limit.capacity(TokenBucket))-s.Tokens)/rate) + 1
+	return time.Duration(s.Updated-now+int64(refill)) * time.Millisecond
+}
+
+func (s *rateState) takeTokens(limit Limit, n int, reserve bool, now int64) rateResult {
+	rate := float64(limit.Rate) / float64(limit.Per.Milliseconds()) // tokens per ms
+	burst := float64(limit.capacity(TokenBucket))
+
+	tokens, updated := s.Tokens, s.Updated
+	if updated == 0 {
+		tokens, updated = burst, now
+	}
+	if now > updated {
+		tokens = math.Min(burst, tokens+float64(now-updated)*rate)
+		updated = now
+	}
+
+	result := rateResult{allowed: true}
+	if tokens < float64(n) {
+		result.wait = time.Duration(math.Ceil((float64(n)-tokens)/rate)) * time.Millisecond
+		result.allowed = reserve
+	}
+	if result.allowed {
+		s.Tokens, s.Updated = tokens-float64(n), updated
+	}
+	return result
+}
+
+func (s *rateState) takeWindow(limit Limit, n int, reserve bool, now int64) rateResult {
+	window := limit.Per.Milliseconds()
+
+	// Drop the calls that have left the window
+	events := s.Events
+	for len(events) > 0 && events[0] <= now-window {
+		events = events[1:]
+	}
+
+	at := now
+	result := rateResult{allowed: true}
+	if excess := len(events) + n - limit.Rate; excess > 0 {
+		// The call fits once the excess-th oldest call has left the window
+		at = events[excess-1] + window
+		result.wait = time.Duration(at-now) * time.Millisecond
+		result.allowed = reserve
+	}
+	if result.allowed {
+		for i := 0; i < n; i++ {
+			events = append(events, at)
+		}
+		sort.Slice(events, func(i, j int) bool { return events[i] < events[j] })
+		s.Events = events
+	}
+	return result
+}
@@ -0,0 +1,100 @@
+package distributedlock
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// etcdRateStore keeps the state of each key as JSON and updates it with a
+// compare-and-swap on the key's revision, retrying when another replica
+// got there first. The key is attached to a lease that runs out once the
+// state no longer matters, so idle keys are deleted by etcd. A write keeps
+// the key's lease while it lasts long enough; otherwise it grants one with
+// a period of headroom and revokes the one it replaces.
+type etcdRateStore struct {
+	client *clientv3.Client
+}
+
+func (s *etcdRateStore) take(ctx context.Context, key string, algorithm RateAlgorithm, limit Limit, n int, reserve bool, now int64) (rateResult, error) {
+	for {
+		resp, err := s.client.Get(ctx, key)
+		if err != nil {
+			return rateResult{}, err
+		}
+
+		var state rateState
+		var rev int64
+		var current clientv3.LeaseID
+		if len(resp.Kvs) > 0 {
+			rev = resp.Kvs[0].ModRevision
+			current = clientv3.LeaseID(resp.Kvs[0].Lease)
+			if err := json.Unmarshal(resp.Kvs[0].Value, &state); err != nil {
+				return rateResult{}, err
+			}
+		}
+
+		result := state.take(algorithm, limit, n, reserve, now)
+		if !result.allowed {
+			return result, nil
+		}
+
+		data, err := json.Marshal(&state)
+		if err != nil {
+			return rateResult{}, err
+		}
+		ttl := state.ttl(algorithm, limit, now)
+		lease, granted, err := s.lease(ctx, current, ttl, limit.Per)
+		if err != nil {
+			return rateResult{}, err
+		}
+		txn, err := s.client.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(key), "=", rev)).
+			Then(clientv3.OpPut(key, string(data), clientv3.WithLease(lease))).
+			Commit()
+		if err != nil || !txn.Succeeded {
+			if granted {
+				s.revoke(ctx, lease)
+			}
+			if err != nil {
+				return rateResult{}, err
+			}
+			continue
+		}
+		if granted && current != clientv3.NoLease {
+			// The old lease has no keys left
+			s.revoke(ctx, current)
+		}
+		return result, nil
+	}
+}
+
+// lease returns current if it still has ttl to live, or a new lease of ttl
+// plus headroom, so that the next takes can keep it too
+func (s *etcdRateStore) lease(ctx context.Context, current clientv3.LeaseID, ttl, headroom time.Duration) (clientv3.LeaseID, bool, error) {
+	if current != clientv3.NoLease {
+		resp, err := s.client.TimeToLive(ctx, current)
+		if err != nil {
+			return 0, false, err
+		}
+		if resp.TTL >= leaseSeconds(ttl) {
+			return current, false, nil
+		}
+	}
+	lease, err := s.client.Grant(ctx, leaseSeconds(ttl+headroom))
+	if err != nil {
+		return 0, false, err
+	}
+	return lease.ID, true, nil
+}
+
+// revoke drops a lease nothing uses any more; if it fails, the lease still
+// runs out by itself
+func (s *etcdRateStore) revoke(ctx context.Context, lease clientv3.LeaseID) {
+	if _, err := s.client.Revoke(context.WithoutCancel(ctx), lease); err != nil {
+		log.Printf("Rate limiter failed to revoke lease %x: %v\n", lease, err)
+	}
+}
@@ -0,0 +1,78 @@
+package distributedlock
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+)
+
+// rateLimitTable holds the state of every rate limit key
+const rateLimitTable = "distributed_rate_limits"
+
+// mysqlRateStore keeps the state of each key as JSON in one row and updates
+// it under SELECT ... FOR UPDATE. Rows carry the time after which their
+// state no longer matters and are deleted by gc.
+type mysqlRateStore struct {
+	db *sql.DB
+	gc *mysqlExpiryGC
+}
+
+// newMySQLRateStore creates the rate limit table if needed
+func newMySQLRateStore(db *sql.DB) (*mysqlRateStore, error) {
+	_, err := db.ExecContext(context.Background(), `CREATE TABLE IF NOT EXISTS `+rateLimitTable+` (
+	name       VARCHAR(255) NOT NULL,
+	state      MEDIUMTEXT   NOT NULL,
+	expires_at DATETIME(3)  NOT NULL,
+	PRIMARY KEY (name),
+	KEY (expires_at)
+) ENGINE=InnoDB`)
+	if err != nil {
+		return nil, err
+	}
+	return &mysqlRateStore{db: db, gc: newMySQLExpiryGC(db, rateLimitTable)}, nil
+}
+
+func (s *mysqlRateStore) take(ctx context.Context, key string, algorithm RateAlgorithm, limit Limit, n int, reserve bool, now int64) (rateResult, error) {
+	result, err := s.update(ctx, mysqlRowKey(key), algorithm, limit, n, reserve, now)
+	if err == nil {
+		s.gc.collect(ctx)
+	}
+	return result, err
+}
+
+func (s *mysqlRateStore) update(ctx context.Context, key string, algorithm RateAlgorithm, limit Limit, n int, reserve bool, now int64) (rateResult, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return rateResult{}, err
+	}
+	defer tx.Rollback()
+
+	// Make sure there is a row to lock, so concurrent first takes serialize too
+	if _, err := tx.ExecContext(ctx, `INSERT IGNORE INTO `+rateLimitTable+` (name, state, expires_at) VALUES (?, '{}', NOW(3))`, key); err != nil {
+		return rateResult{}, err
+	}
+	var data string
+	if err := tx.QueryRowContext(ctx, `SELECT state FROM `+rateLimitTable+` WHERE name = ? FOR UPDATE`, key).Scan(&data); err != nil {
+		return rateResult{}, err
+	}
+
+	var state rateState
+	if err := json.Unmarshal([]byte(data), &state); err != nil {
+		return rateResult{}, err
+	}
+	result := state.take(algorithm, limit, n, reserve, now)
+	if !result.allowed {
+		return result, tx.Commit()
+	}
+
+	updated, err := json.Marshal(&state)
+	if err != nil {
+		return rateResult{}, err
+	}
+	ttl := state.ttl(algorithm, limit, now)
+	if _, err := tx.ExecContext(ctx, `UPDATE `+rateLimitTable+` SET state = ?, expires_at = NOW(3) + INTERVAL ? MICROSECOND WHERE name = ?`,
+		string(updated), ttl.Microseconds(), key); err != nil {
+		return rateResult{}, err
+	}
+	return result, tx.Commit()
+}
@@ -0,0 +1,113 @@
+package distributedlock
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// tokenBucketScript takes tokens from a bucket stored as a hash.
+// KEYS[1] bucket hash
+// ARGV[1] refill rate in tokens/ms, ARGV[2] burst, ARGV[3] n, ARGV[4] reserve (1/0)
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(state[1])
+local updated = tonumber(state[2])
+if not tokens or not updated then
+	tokens = burst
+	updated = now
+end
+if now > updated then
+	tokens = math.min(burst, tokens + (now - updated) * rate)
+	updated = now
+end
+
+local allowed = 1
+local wait = 0
+if tokens < n then
+	wait = math.ceil((n - tokens) / rate)
+	if ARGV[4] ~= '1' then
+		allowed = 0
+	end
+end
+if allowed == 1 then
+	tokens = tokens - n
+	redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'updated', string.format('%d', updated))
+	redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) / rate) + 1)
+end
+return {allowed, wait}
+`)
+
+// slidingWindowScript records calls as zset members scored by their time.
+// KEYS[1] window zset
+// ARGV[1] window ms, ARGV[2] limit, ARGV[3] n, ARGV[4] reserve (1/0), ARGV[5] member id
+var slidingWindowScript = redis.NewScript(`
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+
+local at = now
+local allowed = 1
+local wait = 0
+local excess = count + n - limit
+if excess > 0 then
+	local oldest = redis.call('ZRANGE', KEYS[1], excess - 1, excess - 1, 'WITHSCORES')
+	at = tonumber(oldest[2]) + window
+	wait = at - now
+	if ARGV[4] ~= '1' then
+		allowed = 0
+	end
+end
+if allowed == 1 then
+	for i = 1, n do
+		redis.call('ZADD', KEYS[1], at, ARGV[5] .. ':' .. i)
+	end
+	local last = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+	redis.call('PEXPIRE', KEYS[1], tonumber(last[2]) - now + window)
+end
+return {allowed, wait}
+`)
+
+// redisRateStore runs the rate limit algorithms as Lua scripts, so every
+// take is one atomic round trip. The scripts read the Redis clock instead
+// of now. Each key is a single Redis key, which keeps it on one slot in
+// cluster mode.
+type redisRateStore struct {
+	client redis.UniversalClient
+}
+
+func (s *redisRateStore) take(ctx context.Context, key string, algorithm RateAlgorithm, limit Limit, n int, reserve bool, _ int64) (rateResult, error) {
+	reserveArg := "0"
+	if reserve {
+		reserveArg = "1"
+	}
+
+	var cmd *redis.Cmd
+	if algorithm == SlidingWindow {
+		cmd = slidingWindowScript.Run(ctx, s.client, []string{key},
+			limit.Per.Milliseconds(), limit.Rate, n, reserveArg, newLockToken())
+	} else {
+		rate := float64(limit.Rate) / float64(limit.Per.Milliseconds())
+		cmd = tokenBucketScript.Run(ctx, s.client, []string{key},
+			strconv.FormatFloat(rate, 'g', -1, 64), limit.capacity(TokenBucket), n, reserveArg)
+	}
+
+	values, err := cmd.Int64Slice()
+	if err != nil {
+		return rateResult{}, err
+	}
+	return rateResult{allowed: values[0] == 1, wait: time.Duration(values[1]) * time.Millisecond}, nil
+}
@@ -0,0 +1,351 @@
+package distributedlock
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// newTestRateLimiter returns a Redis rate limiter and a function that moves
+// the Redis clock, which the scripts read, and the limiter's clock forward
+func newTestRateLimiter(t *testing.T, algorithm RateAlgorithm, limit Limit) (*RateLimiter, func(time.Duration)) {
+	t.Helper()
+	lock, mr := newTestRedisLock(t)
+	limiter, err := NewRateLimiter(lock, algorithm, limit)
+	if err != nil {
+		t.Fatalf("Failed to create rate limiter: %v", err)
+	}
+	now := time.UnixMilli(1_700_000_000_000)
+	mr.SetTime(now)
+	limiter.now = func() time.Time { return now }
+	return limiter, func(d time.Duration) {
+		now = now.Add(d)
+		mr.SetTime(now)
+	}
+}
+
+// TestRateStateTokenBucket tests refill, burst and reservations of the Go token bucket
+func TestRateStateTokenBucket(t *testing.T) {
+	limit := Limit{Rate: 1, Per: 100 * time.Millisecond, Burst: 3}
+	var state rateState
+	now := int64(1000)
+
+	for i := 0; i < 3; i++ {
+		if result := state.take(TokenBucket, limit, 1, false, now); !result.allowed {
+			t.Fatalf("Expected call %d within burst to be allowed", i)
+		}
+	}
+	result := state.take(TokenBucket, limit, 1, false, now)
+	if result.allowed || result.wait != 100*time.Millisecond {
+		t.Errorf("Expected denial with 100ms wait, got %+v", result)
+	}
+
+	if result := state.take(TokenBucket, limit, 1, false, now+100); !result.allowed {
+		t.Error("Expected refilled token to be allowed")
+	}
+
+	result = state.take(TokenBucket, limit, 2, true, now+100)
+	if !result.allowed || result.wait != 200*time.Millisecond {
+		t.Errorf("Expected reservation 200ms ahead, got %+v", result)
+	}
+	result = state.take(TokenBucket, limit, 1, false, now+250)
+	if result.allowed || result.wait != 150*time.Millisecond {
+		t.Errorf("Expected reservation to be paid back first, got %+v", result)
+	}
+}
+
+// TestRateStateSlidingWindow tests that the Go sliding window counts calls in the last period
+func TestRateStateSlidingWindow(t *testing.T) {
+	limit := Limit{Rate: 2, Per: time.Second}
+	var state rateState
+
+	state.take(SlidingWindow, limit, 1, false, 1000)
+	state.take(SlidingWindow, limit, 1, false, 1400)
+	result := state.take(SlidingWindow, limit, 1, false, 1500)
+	if result.allowed || result.wait != 500*time.Millisecond {
+		t.Errorf("Expected denial until the first call leaves the window, got %+v", result)
+	}
+	if len(state.Events) != 2 {
+		t.Errorf("Expected denied call not to be recorded, got %v", state.Events)
+	}
+
+	if result := state.take(SlidingWindow, limit, 1, false, 2000); !result.allowed {
+		t.Error("Expected call to be allowed once the window slid")
+	}
+
+	result = state.take(SlidingWindow, limit, 1, true, 2100)
+	if !result.allowed || result.wait != 300*time.Millisecond {
+		t.Errorf("Expected reservation when the second call leaves the window, got %+v", result)
+	}
+}
+
+// TestRateStateTTL tests how long the state of a key is kept by the Go stores
+func TestRateStateTTL(t *testing.T) {
+	bucket := Limit{Rate: 1, Per: 100 * time.Millisecond, Burst: 3}
+	var state rateState
+	state.take(TokenBucket, bucket, 2, false, 1000)
+	if got := state.ttl(TokenBucket, bucket, 1000); got != 201*time.Millisecond {
+		t.Errorf("Expected the bucket to be kept until it refilled, got %v", got)
+	}
+
+	window := Limit{Rate: 2, Per: time.Second}
+	state = rateState{}
+	if got := state.ttl(SlidingWindow, window, 1000); got != time.Second {
+		t.Errorf("Expected an empty window to be kept for one period, got %v", got)
+	}
+	state.take(SlidingWindow, window, 1, false, 1000)
+	state.take(SlidingWindow, window, 1, true, 1200)
+	if got := state.ttl(SlidingWindow, window, 1200); got != time.Second {
+		t.Errorf("Expected the window to be kept until the last call left it, got %v", got)
+	}
+}
+
+// TestEtcdRateStoreConcurrentTakes tests that the compare-and-swap loop
+// lets exactly the limit through when replicas race, and leases the state
+func TestEtcdRateStoreConcurrentTakes(t *testing.T) {
+	ctx := context.Background()
+	client := newTestEtcd(t)
+	store := &etcdRateStore{client: client}
+
+	for _, algorithm := range []RateAlgorithm{TokenBucket, SlidingWindow} {
+		key := "ratelimit/" + string(algorithm)
+		limit := Limit{Rate: 5, Per: time.Minute}
+
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		allowed := 0
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				result, err := store.take(ctx, key, algorithm, limit, 1, false, 1_700_000_000_000)
+				if err != nil {
+					t.Errorf("Take failed: %v", err)
+					return
+				}
+				if result.allowed {
+					mu.Lock()
+					allowed++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		if allowed != limit.Rate {
+			t.Errorf("Expected %d of 20 racing %s takes to be allowed, got %d", limit.Rate, algorithm, allowed)
+		}
+
+		resp, err := client.Get(ctx, key)
+		if err != nil || len(resp.Kvs) == 0 {
+			t.Fatalf("Expected state for %s, got %v", key, err)
+		}
+		ttl, err := client.TimeToLive(ctx, clientv3.LeaseID(resp.Kvs[0].Lease))
+		if err != nil || ttl.TTL <= 0 || ttl.TTL > 121 {
+			t.Errorf("Expected the state to expire within two periods, got %+v, %v", ttl, err)
+		}
+	}
+
+	// Leases of lost compare-and-swaps are revoked
+	leases, err := client.Leases(ctx)
+	if err != nil || len(leases.Leases) != 2 {
+		t.Errorf("Expected one lease per key, got %+v, %v", leases, err)
+	}
+}
+
+// TestEtcdRateStoreReusesLease tests that takes keep the key's lease while
+// it lasts long enough instead of granting one per call
+func TestEtcdRateStoreReusesLease(t *testing.T) {
+	ctx := context.Background()
+	client := newTestEtcd(t)
+	store := &etcdRateStore{client: client}
+	limit := Limit{Rate: 100, Per: time.Hour}
+
+	now := int64(1_700_000_000_000)
+	for i := 0; i < 20; i++ {
+		if result, err := store.take(ctx, "ratelimit/reuse", TokenBucket, limit, 1, false, now); err != nil || !result.allowed {
+			t.Fatalf("Expected take %d to be allowed, got %+v, %v", i, result, err)
+		}
+	}
+	leases, err := client.Leases(ctx)
+	if err != nil || len(leases.Leases) != 1 {
+		t.Fatalf("Expected a single lease for 20 takes, got %+v, %v", leases, err)
+	}
+	first := leases.Leases[0].ID
+
+	// A take that needs a longer TTL than the lease has left moves the key
+	// to a new lease and revokes the old one
+	store.take(ctx, "ratelimit/reuse", TokenBucket, limit, 300, true, now)
+	leases, err = client.Leases(ctx)
+	if err != nil || len(leases.Leases) != 1 || leases.Leases[0].ID == first {
+		t.Errorf("Expected the old lease to be replaced, got %+v, %v", leases, err)
+	}
+}
+
+// TestMySQLRateStore tests the FOR UPDATE transaction, the expiry column and GC
+func TestMySQLRateStore(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("Failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+	store := &mysqlRateStore{db: db, gc: newMySQLExpiryGC(db, rateLimitTable)}
+	limit := Limit{Rate: 1, Per: time.Second}
+
+	insert := "INSERT IGNORE INTO distributed_rate_limits (name, state, expires_at) VALUES (?, '{}', NOW(3))"
+	selectState := "SELECT state FROM distributed_rate_limits WHERE name = ? FOR UPDATE"
+	mock.ExpectBegin()
+	mock.ExpectExec(insert).WithArgs("api").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(selectState).WithArgs("api").
+		WillReturnRows(sqlmock.NewRows([]string{"state"}).AddRow("{}"))
+	mock.ExpectExec("UPDATE distributed_rate_limits SET state = ?, expires_at = NOW(3) + INTERVAL ? MICROSECOND WHERE name = ?").
+		WithArgs(`{"events":[1000]}`, int64(time.Second/time.Microsecond), "api").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec("DELETE FROM distributed_rate_limits WHERE expires_at < NOW(3) LIMIT 1000").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	if result, err := store.take(ctx, "api", SlidingWindow, limit, 1, false, 1000); err != nil || !result.allowed {
+		t.Fatalf("Expected first call to be allowed, got %+v, %v", result, err)
+	}
+
+	// A denied call leaves the row alone, and GC waits for its interval
+	mock.ExpectBegin()
+	mock.ExpectExec(insert).WithArgs("api").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(selectState).WithArgs("api").
+		WillReturnRows(sqlmock.NewRows([]string{"state"}).AddRow(`{"events":[1000]}`))
+	mock.ExpectCommit()
+
+	result, err := store.take(ctx, "api", SlidingWindow, limit, 1, false, 1500)
+	if err != nil || result.allowed || result.wait != 500*time.Millisecond {
+		t.Errorf("Expected denial with 500ms wait, got %+v, %v", result, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestRedisRateLimiterTokenBucket tests the token bucket script against the same steps as the Go version
+func TestRedisRateLimiterTokenBucket(t *testing.T) {
+	ctx := context.Background()
+	limiter, advance := newTestRateLimiter(t, TokenBucket, Limit{Rate: 1, Per: 100 * time.Millisecond, Burst: 3})
+
+	for i := 0; i < 3; i++ {
+		if allowed, err := limiter.Allow(ctx, "api"); err != nil || !allowed {
+			t.Fatalf("Expected call %d within burst to be allowed, got %v, %v", i, allowed, err)
+		}
+	}
+	if allowed, _ := limiter.Allow(ctx, "api"); allowed {
+		t.Error("Expected call beyond burst to be denied")
+	}
+	if allowed, _ := limiter.Allow(ctx, "other"); !allowed {
+		t.Error("Expected other key to have its own bucket")
+	}
+
+	advance(100 * time.Millisecond)
+	if allowed, _ := limiter.Allow(ctx, "api"); !allowed {
+		t.Error("Expected refilled token to be allowed")
+	}
+
+	r, err := limiter.ReserveN(ctx, "api", 2)
+	if err != nil {
+		t.Fatalf("Failed to reserve: %v", err)
+	}
+	if r.Delay != 200*time.Millisecond || !r.TimeToAct.Equal(limiter.now().Add(200*time.Millisecond)) {
+		t.Errorf("Expected reservation 200ms ahead, got %+v", r)
+	}
+
+	if _, err := limiter.AllowN(ctx, "api", 4); !errors.Is(err, ErrRateLimitExceedsBurst) {
+		t.Errorf("Expected ErrRateLimitExceedsBurst, got %v", err)
+	}
+}
+
+// TestRedisRateLimiterSlidingWindow tests the sliding window script and per-key limits
+func TestRedisRateLimiterSlidingWindow(t *testing.T) {
+	ctx := context.Background()
+	limiter, advance := newTestRateLimiter(t, SlidingWindow, Limit{Rate: 2, Per: time.Second})
+	if err := limiter.SetKeyLimit("vip", Limit{Rate: 5, Per: time.Second}); err != nil {
+		t.Fatalf("Failed to set key limit: %v", err)
+	}
+
+	limiter.Allow(ctx, "api")
+	advance(400 * time.Millisecond)
+	limiter.Allow(ctx, "api")
+	advance(100 * time.Millisecond)
+	if allowed, _ := limiter.Allow(ctx, "api"); allowed {
+		t.Error("Expected third call in the window to be denied")
+	}
+	if allowed, _ := limiter.AllowN(ctx, "vip", 3); !allowed {
+		t.Error("Expected key limit to apply to vip")
+	}
+
+	advance(500 * time.Millisecond)
+	if allowed, _ := limiter.Allow(ctx, "api"); !allowed {
+		t.Error("Expected call to be allowed once the window slid")
+	}
+
+	advance(100 * time.Millisecond)
+	r, err := limiter.Reserve(ctx, "api")
+	if err != nil {
+		t.Fatalf("Failed to reserve: %v", err)
+	}
+	if r.Delay != 300*time.Millisecond {
+		t.Errorf("Expected reservation 300ms ahead, got %v", r.Delay)
+	}
+}
+
+// TestRateLimiterWait tests that Wait blocks until the limit allows the call
+func TestRateLimiterWait(t *testing.T) {
+	lock, _ := newTestRedisLock(t)
+	limiter, err := NewRateLimiter(lock, TokenBucket, Limit{Rate: 1, Per: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Failed to create rate limiter: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		if err := limiter.Wait(ctx, "api"); err != nil {
+			t.Fatalf("Failed to wait: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("Expected second call to wait for a token, took %v", elapsed)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := limiter.Wait(ctx, "api"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected DeadlineExceeded, got %v", err)
+	}
+}
+
+// TestRateLimiterNamespace tests that a namespaced service keeps its rate limits apart
+func TestRateLimiterNamespace(t *testing.T) {
+	ctx := context.Background()
+	lock, mr := newTestRedisLock(t)
+	ns, _ := NewNamespacedService(lock, Namespace{Prefix: "tenant-a"})
+	limiter, err := NewRateLimiter(ns, SlidingWindow, Limit{Rate: 1, Per: time.Second})
+	if err != nil {
+		t.Fatalf("Failed to create rate limiter: %v", err)
+	}
+
+	limiter.Allow(ctx, "api")
+	if !mr.Exists("tenant-a/ratelimit/api") {
+		t.Errorf("Expected state under the namespace prefix, got keys %v", mr.Keys())
+	}
+}
+
+// TestRateLimiterUnsupported tests that backends without a shared store are refused
+func TestRateLimiterUnsupported(t *testing.T) {
+	lock, _ := NewFileLock(t.TempDir())
+	if _, err := NewRateLimiter(lock, TokenBucket, Limit{Rate: 1, Per: time.Second}); !errors.Is(err, ErrRateLimiterUnsupported) {
+		t.Errorf("Expected ErrRateLimiterUnsupported, got %v", err)
+	}
+}
@@ -2,12 +2,25 @@ package distributedlock
 
 import (
 	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 )
 
+// ErrNotQueued is returned when asking for the queue position of a waiter that is not queued
+var ErrNotQueued = errors.New("waiter not queued")
+
+// defaultWaiterTimeout is how long a fair-mode waiter stays queued without a heartbeat
+const defaultWaiterTimeout = 5 * time.Second
+
 type RedisLock struct {
-	client *redis.Client
+	client        redis.UniversalClient
+	fair          bool
+	waiterTimeout time.Duration
 }
 
 func NewRedisLock(addr, password string, db int) *RedisLock {
@@ -20,7 +33,70 @@ func NewRedisLock(addr, password string, db int) *RedisLock {
 	}
 }
 
+// NewRedisLockWithClient creates a Redis lock on an existing client, which may
+// be a standalone, Sentinel-backed (failover) or Cluster client
+func NewRedisLockWithClient(client redis.UniversalClient) *RedisLock {
+	return &RedisLock{client: client}
+}
+
+// SetFair switches the lock into fair (FIFO) mode. Waiters join a queue stored
+// next to the lock key and the lock is only granted to the head of the queue.
+// Every acquire attempt doubles as a heartbeat; a waiter that has not tried
+// again within waiterTimeout is considered dead and evicted from the queue.
+func (r *RedisLock) SetFair(waiterTimeout time.Duration) {
+	if waiterTimeout <= 0 {
+		waiterTimeout = defaultWaiterTimeout
+	}
+	r.fair = true
+	r.waiterTimeout = waiterTimeout
+}
+
+// fairAcquireScript grants the lock to the head of the waiting queue. Time
+// comes from the Redis clock, so waiter expiry does not depend on the
+// replicas' clocks (scripts may call TIME under effect replication, Redis 5+).
+// KEYS[1] lock key, KEYS[2] queue list, KEYS[3] heartbeat zset
+// ARGV[1] waiter value, ARGV[2] lock ttl ms, ARGV[3] waiter timeout ms
+var fairAcquireScript = redis.NewScript(`
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local dead = redis.call('ZRANGEBYSCORE', KEYS[3], '-inf', now)
+for _, w in ipairs(dead) do
+	redis.call('LREM', KEYS[2], 0, w)
+	redis.call('ZREM', KEYS[3], w)
+end
+
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	local head = redis.call('LINDEX', KEYS[2], 0)
+	if not head or head == ARGV[1] then
+		redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+		redis.call('LREM', KEYS[2], 0, ARGV[1])
+		redis.call('ZREM', KEYS[3], ARGV[1])
+		return 1
+	end
+end
+
+if not redis.call('ZSCORE', KEYS[3], ARGV[1]) then
+	redis.call('RPUSH', KEYS[2], ARGV[1])
+end
+redis.call('ZADD', KEYS[3], now + tonumber(ARGV[3]), ARGV[1])
+redis.call('PEXPIRE', KEYS[2], ARGV[3])
+redis.call('PEXPIRE', KEYS[3], ARGV[3])
+return 0
+`)
+
+// leaveQueueScript removes a waiter from the queue.
+// KEYS[1] queue list, KEYS[2] heartbeat zset
+// ARGV[1] waiter value
+var leaveQueueScript = redis.NewScript(`
+redis.call('LREM', KEYS[1], 0, ARGV[1])
+redis.call('ZREM', KEYS[2], ARGV[1])
+return 1
+`)
+
 func (r *RedisLock) AcquireLock(ctx context.Context, lockInfo *DistributedLockInfo) (bool, error) {
+	if r.fair {
+		return r.acquireFair(ctx, lockInfo)
+	}
 	result, err := r.client.SetNX(ctx, lockInfo.key, lockInfo.value, lockInfo.expiration).Result()
 	if err != nil {
 		return false, err
@@ -28,6 +104,42 @@ func (r *RedisLock) AcquireLock(ctx context.Context, lockInfo *DistributedLockIn
 	return result, nil
 }
 
+func (r *RedisLock) acquireFair(ctx context.Context, lockInfo *DistributedLockInfo) (bool, error) {
+	keys := []string{lockInfo.key, queueKey(lockInfo.key), heartbeatKey(lockInfo.key)}
+	result, err := fairAcquireScript.Run(ctx, r.client, keys,
+		lockInfo.value,
+		lockInfo.expiration.Milliseconds(),
+		r.waiterTimeout.Milliseconds(),
+	).Int()
+	if err != nil {
+		return false, err
+	}
+	return result == 1, nil
+}
+
+// QueuePosition returns the 0-based position of the waiter in the fair queue.
+// ErrNotQueued is returned if the waiter is not (or no longer) queued.
+func (r *RedisLock) QueuePosition(ctx context.Context, lockInfo *DistributedLockInfo) (int64, error) {
+	pos, err := r.client.LPos(ctx, queueKey(lockInfo.key), lockInfo.value, redis.LPosArgs{}).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return 0, ErrNotQueued
+		}
+		return 0, err
+	}
+	return pos, nil
+}
+
+// CancelWait removes the waiter from the fair queue so it no longer blocks
+// the waiters behind it. It is a no-op when fair mode is disabled.
+func (r *RedisLock) CancelWait(ctx context.Context, lockInfo *DistributedLockInfo) error {
+	if !r.fair {
+		return nil
+	}
+	keys := []string{queueKey(lockInfo.key), heartbeatKey(lockInfo.key)}
+	return leaveQueueScript.Run(ctx, r.client, keys, lockInfo.value).Err()
+}
+
 func (r *RedisLock) ReleaseLock(ctx context.Context, lockInfo *DistributedLockInfo) (bool, error) {
 	val, err := r.client.Get(ctx, lockInfo.key).Result()
 	if err != nil {
@@ -55,6 +167,98 @@ func (r *RedisLock) RenewLock(ctx context.Context, lockInfo *DistributedLockInfo
 	return nil
 }
 
+// NewLock returns a lock handle bound to this Redis instance
+func (r *RedisLock) NewLock(key string, opts ...LockOption) Locker {
+	return newLockHandle(r, key, opts...)
+}
+
 func (r *RedisLock) BuildServiceType() string {
 	return "redis"
 }
+
+// Close closes the Redis client
+func (r *RedisLock) Close() error {
+	return r.client.Close()
+}
+
+// queueKey returns the key of the fair-mode waiting queue for a lock
+func queueKey(key string) string {
+	return slotKey(key, "queue")
+}
+
+// heartbeatKey returns the key of the fair-mode waiter heartbeats for a lock
+func heartbeatKey(key string) string {
+	return slotKey(key, "waiters")
+}
+
+// slotKey derives a helper key that hashes to the same Redis Cluster slot as
+// key, so a script touching both stays on one node. A key that already has a
+// hash tag keeps it; otherwise the whole key becomes the tag. A key that
+// cannot be used as a tag because it contains "}" gets a tag of its slot.
+func slotKey(key, suffix string) string {
+	if hasHashTag(key) {
+		return key + ":" + suffix
+	}
+	if !strings.Contains(key, "}") {
+		return "{" + key + "}:" + suffix
+	}
+	return "{" + slotTag(hashSlot(key)) + "}" + key + ":" + suffix
+}
+
+// hasHashTag reports whether key contains a non-empty {...} hash tag
+func hasHashTag(key string) bool {
+	start := strings.IndexByte(key, '{')
+	if start < 0 {
+		return false
+	}
+	return strings.IndexByte(key[start+1:], '}') > 0
+}
+
+// redisSlots is the number of Redis Cluster hash slots
+const redisSlots = 16384
+
+// hashSlot returns the Redis Cluster slot of key, honouring hash tags
+func hashSlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key)) % redisSlots
+}
+
+// crc16 is the CRC16-XMODEM checksum Redis Cluster uses for key slots
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+var (
+	slotTagsOnce sync.Once
+	slotTags     []string
+)
+
+// slotTag returns a short hash tag that maps to slot
+func slotTag(slot int) string {
+	slotTagsOnce.Do(func() {
+		slotTags = make([]string, redisSlots)
+		for i, found := 0, 0; found < redisSlots; i++ {
+			tag := strconv.Itoa(i)
+			if s := hashSlot(tag); slotTags[s] == "" {
+				slotTags[s] = tag
+				found++
+			}
+		}
+	})
+	return slotTags[slot]
+}
@@ -0,0 +1,303 @@
+package distributedlock
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+	"github.com/go-redis/redis/v8"
+)
+
+func newTestRedisLock(t *testing.T) (*RedisLock, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	lock := NewRedisLock(mr.Addr(), "", 0)
+	t.Cleanup(func() { lock.client.Close() })
+	return lock, mr
+}
+
+// TestRedisLockFairOrder tests that waiters are granted the lock in arrival order
+func TestRedisLockFairOrder(t *testing.T) {
+	ctx := context.Background()
+	lock, _ := newTestRedisLock(t)
+	lock.SetFair(time.Minute)
+
+	holder := NewDistributedLockInfo("fair-key", "holder", 30*time.Second)
+	first := NewDistributedLockInfo("fair-key", "first", 30*time.Second)
+	second := NewDistributedLockInfo("fair-key", "second", 30*time.Second)
+
+	if ok, err := lock.AcquireLock(ctx, holder); err != nil || !ok {
+		t.Fatalf("Expected holder to acquire the lock, got %v, %v", ok, err)
+	}
+	for _, waiter := range []*DistributedLockInfo{first, second} {
+		if ok, err := lock.AcquireLock(ctx, waiter); err != nil || ok {
+			t.Fatalf("Expected %s to be queued, got %v, %v", waiter.value, ok, err)
+		}
+	}
+
+	pos, err := lock.QueuePosition(ctx, second)
+	if err != nil {
+		t.Fatalf("Failed to get queue position: %v", err)
+	}
+	if pos != 1 {
+		t.Errorf("Expected second waiter at position 1, got %d", pos)
+	}
+
+	if ok, err := lock.ReleaseLock(ctx, holder); err != nil || !ok {
+		t.Fatalf("Expected holder to release the lock, got %v, %v", ok, err)
+	}
+
+	// The lock is free, but second is not at the head of the queue
+	if ok, err := lock.AcquireLock(ctx, second); err != nil || ok {
+		t.Fatalf("Expected second waiter to keep waiting, got %v, %v", ok, err)
+	}
+	if ok, err := lock.AcquireLock(ctx, first); err != nil || !ok {
+		t.Fatalf("Expected first waiter to acquire the lock, got %v, %v", ok, err)
+	}
+	if _, err := lock.QueuePosition(ctx, first); err != ErrNotQueued {
+		t.Errorf("Expected ErrNotQueued for the new holder, got %v", err)
+	}
+}
+
+// TestRedisLockFairEvictsDeadWaiters tests that a waiter without heartbeat
+// loses its place once its timeout has passed on the Redis clock
+func TestRedisLockFairEvictsDeadWaiters(t *testing.T) {
+	ctx := context.Background()
+	lock, mr := newTestRedisLock(t)
+	lock.SetFair(time.Minute)
+
+	dead := NewDistributedLockInfo("fair-key", "dead", 30*time.Second)
+	alive := NewDistributedLockInfo("fair-key", "alive", 30*time.Second)
+
+	holder := NewDistributedLockInfo("fair-key", "holder", 30*time.Second)
+	if ok, err := lock.AcquireLock(ctx, holder); err != nil || !ok {
+		t.Fatalf("Expected holder to acquire the lock, got %v, %v", ok, err)
+	}
+	if ok, err := lock.AcquireLock(ctx, dead); err != nil || ok {
+		t.Fatalf("Expected dead waiter to be queued, got %v, %v", ok, err)
+	}
+	if _, err := lock.ReleaseLock(ctx, holder); err != nil {
+		t.Fatalf("Failed to release lock: %v", err)
+	}
+
+	if ok, err := lock.AcquireLock(ctx, alive); err != nil || ok {
+		t.Fatalf("Expected alive waiter to queue behind the dead one, got %v, %v", ok, err)
+	}
+
+	mr.SetTime(time.Now().Add(2 * time.Minute))
+	if ok, err := lock.AcquireLock(ctx, alive); err != nil || !ok {
+		t.Fatalf("Expected alive waiter to acquire after eviction, got %v, %v", ok, err)
+	}
+}
+
+// TestRedisLockCancelWait tests that a waiter leaving the queue unblocks the next one
+func TestRedisLockCancelWait(t *testing.T) {
+	ctx := context.Background()
+	lock, _ := newTestRedisLock(t)
+	lock.SetFair(time.Minute)
+
+	holder := NewDistributedLockInfo("fair-key", "holder", 30*time.Second)
+	quitter := NewDistributedLockInfo("fair-key", "quitter", 30*time.Second)
+	next := NewDistributedLockInfo("fair-key", "next", 30*time.Second)
+
+	lock.AcquireLock(ctx, holder)
+	lock.AcquireLock(ctx, quitter)
+	lock.AcquireLock(ctx, next)
+	lock.ReleaseLock(ctx, holder)
+
+	if err := lock.CancelWait(ctx, quitter); err != nil {
+		t.Fatalf("Failed to cancel wait: %v", err)
+	}
+	if ok, err := lock.AcquireLock(ctx, next); err != nil || !ok {
+		t.Fatalf("Expected next waiter to acquire the lock, got %v, %v", ok, err)
+	}
+}
+
+// TestSlotKey tests that helper keys share the hash slot of the lock key
+func TestSlotKey(t *testing.T) {
+	cases := []struct {
+		key, want string
+	}{
+		{"orders", "{orders}:queue"},
+		{"{tenant}orders", "{tenant}orders:queue"},
+		{"a{b", "{a{b}:queue"},
+	}
+	for _, c := range cases {
+		if got := slotKey(c.key, "queue"); got != c.want {
+			t.Errorf("slotKey(%q) = %q, want %q", c.key, got, c.want)
+		}
+	}
+
+	// Keys that cannot be a hash tag themselves still share their slot
+	for _, key := range []string{"orders", "{tenant}orders", "a{b", "a}b", "orders{}", "}{x}"} {
+		helper := slotKey(key, "queue")
+		if hashSlot(helper) != hashSlot(key) {
+			t.Errorf("slotKey(%q) = %q hashes to slot %d, want %d", key, helper, hashSlot(helper), hashSlot(key))
+		}
+	}
+}
+
+// TestHashSlot tests the slot computation against values known from Redis
+func TestHashSlot(t *testing.T) {
+	cases := map[string]int{"foo": 12182, "bar": 5061, "{user1000}.following": 3443, "{user1000}.followers": 3443}
+	for key, want := range cases {
+		if got := hashSlot(key); got != want {
+			t.Errorf("hashSlot(%q) = %d, want %d", key, got, want)
+		}
+	}
+}
+
+// TestRedisLockClusterMode tests the fair lock through a cluster client
+func TestRedisLockClusterMode(t *testing.T) {
+	ctx := context.Background()
+	node := miniredis.RunT(t)
+
+	svc, err := NewDistributedLock(RedisLockType, RedisConfig{
+		Mode:  RedisCluster,
+		Addrs: []string{node.Addr()},
+		Fair:  true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create cluster lock: %v", err)
+	}
+	lock := svc.(*RedisLock)
+	defer lock.Close()
+
+	if _, ok := lock.client.(*redis.ClusterClient); !ok {
+		t.Fatalf("Expected a cluster client, got %T", lock.client)
+	}
+
+	holder := NewDistributedLockInfo("cluster-key", "holder", 30*time.Second)
+	if ok, err := lock.AcquireLock(ctx, holder); err != nil || !ok {
+		t.Fatalf("Expected to acquire the lock, got %v, %v", ok, err)
+	}
+	if ok, err := lock.ReleaseLock(ctx, holder); err != nil || !ok {
+		t.Fatalf("Expected to release the lock, got %v, %v", ok, err)
+	}
+}
+
+// TestRedisLockSentinelMode tests that the lock follows the primary announced by Sentinel
+func TestRedisLockSentinelMode(t *testing.T) {
+	ctx := context.Background()
+	primary := miniredis.RunT(t)
+	sentinel := newFakeSentinel(t, "mymaster", primary.Addr())
+
+	svc, err := NewDistributedLock(RedisLockType, RedisConfig{
+		Mode:       RedisSentinel,
+		Addrs:      []string{sentinel},
+		MasterName: "mymaster",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create sentinel lock: %v", err)
+	}
+	lock := svc.(*RedisLock)
+	defer lock.Close()
+
+	holder := NewDistributedLockInfo("sentinel-key", "holder", 30*time.Second)
+	if ok, err := lock.AcquireLock(ctx, holder); err != nil || !ok {
+		t.Fatalf("Expected to acquire the lock, got %v, %v", ok, err)
+	}
+	if got, _ := primary.Get("sentinel-key"); got != "holder" {
+		t.Errorf("Expected lock to be written to the primary, got %q", got)
+	}
+}
+
+// TestRedisConfigModeValidation tests that incomplete mode settings are rejected
+func TestRedisConfigModeValidation(t *testing.T) {
+	cases := []RedisConfig{
+		{Mode: RedisSentinel, Addrs: []string{"localhost:26379"}},
+		{Mode: RedisSentinel, MasterName: "mymaster"},
+		{Mode: RedisCluster},
+		{Mode: RedisCluster, Addrs: []string{"localhost:7000"}, DB: 1},
+		{Mode: "ring"},
+	}
+	for _, cfg := range cases {
+		if _, err := NewDistributedLock(RedisLockType, cfg); err == nil {
+			t.Errorf("Expected error for config %+v", cfg)
+		}
+	}
+}
+
+// newFakeSentinel starts a minimal Sentinel that always announces primaryAddr
+func newFakeSentinel(t *testing.T, masterName, primaryAddr string) string {
+	t.Helper()
+	srv, err := server.NewServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start fake sentinel: %v", err)
+	}
+	t.Cleanup(srv.Close)
+
+	host, port, _ := net.SplitHostPort(primaryAddr)
+	srv.Register("PING", func(c *server.Peer, cmd string, args []string) {
+		c.WriteInline("PONG")
+	})
+	srv.Register("SENTINEL", func(c *server.Peer, cmd string, args []string) {
+		switch {
+		case len(args) == 2 && strings.EqualFold(args[0], "get-master-addr-by-name") && args[1] == masterName:
+			c.WriteLen(2)
+			c.WriteBulk(host)
+			c.WriteBulk(port)
+		case len(args) >= 1 && (strings.EqualFold(args[0], "sentinels") || strings.EqualFold(args[0], "slaves") || strings.EqualFold(args[0], "replicas")):
+			c.WriteLen(0)
+		default:
+			c.WriteNull()
+		}
+	})
+	srv.Register("SUBSCRIBE", func(c *server.Peer, cmd string, args []string) {
+		for i, channel := range args {
+			c.WriteLen(3)
+			c.WriteBulk("subscribe")
+			c.WriteBulk(channel)
+			c.WriteInt(i + 1)
+		}
+	})
+	return srv.Addr().String()
+}
+
+// TestRedisConfigTuning tests that TLS and pool settings reach the client
+func TestRedisConfigTuning(t *testing.T) {
+	client, err := newRedisClient(RedisConfig{
+		Addrs:        []string{"redis.internal:6380"},
+		TLS:          TLSConfig{CAFile: writeTestCA(t), ServerName: "redis.internal"},
+		PoolSize:     20,
+		MinIdleConns: 5,
+		DialTimeout:  2 * time.Second,
+		ReadTimeout:  time.Second,
+		WriteTimeout: time.Second,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer client.Close()
+
+	opts := client.(*redis.Client).Options()
+	if opts.TLSConfig == nil || opts.TLSConfig.RootCAs == nil || opts.TLSConfig.ServerName != "redis.internal" {
+		t.Errorf("Expected TLS config with CA and server name, got %+v", opts.TLSConfig)
+	}
+	if opts.PoolSize != 20 || opts.MinIdleConns != 5 {
+		t.Errorf("Expected pool 20/5, got %d/%d", opts.PoolSize, opts.MinIdleConns)
+	}
+	if opts.DialTimeout != 2*time.Second || opts.ReadTimeout != time.Second || opts.WriteTimeout != time.Second {
+		t.Errorf("Expected timeouts to be applied, got %v/%v/%v", opts.DialTimeout, opts.ReadTimeout, opts.WriteTimeout)
+	}
+
+	if _, err := newRedisClient(RedisConfig{TLS: TLSConfig{CertFile: "missing.pem"}}); err == nil {
+		t.Error("Expected error for an unreadable client certificate")
+	}
+}
+
+// TestTLSConfigDisabled tests that TLS stays off unless configured
+func TestTLSConfigDisabled(t *testing.T) {
+	cfg, err := TLSConfig{ServerName: "ignored"}.clientConfig()
+	if err != nil || cfg != nil {
+		t.Errorf("Expected no TLS config, got %v, %v", cfg, err)
+	}
+	cfg, err = TLSConfig{Enabled: true}.clientConfig()
+	if err != nil || cfg == nil || cfg.RootCAs != nil {
+		t.Errorf("Expected TLS with system CAs, got %v, %v", cfg, err)
+	}
+}
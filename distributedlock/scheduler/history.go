@@ -0,0 +1,32 @@
+package scheduler
+
+import "time"
+
+// RunStatus is the outcome of one occurrence on this replica
+type RunStatus string
+
+const (
+	// RunSucceeded means Run returned nil
+	RunSucceeded RunStatus = "succeeded"
+	// RunFailed means Run returned an error or panicked, or the lock could not be taken
+	RunFailed RunStatus = "failed"
+	// RunTimedOut means Run did not finish within the job timeout
+	RunTimedOut RunStatus = "timed-out"
+	// RunTaken means another replica holds the occurrence lock or already ran it
+	RunTaken RunStatus = "taken"
+	// RunMissed means the occurrence was missed and dropped by the missed-run policy
+	RunMissed RunStatus = "missed"
+)
+
+// Run is one history entry of a job. StartedAt and FinishedAt are only set
+// for occurrences this replica ran.
+type Run struct {
+	Job         string
+	ScheduledAt time.Time
+	StartedAt   time.Time
+	FinishedAt  time.Time
+	Status      RunStatus
+	Error       string
+	// CatchUp is set for missed occurrences run by the missed-run policy
+	CatchUp bool
+}
@@ -0,0 +1,413 @@
+// Package scheduler runs cron jobs on every replica while making sure each
+// occurrence of a job runs on only one of them. Every run is guarded by a
+// lock keyed by the job name and the scheduled time. On Redis, etcd and
+// MySQL a finished occurrence is also marked as done for the catch-up
+// horizon, so a replica that wakes up late does not run it again; on other
+// backends only the lock, held until the starting deadline, protects it.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	"gocode_windows/distributedlock"
+)
+
+const (
+	// lockPrefix is put in front of the lock key of every occurrence
+	lockPrefix = "cron/"
+	// defaultStartingDeadline is used for jobs without a StartingDeadline
+	defaultStartingDeadline = time.Minute
+	// defaultCatchUpHorizon is used for jobs without a CatchUpHorizon
+	defaultCatchUpHorizon = 24 * time.Hour
+	// defaultHistoryLimit is how many runs are kept per job by default
+	defaultHistoryLimit = 100
+	// maxCatchUp bounds the occurrences handled after one wakeup, e.g. for
+	// an every-second job after the host slept for a day
+	maxCatchUp = 1000
+)
+
+var (
+	// ErrJobExists is returned when a job name is already registered
+	ErrJobExists = errors.New("job already registered")
+	// ErrJobNotFound is returned for unknown job names
+	ErrJobNotFound = errors.New("job not found")
+	// ErrUnsupportedService is returned for services that do not hand out lock handles
+	ErrUnsupportedService = errors.New("service does not implement LockerFactory")
+)
+
+// MissedRunPolicy decides what happens to occurrences that were not started
+// within their StartingDeadline, e.g. because the previous run overran or
+// the process was paused
+type MissedRunPolicy string
+
+const (
+	// MissedRunSkip drops missed occurrences
+	MissedRunSkip MissedRunPolicy = "skip"
+	// MissedRunLatest runs the most recent missed occurrence and drops the rest
+	MissedRunLatest MissedRunPolicy = "run-latest"
+	// MissedRunAll runs every missed occurrence in order
+	MissedRunAll MissedRunPolicy = "run-all"
+)
+
+// Job is a function run on a cron schedule
+type Job struct {
+	Name string
+	// Schedule is a standard five-field cron expression or a descriptor such
+	// as @hourly or @every 5m; CRON_TZ= selects a time zone
+	Schedule string
+	Run      func(ctx context.Context) error
+	// Timeout cancels the context passed to Run (0 = no timeout)
+	Timeout time.Duration
+	// StartingDeadline is how late a run may start before it counts as
+	// missed; 0 means one minute. The occurrence lock is held at least until
+	// the deadline, so replicas whose clocks differ by less than it cannot
+	// run the same occurrence twice.
+	StartingDeadline time.Duration
+	// MissedRuns is the missed-run policy; empty means MissedRunSkip
+	MissedRuns MissedRunPolicy
+	// CatchUpHorizon is how old a missed occurrence may be and still be run
+	// by the missed-run policy; older ones count as missed. 0 means 24 hours.
+	// Occurrences are marked as done for the horizon plus StartingDeadline.
+	CatchUpHorizon time.Duration
+}
+
+// Scheduler runs registered jobs, taking the occurrence lock on the service
+// before each run. Runs of one job never overlap on a replica.
+type Scheduler struct {
+	service      distributedlock.LockerFactory
+	markers      *distributedlock.MarkerStore
+	location     *time.Location
+	historyLimit int
+
+	mu      sync.Mutex
+	jobs    map[string]*job
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	started bool
+}
+
+// job is a registered job with its parsed schedule and history
+type job struct {
+	Job
+	schedule cron.Schedule
+	history  []Run
+}
+
+// New creates a scheduler whose locks live on service
+func New(service distributedlock.DistributedLockService) (*Scheduler, error) {
+	factory, ok := service.(distributedlock.LockerFactory)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedService, service.BuildServiceType())
+	}
+	markers, err := distributedlock.NewMarkerStore(service)
+	if err != nil && !errors.Is(err, distributedlock.ErrMarkerUnsupported) {
+		return nil, err
+	}
+	return &Scheduler{
+		service:      factory,
+		markers:      markers,
+		location:     time.Local,
+		historyLimit: defaultHistoryLimit,
+		jobs:         make(map[string]*job),
+	}, nil
+}
+
+// SetLocation sets the time zone of schedules without CRON_TZ
+func (s *Scheduler) SetLocation(location *time.Location) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.location = location
+}
+
+// SetHistoryLimit sets how many runs are kept per job
+func (s *Scheduler) SetHistoryLimit(limit int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.historyLimit = limit
+}
+
+// Add registers a job. Jobs added after Start are scheduled right away.
+func (s *Scheduler) Add(j Job) error {
+	schedule, err := cron.ParseStandard(j.Schedule)
+	if err != nil {
+		return fmt.Errorf("job %q: invalid schedule: %w", j.Name, err)
+	}
+	return s.add(j, schedule)
+}
+
+func (s *Scheduler) add(j Job, schedule cron.Schedule) error {
+	if j.Name == "" {
+		return errors.New("job name must not be empty")
+	}
+	if j.Run == nil {
+		return fmt.Errorf("job %q: Run must not be nil", j.Name)
+	}
+	switch j.MissedRuns {
+	case "":
+		j.MissedRuns = MissedRunSkip
+	case MissedRunSkip, MissedRunLatest, MissedRunAll:
+	default:
+		return fmt.Errorf("job %q: unsupported missed-run policy: %s", j.Name, j.MissedRuns)
+	}
+	if j.StartingDeadline <= 0 {
+		j.StartingDeadline = defaultStartingDeadline
+	}
+	if j.CatchUpHorizon <= 0 {
+		j.CatchUpHorizon = defaultCatchUpHorizon
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.jobs[j.Name]; exists {
+		return fmt.Errorf("%w: %s", ErrJobExists, j.Name)
+	}
+	registered := &job{Job: j, schedule: schedule}
+	s.jobs[j.Name] = registered
+	if s.started {
+		s.startJob(registered)
+	}
+	return nil
+}
+
+// Start schedules all jobs until ctx is done or Stop is called. Occurrences
+// before Start are not caught up.
+func (s *Scheduler) Start(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return errors.New("scheduler already started")
+	}
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.started = true
+	for _, j := range s.jobs {
+		s.startJob(j)
+	}
+	return nil
+}
+
+// Stop cancels running jobs and waits for them and their lock releases.
+// Occurrence locks still held for their StartingDeadline are released early.
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	if !s.started {
+		s.mu.Unlock()
+		return
+	}
+	s.cancel()
+	s.started = false
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// History returns the recorded runs of a job, oldest first
+func (s *Scheduler) History(name string) ([]Run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrJobNotFound, name)
+	}
+	return append([]Run(nil), j.history...), nil
+}
+
+// startJob runs the loop of j; s.mu must be held
+func (s *Scheduler) startJob(j *job) {
+	s.wg.Add(1)
+	go func(ctx context.Context) {
+		defer s.wg.Done()
+		s.loop(ctx, j)
+	}(s.ctx)
+}
+
+// loop sleeps until the next occurrence of j and handles every occurrence
+// that is due on wakeup, applying the missed-run policy to the late ones
+func (s *Scheduler) loop(ctx context.Context, j *job) {
+	last := time.Now()
+	for {
+		next := j.schedule.Next(last.In(s.currentLocation()))
+		if next.IsZero() {
+			return
+		}
+		if !sleepUntil(ctx, next) {
+			return
+		}
+
+		now := time.Now()
+		due := []time.Time{next}
+		for len(due) < maxCatchUp {
+			t := j.schedule.Next(due[len(due)-1])
+			if t.IsZero() || t.After(now) {
+				break
+			}
+			due = append(due, t)
+		}
+		last = due[len(due)-1]
+
+		for _, occ := range planRuns(due, now, j.StartingDeadline, j.CatchUpHorizon, j.MissedRuns) {
+			if ctx.Err() != nil {
+				return
+			}
+			if occ.skip {
+				s.record(j, Run{Job: j.Name, ScheduledAt: occ.at, Status: RunMissed})
+				continue
+			}
+			s.runOccurrence(ctx, j, occ)
+		}
+	}
+}
+
+// sleepUntil waits until t and reports false if ctx was done first
+func sleepUntil(ctx context.Context, t time.Time) bool {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// occurrence is one scheduled time of a job as planned by planRuns
+type occurrence struct {
+	at      time.Time
+	catchUp bool
+	skip    bool
+}
+
+// planRuns decides, at now, what to do with the due occurrences: those
+// within deadline run, the missed ones follow policy unless they are older
+// than horizon
+func planRuns(due []time.Time, now time.Time, deadline, horizon time.Duration, policy MissedRunPolicy) []occurrence {
+	var latestMissed = -1
+	for i, at := range due {
+		if now.Sub(at) > deadline {
+			latestMissed = i
+		}
+	}
+
+	plan := make([]occurrence, 0, len(due))
+	for i, at := range due {
+		switch {
+		case i > latestMissed:
+			plan = append(plan, occurrence{at: at})
+		case now.Sub(at) > horizon:
+			plan = append(plan, occurrence{at: at, skip: true})
+		case policy == MissedRunAll || policy == MissedRunLatest && i == latestMissed:
+			plan = append(plan, occurrence{at: at, catchUp: true})
+		default:
+			plan = append(plan, occurrence{at: at, skip: true})
+		}
+	}
+	return plan
+}
+
+// runOccurrence runs one occurrence if this replica gets its lock and the
+// occurrence is not marked as done. The lock is held until the run finishes
+// and at least until the starting deadline of the occurrence; the marker,
+// written before the lock is released, covers replicas that catch up later.
+func (s *Scheduler) runOccurrence(ctx context.Context, j *job, occ occurrence) {
+	run := Run{Job: j.Name, ScheduledAt: occ.at, CatchUp: occ.catchUp}
+
+	key := fmt.Sprintf("%s%s/%d", lockPrefix, j.Name, occ.at.UnixMilli())
+	lock := s.service.NewLock(key, distributedlock.WithRetry(1, 0))
+	acquired, err := lock.Lock(ctx)
+	if err != nil {
+		run.Status, run.Error = RunFailed, fmt.Sprintf("acquire lock: %v", err)
+		s.record(j, run)
+		return
+	}
+	if !acquired {
+		run.Status = RunTaken
+		s.record(j, run)
+		return
+	}
+	defer func() {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.holdLock(ctx, lock, occ.at.Add(j.StartingDeadline))
+		}()
+	}()
+
+	if s.markers != nil {
+		done, err := s.markers.Marked(ctx, key)
+		if err != nil {
+			run.Status, run.Error = RunFailed, fmt.Sprintf("check marker: %v", err)
+			s.record(j, run)
+			return
+		}
+		if done {
+			run.Status = RunTaken
+			s.record(j, run)
+			return
+		}
+	}
+
+	run.StartedAt = time.Now()
+	err = s.call(ctx, j)
+	run.FinishedAt = time.Now()
+	switch {
+	case err == nil:
+		run.Status = RunSucceeded
+	case errors.Is(err, context.DeadlineExceeded) && j.Timeout > 0:
+		run.Status, run.Error = RunTimedOut, err.Error()
+	default:
+		run.Status, run.Error = RunFailed, err.Error()
+	}
+	s.record(j, run)
+
+	if s.markers != nil {
+		ttl := j.CatchUpHorizon + j.StartingDeadline
+		if err := s.markers.Mark(context.WithoutCancel(ctx), key, ttl); err != nil {
+			log.Printf("Scheduler: failed to mark %s as done: %v\n", key, err)
+		}
+	}
+}
+
+// call runs the job with its timeout, turning a panic into an error
+func (s *Scheduler) call(ctx context.Context, j *job) (err error) {
+	if j.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, j.Timeout)
+		defer cancel()
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return j.Run(ctx)
+}
+
+// holdLock releases lock at until, or right away when ctx is done
+func (s *Scheduler) holdLock(ctx context.Context, lock distributedlock.Locker, until time.Time) {
+	sleepUntil(ctx, until)
+	if err := lock.Unlock(context.WithoutCancel(ctx)); err != nil {
+		log.Printf("Scheduler: failed to release %s: %v\n", lock.Key(), err)
+	}
+}
+
+// record appends run to the history of j, dropping the oldest beyond the limit
+func (s *Scheduler) record(j *job, run Run) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j.history = append(j.history, run)
+	if s.historyLimit > 0 && len(j.history) > s.historyLimit {
+		j.history = append(j.history[:0], j.history[len(j.history)-s.historyLimit:]...)
+	}
+}
+
+func (s *Scheduler) currentLocation() *time.Location {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.location
+}
@@ -0,0 +1,251 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"gocode_windows/distributedlock"
+)
+
+// everySchedule fires on multiples of d, for schedules below cron's one-second resolution
+type everySchedule struct {
+	d time.Duration
+}
+
+func (e everySchedule) Next(t time.Time) time.Time {
+	return t.Truncate(e.d).Add(e.d)
+}
+
+// newTestScheduler returns a scheduler on its own Redis client, like one replica
+func newTestScheduler(t *testing.T, mr *miniredis.Miniredis) *Scheduler {
+	t.Helper()
+	s, err := New(distributedlock.NewRedisLock(mr.Addr(), "", 0))
+	if err != nil {
+		t.Fatalf("Failed to create scheduler: %v", err)
+	}
+	t.Cleanup(s.Stop)
+	return s
+}
+
+// TestSchedulerRunsEachOccurrenceOnce tests that replicas split the occurrences without running any twice
+func TestSchedulerRunsEachOccurrenceOnce(t *testing.T) {
+	mr := miniredis.RunT(t)
+
+	var mu sync.Mutex
+	runs := make(map[time.Time]int)
+	for i := 0; i < 3; i++ {
+		s := newTestScheduler(t, mr)
+		err := s.add(Job{
+			Name: "report",
+			Run: func(ctx context.Context) error {
+				mu.Lock()
+				defer mu.Unlock()
+				runs[time.Now().Truncate(50*time.Millisecond)]++
+				return nil
+			},
+		}, everySchedule{50 * time.Millisecond})
+		if err != nil {
+			t.Fatalf("Failed to add job: %v", err)
+		}
+		s.Start(context.Background())
+	}
+	time.Sleep(280 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(runs) < 4 {
+		t.Errorf("Expected at least 4 occurrences to run, got %d", len(runs))
+	}
+	for at, n := range runs {
+		if n != 1 {
+			t.Errorf("Expected occurrence %v to run once, ran %d times", at, n)
+		}
+	}
+}
+
+// TestSchedulerTimeoutAndHistory tests that the job timeout cancels Run and is recorded
+func TestSchedulerTimeoutAndHistory(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s := newTestScheduler(t, mr)
+	s.add(Job{
+		Name:    "slow",
+		Timeout: 20 * time.Millisecond,
+		Run: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	}, everySchedule{100 * time.Millisecond})
+	s.add(Job{
+		Name: "broken",
+		Run: func(ctx context.Context) error {
+			panic("boom")
+		},
+	}, everySchedule{100 * time.Millisecond})
+	s.Start(context.Background())
+	time.Sleep(150 * time.Millisecond)
+
+	history, err := s.History("slow")
+	if err != nil || len(history) == 0 {
+		t.Fatalf("Expected history for slow, got %v, %v", history, err)
+	}
+	if history[0].Status != RunTimedOut || history[0].StartedAt.IsZero() {
+		t.Errorf("Expected timed-out run, got %+v", history[0])
+	}
+
+	history, _ = s.History("broken")
+	if len(history) == 0 || history[0].Status != RunFailed || history[0].Error != "panic: boom" {
+		t.Errorf("Expected failed run from the panic, got %+v", history)
+	}
+
+	if _, err := s.History("unknown"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Expected ErrJobNotFound, got %v", err)
+	}
+}
+
+// TestSchedulerTakenByOtherReplica tests that an occurrence locked elsewhere is recorded as taken
+func TestSchedulerTakenByOtherReplica(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s := newTestScheduler(t, mr)
+	s.SetHistoryLimit(1)
+
+	ran := make(chan struct{}, 10)
+	s.add(Job{Name: "sync", Run: func(ctx context.Context) error {
+		ran <- struct{}{}
+		return nil
+	}}, everySchedule{time.Second})
+
+	// Another replica already holds the next occurrence
+	next := time.Now().Truncate(time.Second).Add(time.Second)
+	other := distributedlock.NewRedisLock(mr.Addr(), "", 0).NewLock(fmt.Sprintf("cron/sync/%d", next.UnixMilli()))
+	if acquired, err := other.Lock(context.Background()); err != nil || !acquired {
+		t.Fatalf("Failed to acquire: %v, %v", acquired, err)
+	}
+	defer other.Unlock(context.Background())
+
+	s.Start(context.Background())
+	time.Sleep(time.Until(next) + 50*time.Millisecond)
+
+	select {
+	case <-ran:
+		t.Error("Expected the job not to run")
+	default:
+	}
+	history, _ := s.History("sync")
+	if len(history) != 1 || history[0].Status != RunTaken {
+		t.Errorf("Expected one taken run, got %+v", history)
+	}
+}
+
+// TestPlanRuns tests the missed-run policies
+func TestPlanRuns(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 30, 0, time.UTC)
+	due := []time.Time{
+		now.Add(-3 * time.Minute),
+		now.Add(-2 * time.Minute),
+		now.Add(-time.Minute),
+		now.Add(-30 * time.Second),
+	}
+
+	tests := []struct {
+		policy  MissedRunPolicy
+		skip    []bool
+		catchUp []bool
+	}{
+		{MissedRunSkip, []bool{true, true, true, false}, []bool{false, false, false, false}},
+		{MissedRunLatest, []bool{true, true, false, false}, []bool{false, false, true, false}},
+		{MissedRunAll, []bool{false, false, false, false}, []bool{true, true, true, false}},
+	}
+	for _, tt := range tests {
+		plan := planRuns(due, now, 45*time.Second, time.Hour, tt.policy)
+		for i, occ := range plan {
+			if occ.skip != tt.skip[i] || occ.catchUp != tt.catchUp[i] {
+				t.Errorf("%s: expected occurrence %d skip=%v catchUp=%v, got %+v", tt.policy, i, tt.skip[i], tt.catchUp[i], occ)
+			}
+		}
+	}
+}
+
+// TestPlanRunsHorizon tests that missed occurrences beyond the catch-up horizon are skipped
+func TestPlanRunsHorizon(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 30, 0, time.UTC)
+	due := []time.Time{now.Add(-3 * time.Hour), now.Add(-time.Hour), now.Add(-10 * time.Second)}
+
+	plan := planRuns(due, now, time.Minute, 2*time.Hour, MissedRunAll)
+	if !plan[0].skip || plan[1].skip || !plan[1].catchUp || plan[2].skip || plan[2].catchUp {
+		t.Errorf("Expected only the occurrence beyond the horizon to be skipped, got %+v", plan)
+	}
+}
+
+// TestSchedulerLateReplicaSkipsDoneOccurrence tests that a replica catching
+// up after the occurrence lock was released does not run it again
+func TestSchedulerLateReplicaSkipsDoneOccurrence(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+
+	var runs int32
+	var mu sync.Mutex
+	newReplica := func() (*Scheduler, *job) {
+		s := newTestScheduler(t, mr)
+		err := s.add(Job{
+			Name:             "invoice",
+			StartingDeadline: 50 * time.Millisecond,
+			MissedRuns:       MissedRunAll,
+			Run: func(ctx context.Context) error {
+				mu.Lock()
+				defer mu.Unlock()
+				runs++
+				return nil
+			},
+		}, everySchedule{time.Hour})
+		if err != nil {
+			t.Fatalf("Failed to add job: %v", err)
+		}
+		return s, s.jobs["invoice"]
+	}
+	onTime, onTimeJob := newReplica()
+	late, lateJob := newReplica()
+
+	at := time.Now().Truncate(time.Millisecond)
+	onTime.runOccurrence(ctx, onTimeJob, occurrence{at: at})
+	// Wait until the occurrence lock is released at the starting deadline
+	onTime.wg.Wait()
+
+	late.runOccurrence(ctx, lateJob, occurrence{at: at, catchUp: true})
+	late.wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if runs != 1 {
+		t.Errorf("Expected the occurrence to run once, ran %d times", runs)
+	}
+	history, _ := late.History("invoice")
+	if len(history) != 1 || history[0].Status != RunTaken || !history[0].CatchUp {
+		t.Errorf("Expected the late catch-up to be recorded as taken, got %+v", history)
+	}
+}
+
+// TestAddValidation tests that invalid jobs are rejected
+func TestAddValidation(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s := newTestScheduler(t, mr)
+	run := func(ctx context.Context) error { return nil }
+
+	if err := s.Add(Job{Name: "bad", Schedule: "every day", Run: run}); err == nil {
+		t.Error("Expected error for invalid schedule")
+	}
+	if err := s.Add(Job{Name: "policy", Schedule: "@hourly", Run: run, MissedRuns: "retry"}); err == nil {
+		t.Error("Expected error for unsupported policy")
+	}
+	if err := s.Add(Job{Name: "ok", Schedule: "*/5 * * * *", Run: run}); err != nil {
+		t.Errorf("Expected job to be added, got %v", err)
+	}
+	if err := s.Add(Job{Name: "ok", Schedule: "@daily", Run: run}); !errors.Is(err, ErrJobExists) {
+		t.Errorf("Expected ErrJobExists, got %v", err)
+	}
+}
@@ -0,0 +1,339 @@
+package distributedlock
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"gocode_windows/config"
+)
+
+// RegisterFromConfig creates every enabled backend in cfg and registers it.
+// The top-level sections are registered under their type name ("redis",
+// "etcd", ...), named instances under their own name, and namespaces as
+// NamespacedService views of those under their own name. Fault rules must
+// name one of these. If any backend fails, the ones registered by this call
+// are unregistered and closed again.
+func RegisterFromConfig(cfg *config.Config) error {
+	var registered []string
+	register := func(name string, lockType LockType, backendConfig interface{}) error {
+		service, err := NewDistributedLock(lockType, backendConfig)
+		if err != nil {
+			return fmt.Errorf("create %s backend %q: %w", lockType, name, err)
+		}
+		if service, err = wrapFaults(cfg.FaultInjection, name, service); err != nil {
+			return err
+		}
+		if err := RegisterNamedService(name, service); err != nil {
+			closeService(service)
+			return err
+		}
+		registered = append(registered, name)
+		return nil
+	}
+
+	err := registerSections(cfg, register)
+	if err == nil {
+		err = registerNamespaces(cfg, &registered)
+	}
+	if err == nil {
+		err = checkFaultRules(cfg.FaultInjection, registered)
+	}
+	if err != nil {
+		for _, name := range registered {
+			UnregisterService(name)
+		}
+	}
+	return err
+}
+
+func registerSections(cfg *config.Config, register func(string, LockType, interface{}) error) error {
+	if cfg.Redis.Enabled {
+		if err := register(string(RedisLockType), RedisLockType, redisConfigFrom(cfg.Redis)); err != nil {
+			return err
+		}
+	}
+	if cfg.Etcd.Enabled {
+		if err := register(string(EtcdLockType), EtcdLockType, etcdConfigFrom(cfg.Etcd)); err != nil {
+			return err
+		}
+	}
+	if cfg.MySQL.Enabled {
+		if err := register(string(MySQLLockType), MySQLLockType, mysqlConfigFrom(cfg.MySQL)); err != nil {
+			return err
+		}
+	}
+	if cfg.Postgres.Enabled {
+		if err := register(string(PostgresLockType), PostgresLockType, postgresConfigFrom(cfg.Postgres)); err != nil {
+			return err
+		}
+	}
+	if cfg.File.Enabled {
+		if err := register(string(FileLockType), FileLockType, FileConfig{Dir: cfg.File.Dir}); err != nil {
+			return err
+		}
+	}
+	if cfg.Consul.Enabled {
+		if err := register(string(ConsulLockType), ConsulLockType, consulConfigFrom(cfg.Consul)); err != nil {
+			return err
+		}
+	}
+	if cfg.Kubernetes.Enabled {
+		if err := register(string(KubernetesLockType), KubernetesLockType, kubernetesConfigFrom(cfg.Kubernetes)); err != nil {
+			return err
+		}
+	}
+	if cfg.ZooKeeper.Enabled {
+		if err := register(string(ZookeeperLockType), ZookeeperLockType, zookeeperConfigFrom(cfg.ZooKeeper)); err != nil {
+			return err
+		}
+	}
+
+	for _, inst := range cfg.Instances {
+		if inst.Name == "" {
+			return errors.New("instance name must not be empty")
+		}
+		backendConfig, err := instanceConfigFrom(inst)
+		if err != nil {
+			return err
+		}
+		if err := register(inst.Name, LockType(inst.Type), backendConfig); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// registerNamespaces wraps already registered backends in namespaces
+func registerNamespaces(cfg *config.Config, registered *[]string) error {
+	for _, nsCfg := range cfg.Namespaces {
+		if nsCfg.Name == "" {
+			return errors.New("namespace name must not be empty")
+		}
+		backend, err := GetService(nsCfg.Backend)
+		if err != nil {
+			return fmt.Errorf("namespace %q: %w", nsCfg.Name, err)
+		}
+		service, err := NewNamespacedService(backend, Namespace{
+			Prefix:    nsCfg.Prefix,
+			Separator: nsCfg.Separator,
+			MaxLocks:  nsCfg.MaxLocks,
+		})
+		if err != nil {
+			return fmt.Errorf("namespace %q: %w", nsCfg.Name, err)
+		}
+		wrapped, err := wrapFaults(cfg.FaultInjection, nsCfg.Name, service)
+		if err != nil {
+			return err
+		}
+		if err := RegisterNamedService(nsCfg.Name, wrapped); err != nil {
+			return err
+		}
+		*registered = append(*registered, nsCfg.Name)
+	}
+	return nil
+}
+
+// wrapFaults puts a FaultInjector around service if fault injection is
+// enabled and has rules for name
+func wrapFaults(cfg config.FaultInjectionConfig, name string, service DistributedLockService) (DistributedLockService, error) {
+	if !cfg.Enabled {
+		return service, nil
+	}
+	var rules []FaultRule
+	for _, ruleCfg := range cfg.Rules {
+		if ruleCfg.Service != name {
+			continue
+		}
+		rule := FaultRule{
+			Op:          FaultOp(ruleCfg.Op),
+			Kind:        FaultKind(ruleCfg.Kind),
+			Key:         ruleCfg.Key,
+			Probability: ruleCfg.Probability,
+			Calls:       ruleCfg.Calls,
+			Times:       ruleCfg.Times,
+			Latency:     ruleCfg.Latency,
+		}
+		if ruleCfg.Error != "" {
+			rule.Err = fmt.Errorf("%w: %s", ErrInjectedFault, ruleCfg.Error)
+		}
+		rules = append(rules, rule)
+	}
+	if len(rules) == 0 {
+		return service, nil
+	}
+
+	injector, err := NewFaultInjector(service, rules...)
+	if err != nil {
+		closeService(service)
+		return nil, fmt.Errorf("fault injection for %q: %w", name, err)
+	}
+	if cfg.Seed != 0 {
+		injector.SetSeed(cfg.Seed)
+	}
+	return injector, nil
+}
+
+// checkFaultRules fails for rules whose service was not registered, as they
+// would never fire
+func checkFaultRules(cfg config.FaultInjectionConfig, registered []string) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	names := make(map[string]bool, len(registered))
+	for _, name := range registered {
+		names[name] = true
+	}
+	for _, rule := range cfg.Rules {
+		if !names[rule.Service] {
+			return fmt.Errorf("fault rule for unknown service %q", rule.Service)
+		}
+	}
+	return nil
+}
+
+// instanceConfigFrom picks the section of a named instance that matches its type
+func instanceConfigFrom(inst config.InstanceConfig) (interface{}, error) {
+	switch LockType(inst.Type) {
+	case RedisLockType:
+		return redisConfigFrom(inst.Redis), nil
+	case EtcdLockType:
+		return etcdConfigFrom(inst.Etcd), nil
+	case MySQLLockType, MySQLLeaseLockType:
+		return mysqlConfigFrom(inst.MySQL), nil
+	case PostgresLockType:
+		return postgresConfigFrom(inst.Postgres), nil
+	case FileLockType:
+		return FileConfig{Dir: inst.File.Dir}, nil
+	case ConsulLockType:
+		return consulConfigFrom(inst.Consul), nil
+	case KubernetesLockType:
+		return kubernetesConfigFrom(inst.Kubernetes), nil
+	case ZookeeperLockType:
+		return zookeeperConfigFrom(inst.ZooKeeper), nil
+	default:
+		return nil, fmt.Errorf("instance %q: unsupported lock type: %s", inst.Name, inst.Type)
+	}
+}
+
+func redisConfigFrom(cfg config.RedisConfig) RedisConfig {
+	return RedisConfig{
+		Mode:             cfg.Mode,
+		Addrs:            cfg.Addrs,
+		Password:         cfg.Password.Value(),
+		DB:               cfg.DB,
+		MasterName:       cfg.MasterName,
+		SentinelPassword: cfg.SentinelPassword.Value(),
+		Fair:             cfg.Fair,
+		WaiterTimeout:    cfg.WaiterTimeout,
+		TLS:              tlsConfigFrom(cfg.TLS),
+		PoolSize:         cfg.PoolSize,
+		MinIdleConns:     cfg.MinIdleConns,
+		DialTimeout:      cfg.DialTimeout,
+		ReadTimeout:      cfg.ReadTimeout,
+		WriteTimeout:     cfg.WriteTimeout,
+	}
+}
+
+func tlsConfigFrom(cfg config.TLSConfig) TLSConfig {
+	return TLSConfig{
+		Enabled:            cfg.Enabled,
+		CAFile:             cfg.CAFile,
+		CertFile:           cfg.CertFile,
+		KeyFile:            cfg.KeyFile,
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+}
+
+func etcdConfigFrom(cfg config.EtcdConfig) EtcdConfig {
+	return EtcdConfig{
+		Endpoints:          cfg.Endpoints,
+		Username:           cfg.Username,
+		Password:           cfg.Password.Value(),
+		DialTimeout:        cfg.DialTimeout,
+		CertFile:           cfg.CertFile,
+		KeyFile:            cfg.KeyFile,
+		CAFile:             cfg.CAFile,
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+		KeepAliveTime:      cfg.KeepAliveTime,
+		KeepAliveTimeout:   cfg.KeepAliveTimeout,
+		AutoSyncInterval:   cfg.AutoSyncInterval,
+	}
+}
+
+func mysqlConfigFrom(cfg config.MySQLConfig) MySQLConfig {
+	return MySQLConfig{
+		User:            cfg.Username,
+		Password:        cfg.Password.Value(),
+		Host:            cfg.Host,
+		Port:            cfg.Port,
+		DBName:          cfg.DBName,
+		WaitTimeout:     cfg.WaitTimeout,
+		LeaseTable:      cfg.LeaseTable,
+		GCInterval:      cfg.GCInterval,
+		TLS:             tlsConfigFrom(cfg.TLS),
+		Params:          cfg.Params,
+		PoolSize:        cfg.PoolSize,
+		MaxIdleConns:    cfg.MaxIdleConns,
+		ConnMaxLifetime: cfg.ConnMaxLifetime,
+		DialTimeout:     cfg.DialTimeout,
+		ReadTimeout:     cfg.ReadTimeout,
+		WriteTimeout:    cfg.WriteTimeout,
+	}
+}
+
+func postgresConfigFrom(cfg config.PostgresConfig) PostgresConfig {
+	return PostgresConfig{
+		User:              cfg.Username,
+		Password:          cfg.Password.Value(),
+		Host:              cfg.Host,
+		Port:              cfg.Port,
+		DBName:            cfg.DBName,
+		SSLMode:           cfg.SSLMode,
+		TransactionScoped: cfg.TransactionScoped,
+	}
+}
+
+func consulConfigFrom(cfg config.ConsulConfig) ConsulConfig {
+	return ConsulConfig{
+		Address:    cfg.Address,
+		Scheme:     cfg.Scheme,
+		Token:      cfg.Token.Value(),
+		Datacenter: cfg.Datacenter,
+		Prefix:     cfg.Prefix,
+		LockDelay:  cfg.LockDelay,
+	}
+}
+
+func kubernetesConfigFrom(cfg config.KubernetesConfig) KubernetesConfig {
+	return KubernetesConfig{
+		Namespace:  cfg.Namespace,
+		Kubeconfig: cfg.Kubeconfig,
+	}
+}
+
+func zookeeperConfigFrom(cfg config.ZooKeeperConfig) ZooKeeperConfig {
+	acl := make([]ZooKeeperACLEntry, 0, len(cfg.ACL))
+	for _, entry := range cfg.ACL {
+		acl = append(acl, ZooKeeperACLEntry{Scheme: entry.Scheme, ID: entry.ID, Perms: entry.Perms})
+	}
+	return ZooKeeperConfig{
+		Servers:        cfg.Servers,
+		SessionTimeout: cfg.SessionTimeout,
+		Prefix:         cfg.Prefix,
+		WaitTimeout:    cfg.WaitTimeout,
+		Username:       cfg.Username,
+		Password:       cfg.Password.Value(),
+		ACL:            acl,
+		Chroot:         cfg.Chroot,
+	}
+}
+
+// closeService releases the resources of a service that never got registered
+func closeService(service DistributedLockService) {
+	if closer, ok := service.(io.Closer); ok {
+		closer.Close()
+	}
+}
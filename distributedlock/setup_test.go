@@ -0,0 +1,167 @@
+package distributedlock
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"gocode_windows/config"
+)
+
+// TestRegisterFromConfigNamedInstances tests registering two Redis instances by name
+func TestRegisterFromConfigNamedInstances(t *testing.T) {
+	payments := miniredis.RunT(t)
+	search := miniredis.RunT(t)
+
+	cfg := &config.Config{
+		Instances: []config.InstanceConfig{
+			{Name: "redis-payments", Type: "redis", Redis: config.RedisConfig{Addrs: []string{payments.Addr()}}},
+			{Name: "redis-search", Type: "redis", Redis: config.RedisConfig{Addrs: []string{search.Addr()}}},
+		},
+	}
+	if err := RegisterFromConfig(cfg); err != nil {
+		t.Fatalf("Failed to register from config: %v", err)
+	}
+	defer UnregisterService("redis-payments")
+	defer UnregisterService("redis-search")
+
+	for _, name := range []string{"redis-payments", "redis-search"} {
+		if _, err := GetService(name); err != nil {
+			t.Errorf("Expected %s to be registered: %v", name, err)
+		}
+	}
+}
+
+// TestRegisterFromConfigRollsBack tests that a failing instance unregisters the earlier ones
+func TestRegisterFromConfigRollsBack(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	cfg := &config.Config{
+		Instances: []config.InstanceConfig{
+			{Name: "redis-ok", Type: "redis", Redis: config.RedisConfig{Addrs: []string{server.Addr()}}},
+			{Name: "broken", Type: "unknown"},
+		},
+	}
+	if err := RegisterFromConfig(cfg); err == nil {
+		t.Fatal("Expected error for unsupported instance type")
+	}
+	if _, err := GetService("redis-ok"); err == nil {
+		t.Error("Expected redis-ok to be unregistered after failure")
+	}
+}
+
+// TestRegisterFromConfigNamespaces tests registering a namespace over a named instance
+func TestRegisterFromConfigNamespaces(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	cfg := &config.Config{
+		Instances: []config.InstanceConfig{
+			{Name: "redis-shared", Type: "redis", Redis: config.RedisConfig{Addrs: []string{server.Addr()}}},
+		},
+		Namespaces: []config.NamespaceConfig{
+			{Name: "billing", Backend: "redis-shared", Prefix: "billing", MaxLocks: 10},
+		},
+	}
+	if err := RegisterFromConfig(cfg); err != nil {
+		t.Fatalf("Failed to register from config: %v", err)
+	}
+	defer UnregisterService("redis-shared")
+	defer UnregisterService("billing")
+
+	service, err := GetService("billing")
+	if err != nil {
+		t.Fatalf("Expected billing to be registered: %v", err)
+	}
+	ns, ok := service.(*NamespacedService)
+	if !ok {
+		t.Fatalf("Expected a NamespacedService, got %T", service)
+	}
+	if got := ns.Key("invoice"); got != "billing/invoice" {
+		t.Errorf("Expected key billing/invoice, got %s", got)
+	}
+
+	// A namespace over a missing backend rolls back everything
+	cfg = &config.Config{
+		Instances: []config.InstanceConfig{
+			{Name: "redis-other", Type: "redis", Redis: config.RedisConfig{Addrs: []string{server.Addr()}}},
+		},
+		Namespaces: []config.NamespaceConfig{{Name: "orphan", Backend: "missing", Prefix: "x"}},
+	}
+	if err := RegisterFromConfig(cfg); err == nil {
+		t.Fatal("Expected error for a namespace over a missing backend")
+	}
+	if _, err := GetService("redis-other"); err == nil {
+		t.Error("Expected redis-other to be unregistered after failure")
+	}
+}
+
+// TestRegisterFromConfigFaultInjection tests that configured rules wrap the matching backend
+func TestRegisterFromConfigFaultInjection(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	cfg := &config.Config{
+		Instances: []config.InstanceConfig{
+			{Name: "redis-staging", Type: "redis", Redis: config.RedisConfig{Addrs: []string{server.Addr()}}},
+			{Name: "redis-clean", Type: "redis", Redis: config.RedisConfig{Addrs: []string{server.Addr()}}},
+		},
+		FaultInjection: config.FaultInjectionConfig{
+			Enabled: true,
+			Rules: []config.FaultRuleConfig{
+				{Service: "redis-staging", Op: "acquire", Kind: "error", Probability: 1},
+			},
+		},
+	}
+	if err := RegisterFromConfig(cfg); err != nil {
+		t.Fatalf("Failed to register from config: %v", err)
+	}
+	defer UnregisterService("redis-staging")
+	defer UnregisterService("redis-clean")
+
+	staging, _ := GetService("redis-staging")
+	if _, ok := staging.(*FaultInjector); !ok {
+		t.Errorf("Expected redis-staging to be wrapped, got %T", staging)
+	}
+	clean, _ := GetService("redis-clean")
+	if _, ok := clean.(*RedisLock); !ok {
+		t.Errorf("Expected redis-clean to be unwrapped, got %T", clean)
+	}
+}
+
+// TestRegisterFromConfigFaultInjectionNamespaces tests rules on a namespace
+// and that rules naming no registered service are rejected
+func TestRegisterFromConfigFaultInjectionNamespaces(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	cfg := &config.Config{
+		Instances: []config.InstanceConfig{
+			{Name: "redis-shared", Type: "redis", Redis: config.RedisConfig{Addrs: []string{server.Addr()}}},
+		},
+		Namespaces: []config.NamespaceConfig{
+			{Name: "billing", Backend: "redis-shared", Prefix: "billing"},
+		},
+		FaultInjection: config.FaultInjectionConfig{
+			Enabled: true,
+			Rules: []config.FaultRuleConfig{
+				{Service: "billing", Op: "acquire", Kind: "error", Probability: 1},
+			},
+		},
+	}
+	if err := RegisterFromConfig(cfg); err != nil {
+		t.Fatalf("Failed to register from config: %v", err)
+	}
+	billing, _ := GetService("billing")
+	UnregisterService("billing")
+	UnregisterService("redis-shared")
+	if _, ok := billing.(*FaultInjector); !ok {
+		t.Errorf("Expected billing to be wrapped, got %T", billing)
+	}
+
+	cfg.FaultInjection.Rules = append(cfg.FaultInjection.Rules,
+		config.FaultRuleConfig{Service: "redis-typo", Kind: "error", Probability: 1})
+	if err := RegisterFromConfig(cfg); err == nil {
+		t.Fatal("Expected error for a rule naming no registered service")
+	}
+	if _, err := GetService("redis-shared"); err == nil {
+		t.Error("Expected redis-shared to be unregistered after failure")
+	}
+}
@@ -0,0 +1,46 @@
+package distributedlock
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ttlStore keeps values next to the locks of a backend until their TTL runs
+// out. It backs the features that publish state to other replicas.
+type ttlStore interface {
+	// get returns the value of key, or nil if there is none
+	get(ctx context.Context, key string) ([]byte, error)
+	put(ctx context.Context, key string, data []byte, ttl time.Duration) error
+}
+
+// ttlWatcher is implemented by stores that can tell waiters when a value
+// was put or the lock guarding it went away, so they need not poll
+type ttlWatcher interface {
+	// watch signals on changed when key is put or the lock lockKey is
+	// released, until stop is called. Signals are coalesced.
+	watch(ctx context.Context, key, lockKey string) (changed <-chan struct{}, stop func(), err error)
+	// notify wakes the watchers of key; it is called after releasing lockKey
+	notify(ctx context.Context, key string) error
+}
+
+// newTTLStore returns the store of the backend under service (see
+// unwrapBackend) and the key prefix of the namespaces in between.
+// unsupported is wrapped into the error for other backends.
+func newTTLStore(service DistributedLockService, unsupported error) (ttlStore, string, error) {
+	backend, prefix := unwrapBackend(service)
+	switch s := backend.(type) {
+	case *RedisLock:
+		return &redisTTLStore{client: s.client}, prefix, nil
+	case *EtcdLock:
+		return &etcdTTLStore{client: s.pool.client}, prefix, nil
+	case *MySQLLock:
+		store, err := newMySQLTTLStore(s.db)
+		return store, prefix, err
+	case *MySQLLeaseLock:
+		store, err := newMySQLTTLStore(s.db)
+		return store, prefix, err
+	default:
+		return nil, "", fmt.Errorf("%w: %s", unsupported, service.BuildServiceType())
+	}
+}
@@ -0,0 +1,74 @@
+package distributedlock
+
+import (
+	"context"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// etcdTTLStore attaches each value to its own lease, so etcd deletes it
+// when the TTL (rounded up to seconds) runs out. Watchers follow the value
+// key and the contender keys of the lock, so a holder that crashes wakes
+// them as soon as its lease is gone.
+type etcdTTLStore struct {
+	client *clientv3.Client
+}
+
+func (s *etcdTTLStore) get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s.client.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, nil
+	}
+	return resp.Kvs[0].Value, nil
+}
+
+func (s *etcdTTLStore) put(ctx context.Context, key string, data []byte, ttl time.Duration) error {
+	lease, err := s.client.Grant(ctx, leaseSeconds(ttl))
+	if err != nil {
+		return err
+	}
+	_, err = s.client.Put(ctx, key, string(data), clientv3.WithLease(lease.ID))
+	return err
+}
+
+func (s *etcdTTLStore) watch(ctx context.Context, key, lockKey string) (<-chan struct{}, func(), error) {
+	ctx, cancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+	resp, err := s.client.Get(ctx, key)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	// Start both watches right after the read, so no change in between is missed
+	rev := clientv3.WithRev(resp.Header.Revision + 1)
+	values := s.client.Watch(ctx, key, rev, clientv3.WithFilterDelete())
+	// EtcdLock contenders hold keys under lockKey/, deleted on release or lease expiry
+	locks := s.client.Watch(ctx, lockKey+"/", clientv3.WithPrefix(), rev, clientv3.WithFilterPut())
+
+	changed := make(chan struct{}, 1)
+	go func() {
+		for {
+			var ok bool
+			select {
+			case _, ok = <-values:
+			case _, ok = <-locks:
+			}
+			if !ok {
+				return
+			}
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return changed, cancel, nil
+}
+
+// notify has nothing to do: the watchers see the release in etcd itself
+func (s *etcdTTLStore) notify(ctx context.Context, key string) error {
+	return nil
+}
@@ -0,0 +1,53 @@
+package distributedlock
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// ttlStoreTable holds the values of every MySQL TTL store
+const ttlStoreTable = "distributed_results"
+
+// mysqlTTLStore keeps each value in one row with its expiry on the database
+// clock. Expired rows are ignored, overwritten by the next put and deleted
+// by gc.
+type mysqlTTLStore struct {
+	db *sql.DB
+	gc *mysqlExpiryGC
+}
+
+// newMySQLTTLStore creates the table if needed
+func newMySQLTTLStore(db *sql.DB) (*mysqlTTLStore, error) {
+	_, err := db.ExecContext(context.Background(), `CREATE TABLE IF NOT EXISTS `+ttlStoreTable+` (
+	name       VARCHAR(255) NOT NULL,
+	value      MEDIUMBLOB   NOT NULL,
+	expires_at DATETIME(3)  NOT NULL,
+	PRIMARY KEY (name),
+	KEY (expires_at)
+) ENGINE=InnoDB`)
+	if err != nil {
+		return nil, err
+	}
+	return &mysqlTTLStore{db: db, gc: newMySQLExpiryGC(db, ttlStoreTable)}, nil
+}
+
+func (s *mysqlTTLStore) get(ctx context.Context, key string) ([]byte, error) {
+	var data []byte
+	err := s.db.QueryRowContext(ctx, `SELECT value FROM `+ttlStoreTable+` WHERE name = ? AND expires_at > NOW(3)`, mysqlRowKey(key)).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (s *mysqlTTLStore) put(ctx context.Context, key string, data []byte, ttl time.Duration) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO `+ttlStoreTable+` (name, value, expires_at)
+VALUES (?, ?, NOW(3) + INTERVAL ? MICROSECOND)
+ON DUPLICATE KEY UPDATE value = VALUES(value), expires_at = VALUES(expires_at)`,
+		mysqlRowKey(key), data, ttl.Microseconds())
+	if err == nil {
+		s.gc.collect(ctx)
+	}
+	return err
+}
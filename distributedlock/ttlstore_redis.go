@@ -0,0 +1,52 @@
+package distributedlock
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// redisTTLStore keeps each value as a string with a PX expiry. Watchers
+// subscribe to a channel named after the key; Redis cannot report the
+// expiry of a crashed holder's lock there, so waiters also need a timeout.
+type redisTTLStore struct {
+	client redis.UniversalClient
+}
+
+func (s *redisTTLStore) get(ctx context.Context, key string) ([]byte, error) {
+	data, err := s.client.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (s *redisTTLStore) put(ctx context.Context, key string, data []byte, ttl time.Duration) error {
+	return s.client.Set(ctx, key, data, ttl).Err()
+}
+
+func (s *redisTTLStore) watch(ctx context.Context, key, lockKey string) (<-chan struct{}, func(), error) {
+	sub := s.client.Subscribe(ctx, key)
+	// Wait for the subscription, so no notify after this call is missed
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return nil, nil, err
+	}
+
+	changed := make(chan struct{}, 1)
+	messages := sub.Channel()
+	go func() {
+		for range messages {
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return changed, func() { sub.Close() }, nil
+}
+
+func (s *redisTTLStore) notify(ctx context.Context, key string) error {
+	return s.client.Publish(ctx, key, "released").Err()
+}
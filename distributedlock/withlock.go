@@ -0,0 +1,111 @@
+package distributedlock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// LockErrorKind tells why WithLock failed
+type LockErrorKind string
+
+const (
+	// LockContended means the lock was held by someone else for all attempts
+	LockContended LockErrorKind = "contended"
+	// LockAcquireFailed means the backend returned an error while acquiring
+	LockAcquireFailed LockErrorKind = "acquire failed"
+	// LockLostDuringWork means the lock was lost while fn was running
+	LockLostDuringWork LockErrorKind = "lost"
+	// LockFuncFailed means fn returned an error while the lock was held
+	LockFuncFailed LockErrorKind = "func failed"
+	// LockReleaseFailed means fn succeeded but releasing the lock failed
+	LockReleaseFailed LockErrorKind = "release failed"
+)
+
+// LockError is returned by WithLock. Err is ErrLockNotAcquired for
+// contention, ErrLockLost (joined with fn's error, if any) for loss, and the
+// error of fn or the backend otherwise, so errors.Is works on all of them.
+type LockError struct {
+	Kind LockErrorKind
+	Key  string
+	Err  error
+}
+
+func (e *LockError) Error() string {
+	return fmt.Sprintf("lock %s: %s: %v", e.Key, e.Kind, e.Err)
+}
+
+func (e *LockError) Unwrap() error {
+	return e.Err
+}
+
+// WithLock runs fn while holding key on service. The lock is acquired with
+// the retry policy of opts, and fn's context is cancelled with cause
+// ErrLockLost as soon as the watchdog fails to renew the lock. The lock is
+// released when fn returns, also when it panics; the panic is passed on.
+//
+// Lock loss is reported even if fn succeeded, as its work may have
+// overlapped with another holder.
+func WithLock(ctx context.Context, service DistributedLockService, key string, opts []LockOption, fn func(ctx context.Context) error) (err error) {
+	handle := newLockHandle(service, key, opts...)
+	acquired, err := handle.Lock(ctx)
+	if err != nil {
+		return &LockError{Kind: LockAcquireFailed, Key: key, Err: err}
+	}
+	if !acquired {
+		return &LockError{Kind: LockContended, Key: key, Err: ErrLockNotAcquired}
+	}
+
+	workCtx, cancel := handle.lossContext(ctx)
+	defer cancel(nil)
+	lost := handle.info.lost
+
+	defer func() {
+		releaseErr := handle.Unlock(context.WithoutCancel(ctx))
+		if releaseErr == nil {
+			return
+		}
+		if err != nil {
+			log.Printf("Lock %s failed to release after error: %v\n", key, releaseErr)
+			return
+		}
+		err = &LockError{Kind: LockReleaseFailed, Key: key, Err: releaseErr}
+	}()
+
+	fnErr := fn(workCtx)
+	select {
+	case <-lost:
+		return &LockError{Kind: LockLostDuringWork, Key: key, Err: errors.Join(ErrLockLost, fnErr)}
+	default:
+	}
+	if fnErr != nil {
+		return &LockError{Kind: LockFuncFailed, Key: key, Err: fnErr}
+	}
+	return nil
+}
+
+// lossContext returns a context derived from ctx that is cancelled with
+// cause ErrLockLost as soon as the watchdog fails to renew the held lock
+func (h *lockHandle) lossContext(ctx context.Context) (context.Context, context.CancelCauseFunc) {
+	workCtx, cancel := context.WithCancelCause(ctx)
+	lost := h.info.lost
+	go func() {
+		select {
+		case <-lost:
+			cancel(ErrLockLost)
+		case <-workCtx.Done():
+		}
+	}()
+	return workCtx, cancel
+}
+
+// isLost reports whether the watchdog gave up the held lock
+func (h *lockHandle) isLost() bool {
+	select {
+	case <-h.info.lost:
+		return true
+	default:
+		return false
+	}
+}
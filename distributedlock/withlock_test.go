@@ -0,0 +1,91 @@
+package distributedlock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestWithLockRunsAndReleases tests that fn runs under the lock and the lock is released afterwards
+func TestWithLockRunsAndReleases(t *testing.T) {
+	lock, mr := newTestRedisLock(t)
+
+	err := WithLock(context.Background(), lock, "job", nil, func(ctx context.Context) error {
+		if !mr.Exists("job") {
+			t.Error("Expected lock to be held while fn runs")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if mr.Exists("job") {
+		t.Error("Expected lock to be released")
+	}
+}
+
+// TestWithLockErrorKinds tests that contention and fn failure are told apart
+func TestWithLockErrorKinds(t *testing.T) {
+	ctx := context.Background()
+	lock, _ := newTestRedisLock(t)
+
+	fnErr := errors.New("boom")
+	err := WithLock(ctx, lock, "job", nil, func(ctx context.Context) error { return fnErr })
+	var lockErr *LockError
+	if !errors.As(err, &lockErr) || lockErr.Kind != LockFuncFailed || !errors.Is(err, fnErr) {
+		t.Errorf("Expected func failure wrapping fn's error, got %v", err)
+	}
+
+	holder := lock.NewLock("job")
+	holder.Lock(ctx)
+	defer holder.Unlock(ctx)
+	err = WithLock(ctx, lock, "job", []LockOption{WithRetry(2, time.Millisecond)}, func(ctx context.Context) error {
+		t.Error("Expected fn not to run")
+		return nil
+	})
+	if !errors.As(err, &lockErr) || lockErr.Kind != LockContended || !errors.Is(err, ErrLockNotAcquired) {
+		t.Errorf("Expected contention, got %v", err)
+	}
+}
+
+// TestWithLockCancelsOnLoss tests that fn's context is cancelled when the watchdog loses the lock
+func TestWithLockCancelsOnLoss(t *testing.T) {
+	lock, mr := newTestRedisLock(t)
+
+	opts := []LockOption{WithExpiration(40 * time.Millisecond)}
+	err := WithLock(context.Background(), lock, "job", opts, func(ctx context.Context) error {
+		mr.Del("job")
+		select {
+		case <-ctx.Done():
+			if !errors.Is(context.Cause(ctx), ErrLockLost) {
+				t.Errorf("Expected cause ErrLockLost, got %v", context.Cause(ctx))
+			}
+			return ctx.Err()
+		case <-time.After(time.Second):
+			t.Error("Expected context to be cancelled on lock loss")
+			return nil
+		}
+	})
+	var lockErr *LockError
+	if !errors.As(err, &lockErr) || lockErr.Kind != LockLostDuringWork || !errors.Is(err, ErrLockLost) {
+		t.Errorf("Expected lock loss, got %v", err)
+	}
+}
+
+// TestWithLockReleasesOnPanic tests that the lock is released and the panic passed on
+func TestWithLockReleasesOnPanic(t *testing.T) {
+	lock, mr := newTestRedisLock(t)
+
+	defer func() {
+		if r := recover(); r != "boom" {
+			t.Errorf("Expected panic to be re-raised, got %v", r)
+		}
+		if mr.Exists("job") {
+			t.Error("Expected lock to be released after the panic")
+		}
+	}()
+	WithLock(context.Background(), lock, "job", nil, func(ctx context.Context) error {
+		panic("boom")
+	})
+}
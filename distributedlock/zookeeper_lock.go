@@ -2,89 +2,258 @@ package distributedlock
 
 import (
 	"context"
+	"log"
 	"path"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/samuel/go-zookeeper/zk"
 )
 
+// protectedPrefix marks lock nodes whose name embeds a GUID, so a create
+// interrupted by a connection loss can be found again
+const protectedPrefix = "_c_"
+
+// ZookeeperLock implements DistributedLockService with the standard
+// ZooKeeper lock recipe: every contender creates an ephemeral sequential
+// child under the lock path, the child with the lowest sequence holds the
+// lock, and everybody else watches its direct predecessor.
+//
+// Lock nodes live as long as the client session. The backend follows the
+// session events: when the session expires every node created in it is
+// marked lost at once, the watchdogs of their holders drop them, and the
+// client reconnects with a fresh session. A
+// disconnect only suspends the locks, since the session may survive it; if
+// no reconnect to the same session happens within the session timeout the
+// server will have expired it, so the locks are marked lost then as well.
 type ZookeeperLock struct {
-	conn   *zk.Conn
-	acl    []zk.ACL
-	prefix string
+	conn           zkConn
+	acl            []zk.ACL
+	prefix         string
+	waitTimeout    time.Duration
+	sessionTimeout time.Duration
+
+	mu        sync.Mutex
+	sessionID int64
+	held      map[*zookeeperHandle]struct{}
+	// orphaned are nodes given up on after a disconnect that may still exist
+	// if the session comes back after all
+	orphaned []*zookeeperHandle
+	suspend  *time.Timer
+	done     chan struct{}
+	events   chan zookeeperSessionEvent
+}
+
+// zookeeperSessionEvent is a session state change together with the client's
+// session at the time it happened
+type zookeeperSessionEvent struct {
+	state     zk.State
+	sessionID int64
+}
+
+// zkConn is the part of *zk.Conn the lock uses
+type zkConn interface {
+	Exists(path string) (bool, *zk.Stat, error)
+	ExistsW(path string) (bool, *zk.Stat, <-chan zk.Event, error)
+	Create(path string, data []byte, flags int32, acl []zk.ACL) (string, error)
+	Children(path string) ([]string, *zk.Stat, error)
+	Get(path string) ([]byte, *zk.Stat, error)
+	Delete(path string, version int32) error
+	SessionID() int64
+	AddAuth(scheme string, auth []byte) error
+	Close()
+}
+
+// zookeeperHandle is the ZooKeeper-private state of a contender: the
+// sequential node it created, kept across retries so it keeps its place
+type zookeeperHandle struct {
+	node      string
+	value     string
+	sessionID int64
+	// lost is closed once the session that owns node is gone
+	lost     chan struct{}
+	lostOnce sync.Once
 }
 
 func NewZookeeperLock(servers []string, sessionTimeout time.Duration, prefix string) (*ZookeeperLock, error) {
-	conn, _, err := zk.Connect(servers, sessionTimeout)
+	// Default ACL gives all permissions to anyone (not suitable for production)
+	acl := zk.WorldACL(zk.PermAll)
+
+	z := &ZookeeperLock{
+		acl:            acl,
+		prefix:         "/" + strings.Trim(prefix, "/"),
+		sessionTimeout: sessionTimeout,
+		held:           make(map[*zookeeperHandle]struct{}),
+		done:           make(chan struct{}),
+		events:         make(chan zookeeperSessionEvent, 16),
+	}
+
+	// The callback runs on the connection's goroutine right after it switched
+	// sessions, so the session ID read there belongs to the event
+	connected := make(chan struct{})
+	var conn *zk.Conn
+	onEvent := func(ev zk.Event) {
+		if ev.Type != zk.EventSession {
+			return
+		}
+		<-connected
+		select {
+		case z.events <- zookeeperSessionEvent{state: ev.State, sessionID: conn.SessionID()}:
+		case <-z.done:
+		}
+	}
+	conn, _, err := zk.Connect(servers, sessionTimeout, zk.WithEventCallback(onEvent))
 	if err != nil {
 		return nil, err
 	}
+	z.conn = conn
+	close(connected)
 
-	// Default ACL gives all permissions to anyone (not suitable for production)
-	acl := zk.WorldACL(zk.PermAll)
+	go z.watchSession()
+	return z, nil
+}
 
-	return &ZookeeperLock{
-		conn:   conn,
-		acl:    acl,
-		prefix: strings.TrimRight(prefix, "/") + "/",
-	}, nil
+// SetAuth authenticates the session with digest credentials. The client
+// re-sends them automatically after reconnecting.
+func (z *ZookeeperLock) SetAuth(username, password string) error {
+	return z.conn.AddAuth("digest", []byte(username+":"+password))
+}
+
+// SetACL sets the ACL used for lock nodes and the parent nodes created for them
+func (z *ZookeeperLock) SetACL(acl []zk.ACL) {
+	z.acl = acl
+}
+
+// SetWaitTimeout sets how long one acquire attempt waits for its predecessor
+// to go away. The default of 0 only checks once and leaves waiting to the
+// retry policy; the contender node is kept between attempts either way.
+func (z *ZookeeperLock) SetWaitTimeout(timeout time.Duration) {
+	z.waitTimeout = timeout
 }
 
 func (z *ZookeeperLock) AcquireLock(ctx context.Context, lockInfo *DistributedLockInfo) (bool, error) {
-	// Create the lock node
-	path := z.prefix + lockInfo.key
+	dir := z.lockDir(lockInfo.key)
 
-	// Create parent nodes if they don't exist
-	if err := z.ensurePath(path); err != nil {
-		return false, err
+	// Reuse the node of an earlier attempt if it survived
+	h, _ := lockInfo.handle.(*zookeeperHandle)
+	if h != nil && h.isLost() {
+		h = nil
+	}
+	if h != nil {
+		exists, _, err := z.conn.Exists(h.node)
+		if err != nil {
+			return false, err
+		}
+		if !exists {
+			z.forget(h)
+			h = nil
+		}
+	}
+	if h == nil {
+		// Create parent nodes if they don't exist
+		if err := z.ensurePath(dir); err != nil {
+			return false, err
+		}
+		sessionID := z.conn.SessionID()
+		node, err := z.createLockNode(ctx, dir, lockInfo.value)
+		if err != nil {
+			return false, err
+		}
+		h = z.track(node, lockInfo.value, sessionID)
+		lockInfo.handle = h
 	}
 
-	// Create an ephemeral sequential node
-	_, err := z.conn.Create(path, []byte(lockInfo.value), zk.FlagEphemeral, z.acl)
-	if err != nil {
-		if err == zk.ErrNodeExists {
+	var deadline <-chan time.Time
+	if z.waitTimeout > 0 {
+		timer := time.NewTimer(z.waitTimeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	for {
+		predecessor, err := z.predecessor(dir, h.node)
+		if err == ErrLockLost {
+			// Our node vanished (e.g. the session expired); start over next attempt
+			z.forget(h)
+			lockInfo.handle = nil
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if predecessor == "" {
+			return true, nil
+		}
+		if deadline == nil {
 			return false, nil
 		}
-		return false, err
-	}
 
-	return true, nil
+		exists, _, watch, err := z.conn.ExistsW(predecessor)
+		if err != nil {
+			return false, err
+		}
+		if !exists {
+			continue
+		}
+		select {
+		case <-watch:
+		case <-h.lost:
+			z.forget(h)
+			lockInfo.handle = nil
+			return false, nil
+		case <-deadline:
+			return false, nil
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
 }
 
-func (z *ZookeeperLock) ReleaseLock(ctx context.Context, lockInfo *DistributedLockInfo) (bool, error) {
-	path := z.prefix + lockInfo.key
-
-	// Check if the node exists
-	exists, _, err := z.conn.Exists(path)
-	if err != nil {
-		return false, err
+// CancelWait deletes the contender node of a caller that gave up waiting
+func (z *ZookeeperLock) CancelWait(ctx context.Context, lockInfo *DistributedLockInfo) error {
+	if _, ok := lockInfo.handle.(*zookeeperHandle); !ok {
+		return nil
 	}
+	_, err := z.ReleaseLock(ctx, lockInfo)
+	return err
+}
 
-	if !exists {
-		return false, nil
+func (z *ZookeeperLock) ReleaseLock(ctx context.Context, lockInfo *DistributedLockInfo) (bool, error) {
+	h, ok := lockInfo.handle.(*zookeeperHandle)
+	if !ok {
+		return false, ErrLockNotHeld
 	}
 
-	// Delete the node to release the lock
-	err = z.conn.Delete(path, -1)
+	deleted, err := z.deleteNode(h.node, lockInfo.value)
 	if err != nil {
 		return false, err
 	}
-
-	return true, nil
+	z.forget(h)
+	lockInfo.handle = nil
+	return deleted && !h.isLost(), nil
 }
 
 func (z *ZookeeperLock) RenewLock(ctx context.Context, lockInfo *DistributedLockInfo) error {
-	path := z.publicPath(lockInfo.key)
+	h, ok := lockInfo.handle.(*zookeeperHandle)
+	if !ok {
+		return ErrLockNotHeld
+	}
+	// Session events may already have told us, without a round trip
+	if h.isLost() {
+		return ErrLockLost
+	}
 
-	// Check if the lock still exists
-	exists, _, err := z.conn.Exists(path)
+	// Check if the lock node still exists
+	exists, _, err := z.conn.Exists(h.node)
 	if err != nil {
 		return err
 	}
 
 	if !exists {
-		return ErrLockNotHeld
+		return ErrLockLost
 	}
 
 	// In ZooKeeper, ephemeral nodes are automatically removed when the session ends
@@ -92,18 +261,153 @@ func (z *ZookeeperLock) RenewLock(ctx context.Context, lockInfo *DistributedLock
 	return nil
 }
 
+// NewLock returns a lock handle bound to this ZooKeeper ensemble
+func (z *ZookeeperLock) NewLock(key string, opts ...LockOption) Locker {
+	return newLockHandle(z, key, opts...)
+}
+
 func (z *ZookeeperLock) BuildServiceType() string {
 	return "zookeeper"
 }
 
-// ensurePath creates all nodes in the path if they don't exist
+// deleteNode deletes node if it still carries value and reports whether it did
+func (z *ZookeeperLock) deleteNode(node, value string) (bool, error) {
+	// Only delete the node if it is still the one we created
+	data, stat, err := z.conn.Get(node)
+	if err == zk.ErrNoNode {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if string(data) != value {
+		return false, nil
+	}
+
+	err = z.conn.Delete(node, stat.Version)
+	if err == zk.ErrNoNode {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// createLockNode creates a protected ephemeral sequential node under dir.
+// If the connection drops during the create, the request may or may not
+// have been applied; the GUID in the node name lets us find out which.
+func (z *ZookeeperLock) createLockNode(ctx context.Context, dir, value string) (string, error) {
+	guid := newLockToken()
+	name := dir + "/" + protectedPrefix + guid + "-lock-"
+
+	for attempt := 0; attempt < 3; attempt++ {
+		node, err := z.conn.Create(name, []byte(value), zk.FlagEphemeral|zk.FlagSequence, z.acl)
+		switch err {
+		case nil:
+			return node, nil
+		case zk.ErrSessionExpired:
+			// A node created in the expired session is already gone
+		case zk.ErrConnectionClosed:
+			node, err := z.findLockNode(ctx, dir, guid)
+			if err != nil || node != "" {
+				return node, err
+			}
+		default:
+			return "", err
+		}
+	}
+	return "", zk.ErrConnectionClosed
+}
+
+// findLockNode looks for the node carrying guid once the connection is back
+func (z *ZookeeperLock) findLockNode(ctx context.Context, dir, guid string) (string, error) {
+	for {
+		children, _, err := z.conn.Children(dir)
+		if err == nil {
+			for _, child := range children {
+				if strings.HasPrefix(child, protectedPrefix+guid) {
+					return dir + "/" + child, nil
+				}
+			}
+			return "", nil
+		}
+		if err != zk.ErrConnectionClosed {
+			return "", err
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// predecessor returns the contender directly in front of node, or "" if
+// node has the lowest sequence and therefore holds the lock. Other children
+// of dir, such as the directories of nested keys, are not contenders.
+func (z *ZookeeperLock) predecessor(dir, node string) (string, error) {
+	all, _, err := z.conn.Children(dir)
+	if err != nil {
+		return "", err
+	}
+	children := all[:0]
+	for _, child := range all {
+		if isLockNode(child) {
+			children = append(children, child)
+		}
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return nodeSequence(children[i]) < nodeSequence(children[j])
+	})
+
+	own := path.Base(node)
+	prev := ""
+	for _, child := range children {
+		if child == own {
+			if prev == "" {
+				return "", nil
+			}
+			return dir + "/" + prev, nil
+		}
+		prev = child
+	}
+	return "", ErrLockLost
+}
+
+// nodeSequence extracts the sequence number ZooKeeper appends to sequential nodes
+func nodeSequence(name string) int64 {
+	if len(name) < 10 {
+		return -1
+	}
+	seq, err := strconv.ParseInt(name[len(name)-10:], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return seq
+}
+
+// isLockNode reports whether name is a contender node created by
+// createLockNode, i.e. _c_<guid>-lock-<sequence>
+func isLockNode(name string) bool {
+	return strings.HasPrefix(name, protectedPrefix) &&
+		strings.HasSuffix(name[:max(len(name)-10, 0)], "-lock-") &&
+		nodeSequence(name) >= 0
+}
+
+// ensurePath creates all nodes in the path if they don't exist. Existing
+// nodes are only checked, so parents whose ACL denies create don't fail.
 func (z *ZookeeperLock) ensurePath(fullPath string) error {
 	nodes := strings.Split(strings.Trim(fullPath, "/"), "/")
-	currentPath := ""
+	currentPath := "/"
 
 	for _, node := range nodes {
 		currentPath = path.Join(currentPath, node)
-		_, err := z.conn.Create(currentPath, []byte{}, 0, z.acl)
+		exists, _, err := z.conn.Exists(currentPath)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		_, err = z.conn.Create(currentPath, []byte{}, 0, z.acl)
 		if err != nil && err != zk.ErrNodeExists {
 			return err
 		}
@@ -112,12 +416,140 @@ func (z *ZookeeperLock) ensurePath(fullPath string) error {
 	return nil
 }
 
-// publicPath returns the full path for a lock
-func (z *ZookeeperLock) publicPath(lockName string) string {
+// lockDir returns the path under which contenders for a lock create their nodes
+func (z *ZookeeperLock) lockDir(lockName string) string {
 	return path.Join(z.prefix, lockName)
 }
 
+// track registers a node created in session sessionID
+func (z *ZookeeperLock) track(node, value string, sessionID int64) *zookeeperHandle {
+	h := &zookeeperHandle{node: node, value: value, sessionID: sessionID, lost: make(chan struct{})}
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if z.held == nil {
+		z.held = make(map[*zookeeperHandle]struct{})
+	}
+	z.held[h] = struct{}{}
+	return h
+}
+
+// forget stops following h
+func (z *ZookeeperLock) forget(h *zookeeperHandle) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	delete(z.held, h)
+}
+
+// watchSession consumes the session events of the connection
+func (z *ZookeeperLock) watchSession() {
+	for {
+		select {
+		case ev := <-z.events:
+			z.handleSessionEvent(ev.state, ev.sessionID)
+		case <-z.done:
+			return
+		}
+	}
+}
+
+// handleSessionEvent updates the held locks for a session state change;
+// sessionID is the client's session at the time of the event
+func (z *ZookeeperLock) handleSessionEvent(state zk.State, sessionID int64) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	switch state {
+	case zk.StateDisconnected:
+		// The session may still be alive; give it the session timeout to come back
+		if z.suspend == nil && len(z.held) > 0 {
+			z.suspend = time.AfterFunc(z.sessionTimeout, func() {
+				z.mu.Lock()
+				defer z.mu.Unlock()
+				z.suspend = nil
+				z.loseHandles("disconnected for longer than the session timeout", true)
+			})
+		}
+	case zk.StateExpired:
+		// The client reconnects with a fresh session on its own
+		z.stopSuspend()
+		z.orphaned = nil
+		z.loseHandles("session expired", false)
+	case zk.StateHasSession:
+		z.stopSuspend()
+		if sessionID != z.sessionID && z.sessionID != 0 {
+			// A new session: everything created in an older one is gone
+			z.orphaned = nil
+			for h := range z.held {
+				if h.sessionID != sessionID {
+					h.markLost()
+					delete(z.held, h)
+				}
+			}
+		} else if len(z.orphaned) > 0 {
+			// The session survived after all; clean up the nodes we gave up on
+			orphaned := z.orphaned
+			z.orphaned = nil
+			go func() {
+				for _, h := range orphaned {
+					z.deleteNode(h.node, h.value)
+				}
+			}()
+		}
+		z.sessionID = sessionID
+	}
+}
+
+// loseHandles marks every followed node lost. Nodes that may still exist
+// are kept as orphaned when keepOrphans is set. Must be called with z.mu held.
+func (z *ZookeeperLock) loseHandles(reason string, keepOrphans bool) {
+	if len(z.held) == 0 {
+		return
+	}
+	log.Printf("ZookeeperLock: %s, %d lock node(s) lost\n", reason, len(z.held))
+	for h := range z.held {
+		h.markLost()
+		if keepOrphans {
+			z.orphaned = append(z.orphaned, h)
+		}
+	}
+	z.held = make(map[*zookeeperHandle]struct{})
+}
+
+func (z *ZookeeperLock) stopSuspend() {
+	if z.suspend != nil {
+		z.suspend.Stop()
+		z.suspend = nil
+	}
+}
+
+func (h *zookeeperHandle) markLost() {
+	h.lostOnce.Do(func() { close(h.lost) })
+}
+
+// lostSignal lets the watchdog of the lock holder react to session loss at once
+func (h *zookeeperHandle) lostSignal() <-chan struct{} {
+	return h.lost
+}
+
+func (h *zookeeperHandle) isLost() bool {
+	select {
+	case <-h.lost:
+		return true
+	default:
+		return false
+	}
+}
+
 // Close closes the ZooKeeper connection
-func (z *ZookeeperLock) Close() {
+func (z *ZookeeperLock) Close() error {
+	z.mu.Lock()
+	z.stopSuspend()
+	z.mu.Unlock()
+	select {
+	case <-z.done:
+	default:
+		close(z.done)
+	}
 	z.conn.Close()
+	return nil
 }
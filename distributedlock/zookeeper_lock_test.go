@@ -0,0 +1,470 @@
+package distributedlock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// TestNodeSequenceOrder tests that contenders are ordered by sequence, not by GUID
+func TestNodeSequenceOrder(t *testing.T) {
+	children := []string{
+		"_c_ffff-lock-0000000012",
+		"_c_0000-lock-0000000013",
+		"_c_aaaa-lock-0000000002",
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return nodeSequence(children[i]) < nodeSequence(children[j])
+	})
+
+	want := []string{"_c_aaaa-lock-0000000002", "_c_ffff-lock-0000000012", "_c_0000-lock-0000000013"}
+	for i := range want {
+		if children[i] != want[i] {
+			t.Fatalf("Expected order %v, got %v", want, children)
+		}
+	}
+	if nodeSequence("short") != -1 {
+		t.Error("Expected -1 for a name without sequence")
+	}
+}
+
+// TestZookeeperACL tests building node ACLs from configuration
+func TestZookeeperACL(t *testing.T) {
+	acl, err := zookeeperACL(ZooKeeperConfig{})
+	if err != nil || len(acl) != 1 || acl[0].Scheme != "world" || acl[0].Perms != zk.PermAll {
+		t.Errorf("Expected world:anyone:all by default, got %v, %v", acl, err)
+	}
+
+	acl, err = zookeeperACL(ZooKeeperConfig{Username: "locker", Password: "secret"})
+	if err != nil || len(acl) != 1 || acl[0].Scheme != "auth" {
+		t.Errorf("Expected auth ACL for an authenticated client, got %v, %v", acl, err)
+	}
+
+	acl, err = zookeeperACL(ZooKeeperConfig{ACL: []ZooKeeperACLEntry{
+		{Scheme: "digest", ID: "locker:secret", Perms: []string{"all"}},
+		{Scheme: "ip", ID: "10.0.0.0/8", Perms: []string{"read", "write"}},
+	}})
+	if err != nil {
+		t.Fatalf("Failed to build ACL: %v", err)
+	}
+	if len(acl) != 2 {
+		t.Fatalf("Expected 2 ACL entries, got %d", len(acl))
+	}
+	if acl[0].Scheme != "digest" || !strings.HasPrefix(acl[0].ID, "locker:") || acl[0].ID == "locker:secret" {
+		t.Errorf("Expected hashed digest id, got %s:%s", acl[0].Scheme, acl[0].ID)
+	}
+	if acl[1].Perms != zk.PermRead|zk.PermWrite {
+		t.Errorf("Expected read|write perms, got %d", acl[1].Perms)
+	}
+
+	for _, entry := range []ZooKeeperACLEntry{
+		{Scheme: "digest", ID: "nopassword", Perms: []string{"all"}},
+		{Scheme: "world", ID: "anyone", Perms: []string{"fly"}},
+		{Scheme: "sasl", ID: "user", Perms: []string{"all"}},
+	} {
+		if _, err := zookeeperACL(ZooKeeperConfig{ACL: []ZooKeeperACLEntry{entry}}); err == nil {
+			t.Errorf("Expected error for ACL entry %+v", entry)
+		}
+	}
+}
+
+// TestZookeeperSessionEvents tests that session events mark held lock nodes lost
+func TestZookeeperSessionEvents(t *testing.T) {
+	z := &ZookeeperLock{sessionTimeout: 50 * time.Millisecond}
+	z.handleSessionEvent(zk.StateHasSession, 1)
+
+	// An expired session loses every node at once
+	expired := z.track("/locks/a/_c_1-lock-0000000001", "owner", 1)
+	z.handleSessionEvent(zk.StateExpired, 0)
+	if !expired.isLost() {
+		t.Error("Expected node to be lost after session expiry")
+	}
+	lockInfo := NewDistributedLockInfo("a", "owner", 30*time.Second)
+	lockInfo.handle = expired
+	if err := z.RenewLock(context.Background(), lockInfo); !errors.Is(err, ErrLockLost) {
+		t.Errorf("Expected ErrLockLost on renew, got %v", err)
+	}
+
+	// A new session loses nodes of the old one but keeps its own
+	old := z.track("/locks/b/_c_2-lock-0000000002", "owner", 1)
+	current := z.track("/locks/c/_c_3-lock-0000000003", "owner", 2)
+	z.handleSessionEvent(zk.StateHasSession, 2)
+	if !old.isLost() || current.isLost() {
+		t.Errorf("Expected only the old session's node lost, got old=%v current=%v", old.isLost(), current.isLost())
+	}
+
+	// A short disconnect of the same session keeps the locks
+	z.handleSessionEvent(zk.StateDisconnected, 2)
+	z.handleSessionEvent(zk.StateHasSession, 2)
+	time.Sleep(100 * time.Millisecond)
+	if current.isLost() {
+		t.Error("Expected node to survive a short disconnect")
+	}
+
+	// A disconnect longer than the session timeout loses them
+	z.handleSessionEvent(zk.StateDisconnected, 2)
+	time.Sleep(100 * time.Millisecond)
+	if !current.isLost() {
+		t.Error("Expected node to be lost after a long disconnect")
+	}
+}
+
+// fakeZK is an in-memory zkConn with sequential nodes and exists watches
+type fakeZK struct {
+	mu       sync.Mutex
+	nodes    map[string][]byte
+	versions map[string]int32
+	seq      map[string]int
+	watches  map[string][]chan zk.Event
+	// dropCreateReply applies the next create but reports a lost connection
+	dropCreateReply bool
+	// childrenErrs are returned by the next Children calls
+	childrenErrs []error
+	// readOnly nodes refuse creating children, as with an ACL without create
+	readOnly map[string]bool
+}
+
+func newFakeZookeeper(t *testing.T) (*fakeZK, *ZookeeperLock) {
+	t.Helper()
+	fake := &fakeZK{
+		nodes:    map[string][]byte{"/": nil},
+		versions: make(map[string]int32),
+		seq:      make(map[string]int),
+		watches:  make(map[string][]chan zk.Event),
+	}
+	z := &ZookeeperLock{
+		conn:   fake,
+		acl:    zk.WorldACL(zk.PermAll),
+		prefix: "/locks",
+		held:   make(map[*zookeeperHandle]struct{}),
+		done:   make(chan struct{}),
+	}
+	t.Cleanup(func() { z.Close() })
+	return fake, z
+}
+
+func (f *fakeZK) Exists(p string) (bool, *zk.Stat, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.nodes[p]
+	return ok, &zk.Stat{Version: f.versions[p]}, nil
+}
+
+func (f *fakeZK) ExistsW(p string) (bool, *zk.Stat, <-chan zk.Event, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	watch := make(chan zk.Event, 1)
+	f.watches[p] = append(f.watches[p], watch)
+	_, ok := f.nodes[p]
+	return ok, &zk.Stat{Version: f.versions[p]}, watch, nil
+}
+
+func (f *fakeZK) Create(p string, data []byte, flags int32, acl []zk.ACL) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	parent := path.Dir(p)
+	if _, ok := f.nodes[parent]; !ok {
+		return "", zk.ErrNoNode
+	}
+	if f.readOnly[parent] {
+		return "", zk.ErrNoAuth
+	}
+	if flags&zk.FlagSequence != 0 {
+		p += fmt.Sprintf("%010d", f.seq[parent])
+		f.seq[parent]++
+	}
+	if _, ok := f.nodes[p]; ok {
+		return "", zk.ErrNodeExists
+	}
+	f.nodes[p] = data
+	if f.dropCreateReply {
+		f.dropCreateReply = false
+		return "", zk.ErrConnectionClosed
+	}
+	return p, nil
+}
+
+func (f *fakeZK) Children(p string) ([]string, *zk.Stat, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.childrenErrs) > 0 {
+		err := f.childrenErrs[0]
+		f.childrenErrs = f.childrenErrs[1:]
+		return nil, nil, err
+	}
+	var children []string
+	for node := range f.nodes {
+		if node != "/" && path.Dir(node) == p {
+			children = append(children, path.Base(node))
+		}
+	}
+	return children, &zk.Stat{}, nil
+}
+
+func (f *fakeZK) Get(p string) ([]byte, *zk.Stat, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.nodes[p]
+	if !ok {
+		return nil, nil, zk.ErrNoNode
+	}
+	return data, &zk.Stat{Version: f.versions[p]}, nil
+}
+
+func (f *fakeZK) Delete(p string, version int32) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.nodes[p]; !ok {
+		return zk.ErrNoNode
+	}
+	if version != -1 && version != f.versions[p] {
+		return zk.ErrBadVersion
+	}
+	delete(f.nodes, p)
+	delete(f.versions, p)
+	for _, watch := range f.watches[p] {
+		watch <- zk.Event{Type: zk.EventNodeDeleted, Path: p}
+	}
+	delete(f.watches, p)
+	return nil
+}
+
+func (f *fakeZK) SessionID() int64                         { return 1 }
+func (f *fakeZK) AddAuth(scheme string, auth []byte) error { return nil }
+func (f *fakeZK) Close()                                   {}
+
+// set replaces the data of a node, bumping its version
+func (f *fakeZK) set(p string, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nodes[p] = data
+	f.versions[p]++
+}
+
+// watched reports whether somebody waits for p to change
+func (f *fakeZK) watched(p string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.watches[p]) > 0
+}
+
+// children returns the nodes under p
+func (f *fakeZK) children(p string) []string {
+	children, _, _ := f.Children(p)
+	return children
+}
+
+// TestZookeeperWaitsOnPredecessor tests that a contender watches the node in
+// front of it and takes the lock once that node is deleted
+func TestZookeeperWaitsOnPredecessor(t *testing.T) {
+	ctx := context.Background()
+	fake, z := newFakeZookeeper(t)
+	z.SetWaitTimeout(5 * time.Second)
+
+	first := NewDistributedLockInfo("jobs", "first", 30*time.Second)
+	second := NewDistributedLockInfo("jobs", "second", 30*time.Second)
+	third := NewDistributedLockInfo("jobs", "third", 30*time.Second)
+	if ok, err := z.AcquireLock(ctx, first); err != nil || !ok {
+		t.Fatalf("Expected first to acquire the lock, got %v, %v", ok, err)
+	}
+
+	results := make(chan string, 2)
+	contend := func(info *DistributedLockInfo, queued int) {
+		go func() {
+			if ok, err := z.AcquireLock(ctx, info); err == nil && ok {
+				results <- info.value
+			}
+		}()
+		// Wait for its node so the contenders queue in a known order
+		for len(fake.children("/locks/jobs")) < queued {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	contend(second, 2)
+	contend(third, 3)
+
+	firstNode := first.handle.(*zookeeperHandle).node
+	deadline := time.Now().Add(time.Second)
+	for !fake.watched(firstNode) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if !fake.watched(firstNode) {
+		t.Fatal("Expected the next contender to watch the holder's node")
+	}
+
+	if ok, err := z.ReleaseLock(ctx, first); err != nil || !ok {
+		t.Fatalf("Expected to release the lock, got %v, %v", ok, err)
+	}
+	select {
+	case got := <-results:
+		if got != "second" {
+			t.Errorf("Expected second to take the lock, got %s", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a waiting contender to take the lock")
+	}
+	select {
+	case got := <-results:
+		t.Errorf("Expected only one contender to hold the lock, %s acquired as well", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// TestZookeeperReleaseVerifiesOwner tests that release leaves a node whose data changed alone
+func TestZookeeperReleaseVerifiesOwner(t *testing.T) {
+	ctx := context.Background()
+	fake, z := newFakeZookeeper(t)
+
+	info := NewDistributedLockInfo("jobs", "owner", 30*time.Second)
+	if ok, err := z.AcquireLock(ctx, info); err != nil || !ok {
+		t.Fatalf("Expected to acquire the lock, got %v, %v", ok, err)
+	}
+	node := info.handle.(*zookeeperHandle).node
+	fake.set(node, []byte("intruder"))
+
+	if ok, err := z.ReleaseLock(ctx, info); err != nil || ok {
+		t.Errorf("Expected release of a foreign node to report false, got %v, %v", ok, err)
+	}
+	if exists, _, _ := fake.Exists(node); !exists {
+		t.Error("Expected the foreign node to be kept")
+	}
+}
+
+// TestZookeeperFindsNodeAfterConnectionLoss tests that a create applied
+// before the connection dropped is found by its GUID instead of duplicated
+func TestZookeeperFindsNodeAfterConnectionLoss(t *testing.T) {
+	ctx := context.Background()
+	fake, z := newFakeZookeeper(t)
+	if err := z.ensurePath("/locks/jobs"); err != nil {
+		t.Fatalf("Failed to create lock dir: %v", err)
+	}
+	fake.dropCreateReply = true
+	fake.childrenErrs = []error{zk.ErrConnectionClosed}
+
+	info := NewDistributedLockInfo("jobs", "owner", 30*time.Second)
+	if ok, err := z.AcquireLock(ctx, info); err != nil || !ok {
+		t.Fatalf("Expected to acquire the lock, got %v, %v", ok, err)
+	}
+	children := fake.children("/locks/jobs")
+	if len(children) != 1 {
+		t.Fatalf("Expected exactly one contender node, got %v", children)
+	}
+	if node := info.handle.(*zookeeperHandle).node; node != "/locks/jobs/"+children[0] {
+		t.Errorf("Expected the handle to own %s, got %s", children[0], node)
+	}
+}
+
+// TestZookeeperCancelWait tests that giving up deletes the contender node
+func TestZookeeperCancelWait(t *testing.T) {
+	ctx := context.Background()
+	fake, z := newFakeZookeeper(t)
+
+	holder := NewDistributedLockInfo("jobs", "holder", 30*time.Second)
+	waiter := NewDistributedLockInfo("jobs", "waiter", 30*time.Second)
+	z.AcquireLock(ctx, holder)
+	if ok, err := z.AcquireLock(ctx, waiter); err != nil || ok {
+		t.Fatalf("Expected waiter to queue, got %v, %v", ok, err)
+	}
+	if n := len(fake.children("/locks/jobs")); n != 2 {
+		t.Fatalf("Expected the waiter's node to be kept between attempts, got %d nodes", n)
+	}
+
+	if err := z.CancelWait(ctx, waiter); err != nil {
+		t.Fatalf("Expected CancelWait to succeed, got %v", err)
+	}
+	if n := len(fake.children("/locks/jobs")); n != 1 || waiter.handle != nil {
+		t.Errorf("Expected the waiter's node to be deleted, got %d nodes, handle %v", n, waiter.handle)
+	}
+	if err := z.CancelWait(ctx, waiter); err != nil {
+		t.Errorf("Expected CancelWait without a node to be a no-op, got %v", err)
+	}
+	if err := z.RenewLock(ctx, holder); err != nil {
+		t.Errorf("Expected the holder to keep the lock, got %v", err)
+	}
+}
+
+// TestZookeeperEnsurePathUnderReadOnlyParent tests that existing parents are
+// not re-created, so an ACL without create on them does not fail the lock
+func TestZookeeperEnsurePathUnderReadOnlyParent(t *testing.T) {
+	ctx := context.Background()
+	fake, z := newFakeZookeeper(t)
+	fake.nodes["/locks"] = nil
+	fake.nodes["/locks/jobs"] = nil
+	fake.readOnly = map[string]bool{"/": true, "/locks": true}
+
+	info := NewDistributedLockInfo("jobs", "owner", 30*time.Second)
+	if ok, err := z.AcquireLock(ctx, info); err != nil || !ok {
+		t.Fatalf("Expected to acquire under existing parents, got %v, %v", ok, err)
+	}
+
+	other := NewDistributedLockInfo("reports", "owner", 30*time.Second)
+	if _, err := z.AcquireLock(ctx, other); err != zk.ErrNoAuth {
+		t.Errorf("Expected ErrNoAuth for a missing node under a read-only parent, got %v", err)
+	}
+}
+
+// TestZookeeperSessionLossReachesHolder tests that a session expiry drops a
+// held lock right away instead of at the next renewal
+func TestZookeeperSessionLossReachesHolder(t *testing.T) {
+	ctx := context.Background()
+	_, z := newFakeZookeeper(t)
+	z.handleSessionEvent(zk.StateHasSession, 1)
+
+	lock := z.NewLock("jobs", WithExpiration(time.Hour)).(*lockHandle)
+	if ok, err := lock.Lock(ctx); err != nil || !ok {
+		t.Fatalf("Expected to acquire the lock, got %v, %v", ok, err)
+	}
+	lost := lock.info.lost
+
+	z.handleSessionEvent(zk.StateExpired, 0)
+	select {
+	case <-lost:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the holder to be told about the expired session")
+	}
+	if lock.Locked() {
+		t.Error("Expected the lock to no longer be held")
+	}
+}
+
+// TestZookeeperNestedKeys tests that the directory of a nested key is not
+// taken for a contender of its parent key
+func TestZookeeperNestedKeys(t *testing.T) {
+	ctx := context.Background()
+	_, z := newFakeZookeeper(t)
+
+	child := NewDistributedLockInfo("jobs/nightly", "child", 30*time.Second)
+	parent := NewDistributedLockInfo("jobs", "parent", 30*time.Second)
+	if ok, err := z.AcquireLock(ctx, child); err != nil || !ok {
+		t.Fatalf("Expected to acquire the nested key, got %v, %v", ok, err)
+	}
+	if ok, err := z.AcquireLock(ctx, parent); err != nil || !ok {
+		t.Fatalf("Expected to acquire the parent key while the nested key is held, got %v, %v", ok, err)
+	}
+	z.ReleaseLock(ctx, parent)
+	z.ReleaseLock(ctx, child)
+
+	// The nested key's directory stays after release
+	if ok, err := z.AcquireLock(ctx, parent); err != nil || !ok {
+		t.Errorf("Expected to acquire the parent key after release, got %v, %v", ok, err)
+	}
+
+	for name, want := range map[string]bool{
+		"_c_ab12-lock-0000000007": true,
+		"nightly":                 false,
+		"_c_ab12-lock-":           false,
+		"nightly-lock-0000000007": false,
+	} {
+		if got := isLockNode(name); got != want {
+			t.Errorf("isLockNode(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -14,11 +14,17 @@ import (
 // distributed lock main function
 func main() {
 	// 加载配置
-	_, err := config.LoadConfig("")
+	cfg, err := config.LoadConfig(config.GetConfigPath())
 	if err != nil {
 		panic(fmt.Sprintf("加载配置失败: %v", err))
 	}
 
+	// 按配置注册所有启用的锁后端
+	if err := distributedlock.RegisterFromConfig(cfg); err != nil {
+		panic(fmt.Sprintf("注册锁服务失败: %v", err))
+	}
+	defer distributedlock.CloseServices()
+
 	// 创建一个 Redis 后端的锁
 	lock := distributedlock.NewDistributedLockInfo(
 		"my-resource",  // 锁的键